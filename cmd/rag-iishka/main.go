@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -9,12 +10,17 @@ import (
 
 	"rag-iishka/internal/api"
 	"rag-iishka/internal/api/handlers"
+	"rag-iishka/internal/importer"
 	"rag-iishka/internal/repository"
 	"rag-iishka/internal/service"
 	"rag-iishka/pkg/auth"
 	"rag-iishka/pkg/config"
+	"rag-iishka/pkg/imagefile"
+	"rag-iishka/pkg/llm"
 	"rag-iishka/pkg/logger"
+	"rag-iishka/pkg/ocr"
 	"rag-iishka/pkg/postgres"
+	"rag-iishka/pkg/storage"
 
 	"go.uber.org/zap"
 )
@@ -69,7 +75,11 @@ func main() {
 	docRepo := repository.NewDocumentRepository(db, appLogger)
 	txRepo := repository.NewTransactionRepository(db, appLogger)
 	recRepo := repository.NewRecommendationRepository(db, appLogger)
+	failRepo := repository.NewValidationFailureRepository(db, appLogger)
+	receiptRepo := repository.NewFiscalReceiptRepository(db, appLogger)
+	jobRepo := repository.NewJobRepository(db, appLogger)
 	knowledgeRepo := repository.NewKnowledgeRepository(db, appLogger)
+	transactor := repository.NewTransactor(db)
 
 	// Initialize JWT manager
 	jwtManager := auth.NewJWTManager(cfg.JWT.SecretKey, cfg.JWT.Expiration, cfg.JWT.RefreshExp)
@@ -77,26 +87,58 @@ func main() {
 	// Initialize services
 	authService := service.NewAuthService(userRepo, jwtManager, appLogger)
 
-	llmService, err := service.NewLLMService(&cfg.GigaChat, appLogger)
+	llmProvider, err := llm.NewProvider(cfg, appLogger)
 	if err != nil {
-		appLogger.Fatal("Failed to initialize LLM service", zap.Error(err))
+		appLogger.Fatal("Failed to initialize LLM provider", zap.Error(err))
 	}
+	llmService := service.NewLLMService(llmProvider, appLogger)
 	defer llmService.Close()
 
-	ocrService := service.NewOCRService(llmService, appLogger)
+	ocrEngine, err := ocr.NewEngine(&cfg.OCR, llmService, appLogger)
+	if err != nil {
+		appLogger.Fatal("Failed to initialize OCR engine", zap.Error(err))
+	}
+	ocrService := service.NewOCRService(ocrEngine, imagefile.NewDecoder(cfg.OCR.HEICConverter, appLogger), appLogger)
+
+	ragService := service.NewRAGService(knowledgeRepo, llmService, &cfg.RAG, appLogger)
+	// Vectors of another size cannot be stored or searched; an unavailable model only disables vector search
+	if err := ragService.CheckEmbeddingModel(ctx); errors.Is(err, service.ErrEmbeddingDimensions) {
+		appLogger.Fatal("Invalid RAG_EMBEDDING_MODEL", zap.Error(err))
+	} else if err != nil {
+		appLogger.Warn("Failed to check the embedding model", zap.Error(err))
+	}
+	recService := service.NewRecommendationService(llmService, ragService, recRepo, &cfg.Recommendations, appLogger)
 
-	ragService := service.NewRAGService(knowledgeRepo, &cfg.RAG, appLogger)
-	recService := service.NewRecommendationService(llmService, ragService, recRepo, appLogger)
+	var csvProfiles []importer.CSVProfile
+	if cfg.Import.CSVProfilesFile != "" {
+		csvProfiles, err = importer.LoadProfiles(cfg.Import.CSVProfilesFile)
+		if err != nil {
+			appLogger.Fatal("Failed to load CSV import profiles", zap.Error(err))
+		}
+	}
+	statementImporter := importer.New(csvProfiles)
 
-	uploadDir := "uploads"
-	docService := service.NewDocumentService(docRepo, txRepo, recRepo, ocrService, llmService, recService, uploadDir, appLogger)
+	fileStorage, err := storage.New(ctx, &cfg.Storage, appLogger)
+	if err != nil {
+		appLogger.Fatal("Failed to initialize file storage", zap.Error(err))
+	}
+
+	docService := service.NewDocumentService(docRepo, txRepo, recRepo, failRepo, receiptRepo, transactor, ocrService, llmService, recService, statementImporter, fileStorage, &cfg.Upload, appLogger)
+
+	jobService := service.NewJobService(jobRepo, docService, &cfg.Jobs, appLogger)
+	jobService.Start(ctx)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService, appLogger)
-	docHandler := handlers.NewDocumentHandler(docService, appLogger)
+	var urlSigner *auth.URLSigner
+	if cfg.Storage.SignedURLKey != "" {
+		urlSigner = auth.NewURLSigner(cfg.Storage.SignedURLKey, cfg.Storage.SignedURLTTL)
+	}
+	docHandler := handlers.NewDocumentHandler(docService, jobService, urlSigner, appLogger)
+	jobHandler := handlers.NewJobHandler(jobService, appLogger)
 
 	// Setup router
-	app := api.SetupRouter(authHandler, docHandler, jwtManager, appLogger)
+	app := api.SetupRouter(authHandler, docHandler, jobHandler, jwtManager, &cfg.Upload, appLogger)
 
 	// Start server
 	go func() {
@@ -116,4 +158,7 @@ func main() {
 	if err := app.Shutdown(); err != nil {
 		appLogger.Error("Server shutdown error", zap.Error(err))
 	}
+
+	appLogger.Info("Stopping processing job workers")
+	jobService.Stop()
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,11 +16,15 @@ import (
 	"rag-iishka/internal/models"
 	"rag-iishka/internal/repository"
 	"rag-iishka/internal/service"
+	"rag-iishka/pkg/chunker"
 	"rag-iishka/pkg/config"
+	"rag-iishka/pkg/llm"
 	"rag-iishka/pkg/logger"
 	"rag-iishka/pkg/postgres"
 
+	"github.com/gen2brain/go-fitz"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
@@ -46,78 +51,51 @@ func main() {
 	defer db.Close()
 
 	knowledgeRepo := repository.NewKnowledgeRepository(db, appLogger)
+	sourceRepo := repository.NewKnowledgeSourceRepository(db, appLogger)
+	transactor := repository.NewTransactor(db)
 
 	// Initialize LLM service for PDF processing
-	llmService, err := service.NewLLMService(&cfg.GigaChat, appLogger)
+	llmProvider, err := llm.NewProvider(cfg, appLogger)
 	if err != nil {
-		appLogger.Fatal("Failed to initialize LLM service", zap.Error(err))
+		appLogger.Fatal("Failed to initialize LLM provider", zap.Error(err))
 	}
+	llmService := service.NewLLMService(llmProvider, appLogger)
 	defer llmService.Close()
 
+	ragService := service.NewRAGService(knowledgeRepo, llmService, &cfg.RAG, appLogger)
+	// Chunks are stored without embeddings when the model is unavailable, but never with vectors of another size
+	if err := ragService.CheckEmbeddingModel(ctx); errors.Is(err, service.ErrEmbeddingDimensions) {
+		appLogger.Fatal("Invalid RAG_EMBEDDING_MODEL", zap.Error(err))
+	} else if err != nil {
+		appLogger.Warn("Failed to check the embedding model", zap.Error(err))
+	}
+
 	appLogger.Info("Starting database seeding...")
 
 	// Seed knowledge base from PDF files
 	seedDir := filepath.Join("cmd", "seed")
-	cacheFile := filepath.Join(seedDir, ".seed_cache.json")
-	if err := seedKnowledgeBaseFromPDFs(ctx, seedDir, cacheFile, knowledgeRepo, llmService, appLogger); err != nil {
-		appLogger.Fatal("Failed to seed knowledge base from PDFs", zap.Error(err))
-	}
-
-	appLogger.Info("Database seeding completed successfully!")
-}
-
-// ProcessedFile represents a processed PDF file in cache
-type ProcessedFile struct {
-	FilePath    string    `json:"file_path"`
-	FileHash    string    `json:"file_hash"`
-	ProcessedAt time.Time `json:"processed_at"`
-}
-
-// CacheData stores information about processed files
-type CacheData struct {
-	ProcessedFiles map[string]ProcessedFile `json:"processed_files"` // key: file path
-}
-
-// loadCache loads the cache of processed files
-func loadCache(cacheFile string) (*CacheData, error) {
-	cache := &CacheData{
-		ProcessedFiles: make(map[string]ProcessedFile),
-	}
-
-	// Check if cache file exists
-	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
-		return cache, nil
-	}
-
-	// Read cache file
-	data, err := os.ReadFile(cacheFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cache file: %w", err)
+	seeder := &knowledgeSeeder{
+		knowledgeRepo: knowledgeRepo,
+		sourceRepo:    sourceRepo,
+		transactor:    transactor,
+		llmService:    llmService,
+		ragService:    ragService,
+		chunkOptions: chunker.Options{
+			MaxTokens:     cfg.RAG.ChunkTokens,
+			OverlapTokens: cfg.RAG.ChunkOverlap,
+		},
+		logger: appLogger,
 	}
-
-	if len(data) == 0 {
-		return cache, nil
-	}
-
-	if err := json.Unmarshal(data, cache); err != nil {
-		return nil, fmt.Errorf("failed to parse cache file: %w", err)
-	}
-
-	return cache, nil
-}
-
-// saveCache saves the cache of processed files
-func saveCache(cacheFile string, cache *CacheData) error {
-	data, err := json.MarshalIndent(cache, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal cache: %w", err)
+	if err := seeder.seedFromPDFs(ctx, seedDir); err != nil {
+		appLogger.Fatal("Failed to seed knowledge base from PDFs", zap.Error(err))
 	}
 
-	if err := os.WriteFile(cacheFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write cache file: %w", err)
+	// Embed entries created before embeddings were available or with another model
+	if err := backfillEmbeddings(ctx, knowledgeRepo, ragService, cfg.RAG.EmbeddingModel, appLogger); err != nil {
+		appLogger.Fatal("Failed to backfill knowledge base embeddings", zap.Error(err))
 	}
 
-	return nil
+	appLogger.Info("Database seeding completed successfully!")
 }
 
 // calculateFileHash calculates MD5 hash of a file
@@ -136,23 +114,21 @@ func calculateFileHash(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
-// seedKnowledgeBaseFromPDFs processes PDF files and creates knowledge base entries
-func seedKnowledgeBaseFromPDFs(
-	ctx context.Context,
-	seedDir string,
-	cacheFile string,
-	repo *repository.KnowledgeRepository,
-	llmService *service.LLMService,
-	logger *zap.Logger,
-) error {
-	now := time.Now()
+// knowledgeSeeder stores PDF files as knowledge sources split into chunks
+type knowledgeSeeder struct {
+	knowledgeRepo *repository.KnowledgeRepository
+	sourceRepo    *repository.KnowledgeSourceRepository
+	transactor    *repository.Transactor
+	llmService    *service.LLMService
+	ragService    *service.RAGService
+	chunkOptions  chunker.Options
+	logger        *zap.Logger
+}
 
-	// Load cache
-	cache, err := loadCache(cacheFile)
-	if err != nil {
-		logger.Warn("Failed to load cache, will process all files", zap.Error(err))
-		cache = &CacheData{ProcessedFiles: make(map[string]ProcessedFile)}
-	}
+// seedFromPDFs processes PDF files and creates knowledge base chunks.
+// A file is skipped if it is already stored with the same hash.
+func (s *knowledgeSeeder) seedFromPDFs(ctx context.Context, seedDir string) error {
+	logger := s.logger
 
 	// Map PDF files to knowledge types
 	pdfFiles := []struct {
@@ -194,26 +170,29 @@ func seedKnowledgeBaseFromPDFs(
 		}
 
 		// Check if file was already processed
-		if cached, exists := cache.ProcessedFiles[pdfPath]; exists {
-			if cached.FileHash == fileHash {
-				logger.Info("PDF file already processed, skipping",
-					zap.String("path", pdfPath),
-					zap.Time("processed_at", cached.ProcessedAt),
-				)
-				continue
-			} else {
-				logger.Info("PDF file changed, reprocessing",
-					zap.String("path", pdfPath),
-					zap.String("old_hash", cached.FileHash),
-					zap.String("new_hash", fileHash),
-				)
-			}
+		existing, err := s.sourceRepo.GetBySourceFile(ctx, pdfInfo.path)
+		switch {
+		case err == nil && fileHash != "" && existing.FileHash == fileHash:
+			logger.Info("PDF file already processed, skipping",
+				zap.String("path", pdfPath),
+				zap.Time("processed_at", existing.UpdatedAt),
+			)
+			continue
+		case err == nil:
+			logger.Info("PDF file changed, reprocessing",
+				zap.String("path", pdfPath),
+				zap.String("old_hash", existing.FileHash),
+				zap.String("new_hash", fileHash),
+			)
+		case errors.Is(err, pgx.ErrNoRows):
+			existing = nil
+		default:
+			return fmt.Errorf("failed to get knowledge source %s: %w", pdfInfo.path, err)
 		}
 
 		logger.Info("Processing PDF file", zap.String("path", pdfPath))
 
-		// Extract text from PDF using GigaChat Vision API
-		text, err := extractTextFromPDF(ctx, pdfPath, llmService, logger)
+		pages, err := s.extractPages(ctx, pdfPath)
 		if err != nil {
 			// Check if error is due to file size (413)
 			if strings.Contains(err.Error(), "413") || strings.Contains(err.Error(), "too large") {
@@ -227,7 +206,8 @@ func seedKnowledgeBaseFromPDFs(
 			continue
 		}
 
-		if text == "" {
+		chunks := chunker.Split(pages, s.chunkOptions)
+		if len(chunks) == 0 {
 			logger.Warn("No text extracted from PDF", zap.String("path", pdfPath))
 			continue
 		}
@@ -246,44 +226,157 @@ func seedKnowledgeBaseFromPDFs(
 
 		metadataJSON, _ := json.Marshal(metadata)
 
-		// Create knowledge base entry
-		kb := &models.KnowledgeBase{
-			ID:        uuid.New(),
-			Type:      pdfInfo.kbType,
-			Title:     title,
-			Content:   text,
-			Embedding: []float32{}, // Empty embedding for now
-			Metadata:  string(metadataJSON),
-			CreatedAt: now,
-			UpdatedAt: now,
+		now := time.Now()
+		source := &models.KnowledgeSource{
+			ID:         uuid.New(),
+			Type:       pdfInfo.kbType,
+			Title:      title,
+			SourceFile: pdfInfo.path,
+			FileHash:   fileHash,
+			PageCount:  countPages(pages),
+			Metadata:   string(metadataJSON),
+			CreatedAt:  now,
+			UpdatedAt:  now,
 		}
 
-		if err := repo.Create(ctx, kb); err != nil {
-			logger.Error("Failed to create knowledge base entry", zap.String("path", pdfPath), zap.Error(err))
+		entries := make([]*models.KnowledgeBase, len(chunks))
+		for i, chunk := range chunks {
+			entries[i] = &models.KnowledgeBase{
+				ID:         uuid.New(),
+				SourceID:   &source.ID,
+				ChunkIndex: chunk.Index,
+				PageStart:  chunk.PageStart,
+				PageEnd:    chunk.PageEnd,
+				TokenCount: chunk.TokenCount,
+				Type:       pdfInfo.kbType,
+				Title:      title,
+				Content:    chunk.Text,
+				Metadata:   string(metadataJSON),
+				CreatedAt:  now,
+				UpdatedAt:  now,
+			}
+		}
+
+		// Chunks without embedding are still found by text search and embedded by the backfill
+		if err := s.ragService.EmbedKnowledge(ctx, entries); err != nil {
+			logger.Warn("Failed to embed knowledge base chunks", zap.String("path", pdfPath), zap.Error(err))
+		}
+
+		// The previous version of the source is replaced only if all chunks are stored
+		err = s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+			if existing != nil {
+				if err := s.sourceRepo.Delete(ctx, existing.ID); err != nil {
+					return fmt.Errorf("failed to delete previous source: %w", err)
+				}
+			}
+			if err := s.knowledgeRepo.DeleteLegacyBySourceFile(ctx, pdfInfo.path); err != nil {
+				return fmt.Errorf("failed to delete unchunked entries: %w", err)
+			}
+			if err := s.sourceRepo.Create(ctx, source); err != nil {
+				return fmt.Errorf("failed to create source: %w", err)
+			}
+			return s.knowledgeRepo.CreateBatch(ctx, entries)
+		})
+		if err != nil {
+			logger.Error("Failed to store knowledge source", zap.String("path", pdfPath), zap.Error(err))
 			continue
 		}
 
-		logger.Info("Created knowledge base entry from PDF",
+		logger.Info("Created knowledge base chunks from PDF",
 			zap.String("title", title),
 			zap.String("type", string(pdfInfo.kbType)),
-			zap.Int("content_length", len(text)),
+			zap.Int("pages", source.PageCount),
+			zap.Int("chunks", len(entries)),
 		)
+	}
+
+	return nil
+}
+
+// extractPages extracts text of every page with go-fitz.
+// Scanned PDFs without a text layer are read with GigaChat Vision API as a single page of unknown number.
+func (s *knowledgeSeeder) extractPages(ctx context.Context, pdfPath string) ([]chunker.Page, error) {
+	doc, err := fitz.New(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+	defer doc.Close()
+
+	var pages []chunker.Page
+	for i := 0; i < doc.NumPage(); i++ {
+		text, err := doc.Text(i)
+		if err != nil {
+			s.logger.Warn("Failed to extract text from page",
+				zap.Int("page", i+1),
+				zap.String("file", pdfPath),
+				zap.Error(err),
+			)
+			continue
+		}
+		if strings.TrimSpace(text) != "" {
+			pages = append(pages, chunker.Page{Number: i + 1, Text: text})
+		}
+	}
+
+	if len(pages) > 0 {
+		return pages, nil
+	}
 
-		// Update cache
-		cache.ProcessedFiles[pdfPath] = ProcessedFile{
-			FilePath:    pdfPath,
-			FileHash:    fileHash,
-			ProcessedAt: now,
+	s.logger.Info("PDF has no text layer, using Vision API", zap.String("file", pdfPath))
+	text, err := extractTextFromPDF(ctx, pdfPath, s.llmService, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	return []chunker.Page{{Number: 0, Text: text}}, nil
+}
+
+// countPages returns the number of the last known page
+func countPages(pages []chunker.Page) int {
+	count := 0
+	for _, p := range pages {
+		if p.Number > count {
+			count = p.Number
 		}
 	}
+	return count
+}
 
-	// Save cache
-	if err := saveCache(cacheFile, cache); err != nil {
-		logger.Warn("Failed to save cache", zap.Error(err))
-	} else {
-		logger.Info("Cache saved", zap.Int("processed_files", len(cache.ProcessedFiles)))
+// backfillEmbeddings embeds knowledge base entries that have no embedding of the configured model
+func backfillEmbeddings(
+	ctx context.Context,
+	repo *repository.KnowledgeRepository,
+	ragService *service.RAGService,
+	model string,
+	logger *zap.Logger,
+) error {
+	const batchSize = 50
+
+	var total int
+	for {
+		entries, err := repo.ListWithoutEmbedding(ctx, model, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list entries without embedding: %w", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		if err := ragService.EmbedKnowledge(ctx, entries); err != nil {
+			return err
+		}
+
+		for _, kb := range entries {
+			if err := repo.UpdateEmbedding(ctx, kb); err != nil {
+				return fmt.Errorf("failed to update embedding of %s: %w", kb.ID, err)
+			}
+		}
+		total += len(entries)
 	}
 
+	logger.Info("Knowledge base embeddings are up to date",
+		zap.String("model", model),
+		zap.Int("embedded", total),
+	)
 	return nil
 }
 
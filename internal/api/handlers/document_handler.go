@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"net/url"
+	"time"
+
+	"rag-iishka/internal/dto"
 	"rag-iishka/internal/models"
 	"rag-iishka/internal/service"
+	"rag-iishka/pkg/auth"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -11,28 +17,38 @@ import (
 
 type DocumentHandler struct {
 	docService *service.DocumentService
+	jobService *service.JobService
+	urlSigner  *auth.URLSigner // nil when signed file URLs are disabled
 	logger     *zap.Logger
 }
 
-func NewDocumentHandler(docService *service.DocumentService, logger *zap.Logger) *DocumentHandler {
+func NewDocumentHandler(docService *service.DocumentService, jobService *service.JobService, urlSigner *auth.URLSigner, logger *zap.Logger) *DocumentHandler {
 	return &DocumentHandler{
 		docService: docService,
+		jobService: jobService,
+		urlSigner:  urlSigner,
 		logger:     logger,
 	}
 }
 
 // UploadDocument godoc
 // @Summary Upload a financial document
-// @Description Upload a receipt, statement, or screenshot for processing
+// @Description Upload a receipt, statement, or screenshot for processing.
+// @Description The type is optional: it is detected after OCR, and a confidently detected type overrules the chosen one.
+// @Description A file the user has already uploaded is not stored again: the existing document is returned with 200 and duplicate=true.
 // @Tags documents
 // @Accept multipart/form-data
 // @Produce json
-// @Param file formData file true "Document file (image)"
-// @Param type formData string true "Document type: receipt, statement, or screenshot"
+// @Param file formData file true "Document file: image (JPEG, PNG, HEIC, WebP, TIFF, BMP), PDF or statement file (CSV, OFX, QFX, QIF, 1C)"
+// @Param type formData string false "Document type: receipt, statement, screenshot, or auto (default) to detect it"
 // @Security Bearer
+// @Success 200 {object} dto.DocumentResponse
 // @Success 201 {object} dto.DocumentResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Failure 422 {object} map[string]string
 // @Router /api/v1/documents/upload [post]
 func (h *DocumentHandler) UploadDocument(c *fiber.Ctx) error {
 	userID, err := getUserID(c)
@@ -50,24 +66,10 @@ func (h *DocumentHandler) UploadDocument(c *fiber.Ctx) error {
 		})
 	}
 
-	docTypeStr := c.FormValue("type")
-	if docTypeStr == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Type is required",
-		})
-	}
-
-	var docType models.DocumentType
-	switch docTypeStr {
-	case "receipt":
-		docType = models.DocumentTypeReceipt
-	case "statement":
-		docType = models.DocumentTypeStatement
-	case "screenshot":
-		docType = models.DocumentTypeScreenshot
-	default:
+	docType, ok := parseDocumentType(c.FormValue("type"))
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid document type",
+			"error": "Invalid document type: use receipt, statement, screenshot, or auto",
 		})
 	}
 
@@ -82,6 +84,11 @@ func (h *DocumentHandler) UploadDocument(c *fiber.Ctx) error {
 
 	// Upload document
 	doc, err := h.docService.UploadDocument(c.Context(), userID, src, file.Filename, docType)
+	if status, message, ok := uploadError(err); ok {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
 	if err != nil {
 		h.logger.Error("Failed to upload document", zap.Error(err))
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -89,17 +96,137 @@ func (h *DocumentHandler) UploadDocument(c *fiber.Ctx) error {
 		})
 	}
 
+	if doc.Duplicate {
+		return c.JSON(doc)
+	}
 	return c.Status(fiber.StatusCreated).JSON(doc)
 }
 
+// UploadDocuments godoc
+// @Summary Upload several financial documents
+// @Description Upload several files at once and/or ZIP archives, whose files become documents of their own.
+// @Description Every file is validated and deduplicated like in the single upload; a file that fails does not stop the others.
+// @Description With process=true processing jobs are started for the uploaded documents.
+// @Tags documents
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file true "Document files and ZIP archives (the field can be repeated)"
+// @Param type formData string false "Document type of all files: receipt, statement, screenshot, or auto (default) to detect it"
+// @Param process formData bool false "Start processing of the uploaded documents"
+// @Security Bearer
+// @Success 200 {object} dto.BatchUploadResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Router /api/v1/documents/upload/batch [post]
+func (h *DocumentHandler) UploadDocuments(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Multipart form is required",
+		})
+	}
+	headers := append(form.File["files"], form.File["file"]...)
+	if len(headers) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Files are required",
+		})
+	}
+
+	docType, ok := parseDocumentType(c.FormValue("type"))
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document type: use receipt, statement, screenshot, or auto",
+		})
+	}
+
+	files := make([]service.UploadFile, 0, len(headers))
+	for _, header := range headers {
+		src, err := header.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to open file",
+			})
+		}
+		defer src.Close()
+
+		files = append(files, service.UploadFile{
+			Name:    header.Filename,
+			Size:    header.Size,
+			Content: src,
+		})
+	}
+
+	results, err := h.docService.UploadDocuments(c.Context(), userID, files, docType)
+	if errors.Is(err, service.ErrTooManyFiles) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		h.logger.Error("Failed to upload documents", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to upload documents",
+		})
+	}
+
+	process := c.FormValue("process") == "true"
+	response := dto.BatchUploadResponse{Results: make([]dto.BatchUploadResult, len(results))}
+	for i, result := range results {
+		item := dto.BatchUploadResult{
+			FileName: result.FileName,
+			Archive:  result.Archive,
+			Document: result.Document,
+		}
+
+		switch {
+		case result.Err != nil:
+			response.Failed++
+			if _, message, ok := uploadError(result.Err); ok {
+				item.Error = message
+			} else {
+				h.logger.Error("Failed to upload document", zap.String("file", result.FileName), zap.Error(result.Err))
+				item.Error = "Failed to upload document"
+			}
+		case result.Document.Duplicate:
+			response.Duplicates++
+		default:
+			response.Uploaded++
+		}
+
+		if process && result.Document != nil {
+			documentID, _ := uuid.Parse(result.Document.ID)
+			item.Job, err = h.jobService.EnqueueProcessing(c.Context(), userID, documentID, false)
+			if err != nil {
+				h.logger.Error("Failed to process document", zap.String("document_id", result.Document.ID), zap.Error(err))
+				item.Error = "Failed to process document"
+			}
+		}
+
+		response.Results[i] = item
+	}
+
+	return c.JSON(response)
+}
+
 // ProcessDocument godoc
 // @Summary Process a document
-// @Description Process a document: OCR -> LLM analysis -> RAG -> recommendations
+// @Description Queue a document for processing: OCR -> LLM analysis -> RAG -> recommendations.
+// @Description Returns a job immediately; poll GET /api/v1/jobs/{id} for progress and the result.
+// @Description Previous results are replaced. An up-to-date document is not processed again unless force=true.
 // @Tags documents
 // @Produce json
 // @Param id path string true "Document ID"
+// @Param force query bool false "Re-process even if the document is up to date" default(false)
 // @Security Bearer
-// @Success 200 {object} dto.ProcessDocumentResponse
+// @Success 202 {object} dto.JobResponse
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -119,15 +246,12 @@ func (h *DocumentHandler) ProcessDocument(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := h.docService.ProcessDocument(c.Context(), userID, documentID)
+	job, err := h.jobService.EnqueueProcessing(c.Context(), userID, documentID, c.QueryBool("force", false))
 	if err != nil {
-		h.logger.Error("Failed to process document", zap.Error(err))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to process document",
-		})
+		return h.handleDocumentError(c, err, "Failed to process document")
 	}
 
-	return c.JSON(result)
+	return c.Status(fiber.StatusAccepted).JSON(job)
 }
 
 // ListDocuments godoc
@@ -163,6 +287,447 @@ func (h *DocumentHandler) ListDocuments(c *fiber.Ctx) error {
 	return c.JSON(docs)
 }
 
+// GetDocument godoc
+// @Summary Get a document
+// @Description Get a document with its extracted text
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {object} dto.DocumentResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id} [get]
+func (h *DocumentHandler) GetDocument(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	doc, err := h.docService.GetDocument(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get document")
+	}
+
+	return c.JSON(doc)
+}
+
+// GetDocumentFile godoc
+// @Summary Download a document file
+// @Description Stream the uploaded file of a document
+// @Tags documents
+// @Produce octet-stream
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/file [get]
+func (h *DocumentHandler) GetDocumentFile(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	file, err := h.docService.OpenDocumentFile(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get document file")
+	}
+
+	return sendDocumentFile(c, file)
+}
+
+// GetDocumentFileURL godoc
+// @Summary Get a signed URL of a document file
+// @Description Get a short-lived URL of the document file that works without the Authorization header, e.g. in <img src>
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {object} dto.FileURLResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/file-url [get]
+func (h *DocumentHandler) GetDocumentFileURL(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	if h.urlSigner == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Signed file URLs are disabled",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	if _, err := h.docService.GetOwnedDocument(c.Context(), userID, documentID); err != nil {
+		return h.handleDocumentError(c, err, "Failed to get document")
+	}
+
+	signedURL, expiresAt := h.urlSigner.Sign("/files/" + documentID.String())
+	return c.JSON(dto.FileURLResponse{
+		URL:       signedURL,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	})
+}
+
+// GetSignedDocumentFile godoc
+// @Summary Download a document file by a signed URL
+// @Description Stream a document file by a URL from /api/v1/documents/{id}/file-url; no Authorization header is needed
+// @Tags documents
+// @Produce octet-stream
+// @Param id path string true "Document ID"
+// @Param expires query int true "Expiration time (Unix)"
+// @Param signature query string true "URL signature"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /files/{id} [get]
+func (h *DocumentHandler) GetSignedDocumentFile(c *fiber.Ctx) error {
+	if h.urlSigner == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Signed file URLs are disabled",
+		})
+	}
+
+	if err := h.urlSigner.Verify(c.Path(), c.Query("expires"), c.Query("signature")); err != nil {
+		h.logger.Warn("Rejected signed file URL", zap.String("path", c.Path()), zap.Error(err))
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid or expired URL",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	file, err := h.docService.OpenSignedDocumentFile(c.Context(), documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get document file")
+	}
+
+	return sendDocumentFile(c, file)
+}
+
+// sendDocumentFile streams a document file for display in the browser; fiber closes the body when it is sent
+func sendDocumentFile(c *fiber.Ctx, file *service.DocumentFile) error {
+	c.Set(fiber.HeaderContentType, file.ContentType)
+	c.Set(fiber.HeaderContentDisposition, "inline; filename*=UTF-8''"+url.PathEscape(file.Name))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	return c.SendStream(file.Body, int(file.Size))
+}
+
+// GetDocumentTransactions godoc
+// @Summary Get document transactions
+// @Description Get transactions extracted from a processed document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {array} dto.TransactionResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/transactions [get]
+func (h *DocumentHandler) GetDocumentTransactions(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	transactions, err := h.docService.GetDocumentTransactions(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get transactions")
+	}
+
+	return c.JSON(transactions)
+}
+
+// GetDocumentValidationFailures godoc
+// @Summary Get document validation failures
+// @Description Get model answers rejected by JSON decoding or validation while processing the document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {array} dto.ValidationFailureResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/validation-failures [get]
+func (h *DocumentHandler) GetDocumentValidationFailures(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	failures, err := h.docService.GetDocumentValidationFailures(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get validation failures")
+	}
+
+	return c.JSON(failures)
+}
+
+// GetDocumentRecommendations godoc
+// @Summary Get document recommendations
+// @Description Get recommendations generated for all transactions of a processed document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {array} dto.RecommendationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/recommendations [get]
+func (h *DocumentHandler) GetDocumentRecommendations(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	recommendations, err := h.docService.GetDocumentRecommendations(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get recommendations")
+	}
+
+	return c.JSON(recommendations)
+}
+
+// GetTransactionRecommendations godoc
+// @Summary Get transaction recommendations
+// @Description Get recommendations generated for one transaction of a document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Param txId path string true "Transaction ID"
+// @Security Bearer
+// @Success 200 {array} dto.RecommendationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/transactions/{txId}/recommendations [get]
+func (h *DocumentHandler) GetTransactionRecommendations(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	transactionID, err := uuid.Parse(c.Params("txId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid transaction ID",
+		})
+	}
+
+	recommendations, err := h.docService.GetTransactionRecommendations(c.Context(), userID, documentID, transactionID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get recommendations")
+	}
+
+	return c.JSON(recommendations)
+}
+
+// GetDocumentItems godoc
+// @Summary Get document receipt items
+// @Description Get line items of all receipt transactions of a document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Security Bearer
+// @Success 200 {array} dto.TransactionItemResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/items [get]
+func (h *DocumentHandler) GetDocumentItems(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	items, err := h.docService.GetDocumentItems(c.Context(), userID, documentID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get items")
+	}
+
+	return c.JSON(items)
+}
+
+// GetTransactionItems godoc
+// @Summary Get transaction receipt items
+// @Description Get line items of one receipt transaction of a document
+// @Tags documents
+// @Produce json
+// @Param id path string true "Document ID"
+// @Param txId path string true "Transaction ID"
+// @Security Bearer
+// @Success 200 {array} dto.TransactionItemResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/documents/{id}/transactions/{txId}/items [get]
+func (h *DocumentHandler) GetTransactionItems(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	transactionID, err := uuid.Parse(c.Params("txId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid transaction ID",
+		})
+	}
+
+	items, err := h.docService.GetTransactionItems(c.Context(), userID, documentID, transactionID)
+	if err != nil {
+		return h.handleDocumentError(c, err, "Failed to get items")
+	}
+
+	return c.JSON(items)
+}
+
+// parseDocumentType validates the type form field of an upload; an empty type or auto
+// leaves the type to detection and gives an empty type
+func parseDocumentType(value string) (models.DocumentType, bool) {
+	switch value {
+	case "", "auto":
+		return "", true
+	case "receipt":
+		return models.DocumentTypeReceipt, true
+	case "statement":
+		return models.DocumentTypeStatement, true
+	case "screenshot":
+		return models.DocumentTypeScreenshot, true
+	default:
+		return "", false
+	}
+}
+
+// uploadError maps validation errors of an uploaded file to a status and a message for the user;
+// ok is false for other errors
+func uploadError(err error) (status int, message string, ok bool) {
+	switch {
+	case errors.Is(err, service.ErrUnsupportedFileFormat):
+		return fiber.StatusUnsupportedMediaType, "Unsupported file format: upload an image (JPEG, PNG, HEIC, WebP, TIFF, BMP), a PDF or a statement file (CSV, OFX, QFX, QIF, 1C)", true
+	case errors.Is(err, service.ErrFileTooLarge), errors.Is(err, service.ErrTooManyFiles):
+		return fiber.StatusRequestEntityTooLarge, err.Error(), true
+	case errors.Is(err, service.ErrTooManyPages), errors.Is(err, service.ErrInvalidArchive):
+		return fiber.StatusUnprocessableEntity, err.Error(), true
+	}
+	return 0, "", false
+}
+
+// handleDocumentError maps service errors to HTTP responses.
+// Documents of other users are reported as not found to avoid leaking their existence.
+func (h *DocumentHandler) handleDocumentError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, service.ErrDocumentNotFound), errors.Is(err, service.ErrDocumentAccessDenied):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Document not found",
+		})
+	case errors.Is(err, service.ErrTransactionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Transaction not found",
+		})
+	}
+
+	h.logger.Error(message, zap.Error(err))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
 func getUserID(c *fiber.Ctx) (uuid.UUID, error) {
 	userIDStr, ok := c.Locals("userID").(string)
 	if !ok {
@@ -176,4 +741,3 @@ func getUserID(c *fiber.Ctx) (uuid.UUID, error) {
 
 	return userID, nil
 }
-
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+
+	"rag-iishka/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+type JobHandler struct {
+	jobService *service.JobService
+	logger     *zap.Logger
+}
+
+func NewJobHandler(jobService *service.JobService, logger *zap.Logger) *JobHandler {
+	return &JobHandler{
+		jobService: jobService,
+		logger:     logger,
+	}
+}
+
+// GetJob godoc
+// @Summary Get processing job status
+// @Description Get the stage (ocr, analysis, recommendations), progress, error and result of a processing job
+// @Tags jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Security Bearer
+// @Success 200 {object} dto.JobResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/jobs/{id} [get]
+func (h *JobHandler) GetJob(c *fiber.Ctx) error {
+	userID, err := getUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID",
+		})
+	}
+
+	job, err := h.jobService.GetJob(c.Context(), userID, jobID)
+	if err != nil {
+		if errors.Is(err, service.ErrJobNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Job not found",
+			})
+		}
+		h.logger.Error("Failed to get job", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get job",
+		})
+	}
+
+	return c.JSON(job)
+}
@@ -6,6 +6,7 @@ import (
 	"rag-iishka/docs"
 	"rag-iishka/internal/api/handlers"
 	"rag-iishka/pkg/auth"
+	"rag-iishka/pkg/config"
 	"rag-iishka/pkg/middleware"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,13 +17,20 @@ import (
 	"go.uber.org/zap"
 )
 
+// multipartOverhead is the room left in the request body limit for multipart headers and form fields
+const multipartOverhead = 1 << 20
+
 func SetupRouter(
 	authHandler *handlers.AuthHandler,
 	docHandler *handlers.DocumentHandler,
+	jobHandler *handlers.JobHandler,
 	jwtManager *auth.JWTManager,
+	uploadCfg *config.UploadConfig,
 	appLogger *zap.Logger,
 ) *fiber.App {
 	app := fiber.New(fiber.Config{
+		// A batch upload, or at least the largest allowed file with the rest of the multipart form
+		BodyLimit: int(max(uploadCfg.MaxRequestSize, uploadCfg.MaxFileSize+multipartOverhead)),
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -51,7 +59,6 @@ func SetupRouter(
 	// Try to find web/static directory relative to current working directory
 	// or relative to executable location
 	webStaticPath := findWebStaticPath(appLogger)
-	uploadsPath := findUploadsPath(appLogger)
 
 	// Static files (web interface)
 	if webStaticPath != "" {
@@ -60,10 +67,6 @@ func SetupRouter(
 	} else {
 		appLogger.Warn("Web static directory not found, static files will not be served")
 	}
-	if uploadsPath != "" {
-		appLogger.Info("Serving uploads", zap.String("path", uploadsPath))
-		app.Static("/uploads", uploadsPath)
-	}
 
 	// Serve index.html for root path
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -95,15 +98,32 @@ func SetupRouter(
 	auth.Post("/login", authHandler.Login)
 	auth.Post("/refresh", authHandler.RefreshToken)
 
+	// Document files by signed URLs (public, the signature grants access)
+	app.Get("/files/:id", docHandler.GetSignedDocumentFile)
+
 	// Protected routes
 	protected := app.Group("/api/v1", middleware.AuthMiddleware(jwtManager, appLogger))
 
 	// Document routes
 	documents := protected.Group("/documents")
 	documents.Post("/upload", docHandler.UploadDocument)
+	documents.Post("/upload/batch", docHandler.UploadDocuments)
 	documents.Get("", docHandler.ListDocuments)
+	documents.Get("/:id", docHandler.GetDocument)
+	documents.Get("/:id/file", docHandler.GetDocumentFile)
+	documents.Get("/:id/file-url", docHandler.GetDocumentFileURL)
+	documents.Get("/:id/transactions", docHandler.GetDocumentTransactions)
+	documents.Get("/:id/transactions/:txId/recommendations", docHandler.GetTransactionRecommendations)
+	documents.Get("/:id/transactions/:txId/items", docHandler.GetTransactionItems)
+	documents.Get("/:id/items", docHandler.GetDocumentItems)
+	documents.Get("/:id/recommendations", docHandler.GetDocumentRecommendations)
+	documents.Get("/:id/validation-failures", docHandler.GetDocumentValidationFailures)
 	documents.Post("/:id/process", docHandler.ProcessDocument)
 
+	// Processing job routes
+	jobs := protected.Group("/jobs")
+	jobs.Get("/:id", jobHandler.GetJob)
+
 	return app
 }
 
@@ -133,25 +153,6 @@ func findWebStaticPath(logger *zap.Logger) string {
 	return ""
 }
 
-// findUploadsPath finds the path to uploads directory
-func findUploadsPath(logger *zap.Logger) string {
-	// Try paths relative to current working directory
-	paths := []string{
-		"./uploads",
-		"uploads",
-		"../uploads",
-		"../../uploads",
-	}
-
-	for _, path := range paths {
-		if _, err := os.Stat(path); err == nil {
-			return path
-		}
-	}
-
-	return "uploads" // Default, will be created if needed
-}
-
 // fileExists checks if a file exists
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
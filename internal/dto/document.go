@@ -1,22 +1,77 @@
 package dto
 
 type UploadDocumentRequest struct {
-	Type string `json:"type" validate:"required,oneof=receipt statement screenshot"`
+	Type string `json:"type" validate:"omitempty,oneof=receipt statement screenshot auto"`
 }
 
 type DocumentResponse struct {
-	ID            string `json:"id"`
-	Type          string `json:"type"`
-	FileName      string `json:"file_name"`
-	FileSize      int64  `json:"file_size"`
-	FileURL       string `json:"file_url"`
-	ExtractedText string `json:"extracted_text,omitempty"`
-	CreatedAt     string `json:"created_at"`
+	ID                string  `json:"id"`
+	Type              string  `json:"type"` // type chosen by the user; empty when it is left to detection
+	FileName          string  `json:"file_name"`
+	FileSize          int64   `json:"file_size"`
+	FileURL           string  `json:"file_url"`
+	ContentHash       string  `json:"content_hash,omitempty"`    // hex SHA-256 of the file
+	DetectedType      string  `json:"detected_type,omitempty"`   // type detected after OCR, set once the document is processed
+	TypeConfidence    float64 `json:"type_confidence,omitempty"` // confidence of the detected type from 0 to 1
+	ExtractedText     string  `json:"extracted_text,omitempty"`
+	ProcessingVersion string  `json:"processing_version,omitempty"`
+	ProcessedAt       string  `json:"processed_at,omitempty"`
+	CreatedAt         string  `json:"created_at"`
+
+	// Duplicate is set by upload when the user has already uploaded the same file: this is the existing document
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// FileURLResponse is a short-lived signed URL of a document file
+type FileURLResponse struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"`
 }
 
 type ProcessDocumentResponse struct {
-	Document      DocumentResponse   `json:"document"`
-	Transactions  []TransactionResponse `json:"transactions"`
+	Document        DocumentResponse         `json:"document"`
+	Transactions    []TransactionResponse    `json:"transactions"`
 	Recommendations []RecommendationResponse `json:"recommendations"`
+	FiscalReceipt   *FiscalReceiptResponse   `json:"fiscal_receipt,omitempty"`
+}
+
+// FiscalReceiptResponse is the fiscal data decoded from the QR code of a receipt
+type FiscalReceiptResponse struct {
+	FN            string  `json:"fn"`
+	FD            string  `json:"fd"`
+	FP            string  `json:"fp"`
+	OperationType int     `json:"operation_type"` // 1 - приход, 2 - возврат прихода, 3 - расход, 4 - возврат расхода
+	Total         float64 `json:"total"`
+	IssuedAt      string  `json:"issued_at"` // местное время с чека, без часового пояса
+	TransactionID string  `json:"transaction_id,omitempty"`
+
+	// DuplicateOfDocumentID is set when the user has already uploaded this receipt with another document
+	DuplicateOfDocumentID string `json:"duplicate_of_document_id,omitempty"`
 }
 
+// ValidationFailureResponse is a model answer rejected while processing a document
+type ValidationFailureResponse struct {
+	ID        string   `json:"id"`
+	Stage     string   `json:"stage"`
+	Attempt   int      `json:"attempt"`
+	Errors    []string `json:"errors"`
+	Response  string   `json:"response"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// BatchUploadResponse is the result of a batch upload: one entry per file, files of ZIP archives included
+type BatchUploadResponse struct {
+	Uploaded   int                 `json:"uploaded"`   // new documents
+	Duplicates int                 `json:"duplicates"` // files the user had already uploaded
+	Failed     int                 `json:"failed"`
+	Results    []BatchUploadResult `json:"results"`
+}
+
+// BatchUploadResult is the outcome of one file of a batch upload
+type BatchUploadResult struct {
+	FileName string            `json:"file_name"`         // path inside the archive for files of a ZIP archive
+	Archive  string            `json:"archive,omitempty"` // ZIP archive the file was taken from
+	Document *DocumentResponse `json:"document,omitempty"`
+	Job      *JobResponse      `json:"job,omitempty"` // processing job, with process=true
+	Error    string            `json:"error,omitempty"`
+}
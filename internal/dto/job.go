@@ -0,0 +1,15 @@
+package dto
+
+type JobResponse struct {
+	ID         string                   `json:"id"`
+	DocumentID string                   `json:"document_id"`
+	Status     string                   `json:"status"`
+	Stage      string                   `json:"stage,omitempty"`
+	Progress   int                      `json:"progress"`
+	Force      bool                     `json:"force"`
+	Error      string                   `json:"error,omitempty"`
+	Result     *ProcessDocumentResponse `json:"result,omitempty"`
+	CreatedAt  string                   `json:"created_at"`
+	StartedAt  string                   `json:"started_at,omitempty"`
+	FinishedAt string                   `json:"finished_at,omitempty"`
+}
@@ -1,11 +1,27 @@
 package dto
 
 type RecommendationResponse struct {
-	ID              string  `json:"id"`
-	Title           string  `json:"title"`
-	Description     string  `json:"description"`
+	ID               string  `json:"id"`
+	TransactionID    string  `json:"transaction_id"` // main transaction of the recommendation
+	Title            string  `json:"title"`
+	Description      string  `json:"description"`
 	PotentialSavings float64 `json:"potential_savings"`
-	Source          string  `json:"source"`
-	CreatedAt       string  `json:"created_at"`
+	SavingsBasis     string  `json:"savings_basis"`
+	Confidence       float64 `json:"confidence"`
+	Source           string  `json:"source"`
+	CreatedAt        string  `json:"created_at"`
+
+	TransactionIDs []string           `json:"transaction_ids"` // all transactions the recommendation covers
+	Citations      []CitationResponse `json:"citations"`
 }
 
+// CitationResponse is a knowledge base entry a recommendation refers to as [number]
+type CitationResponse struct {
+	Number      int    `json:"number"`
+	KnowledgeID string `json:"knowledge_id,omitempty"` // empty if the entry was removed by re-seeding
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	SourceFile  string `json:"source_file"`
+	PageStart   int    `json:"page_start,omitempty"`
+	PageEnd     int    `json:"page_end,omitempty"`
+}
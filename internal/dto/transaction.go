@@ -7,7 +7,23 @@ type TransactionResponse struct {
 	Description     string  `json:"description"`
 	Category        string  `json:"category"`
 	LLMDescription  string  `json:"llm_description"`
+	Bank            string  `json:"bank,omitempty"`
 	Date            string  `json:"date"`
 	CreatedAt       string  `json:"created_at"`
+
+	Items []TransactionItemResponse `json:"items,omitempty"` // позиции чека
+}
+
+// TransactionItemResponse is a line item of a receipt transaction
+type TransactionItemResponse struct {
+	ID            string  `json:"id"`
+	TransactionID string  `json:"transaction_id"`
+	Position      int     `json:"position"`
+	Name          string  `json:"name"`
+	Quantity      float64 `json:"quantity"`
+	UnitPrice     float64 `json:"unit_price"`
+	Total         float64 `json:"total"`
+	VATRate       string  `json:"vat_rate"` // 20%, 18%, 10%, 0%, none или пусто, если не указана
+	Category      string  `json:"category"`
 }
 
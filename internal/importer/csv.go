@@ -0,0 +1,311 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CSVProfile maps the columns of a bank's CSV export. A profile matches a file when
+// the header row has all of its configured columns; headers are compared case-insensitively.
+type CSVProfile struct {
+	Name        string     `json:"name"`
+	Bank        string     `json:"bank"`         // bank of the statement's transactions
+	Encoding    string     `json:"encoding"`     // utf-8, windows-1251 or cp866; empty detects utf-8 or windows-1251
+	Delimiter   string     `json:"delimiter"`    // empty tries ";", "," and tab
+	DateFormats []string   `json:"date_formats"` // Go time layouts of the date column
+	Currency    string     `json:"currency"`     // currency of all rows when there is no currency column
+	Statuses    []string   `json:"statuses"`     // accepted values of the status column, e.g. ["OK"]; empty accepts all
+	Columns     CSVColumns `json:"columns"`
+}
+
+// CSVColumns are the header names of the columns. Either Amount or Debit and Credit must be set.
+type CSVColumns struct {
+	Date        string `json:"date"`
+	Amount      string `json:"amount"` // signed amount, negative for debits
+	Debit       string `json:"debit"`  // unsigned amount of outgoing operations
+	Credit      string `json:"credit"` // unsigned amount of incoming operations
+	Currency    string `json:"currency"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	MCC         string `json:"mcc"`
+	Status      string `json:"status"`
+}
+
+// DefaultProfiles are the built-in profiles of Sber, Tinkoff and Alfa-Bank exports
+var DefaultProfiles = []CSVProfile{
+	{
+		Name:        "tinkoff",
+		Bank:        "Тинькофф",
+		Delimiter:   ";",
+		DateFormats: []string{"02.01.2006 15:04:05", "02.01.2006 15:04", "02.01.2006"},
+		Statuses:    []string{"OK"},
+		Columns: CSVColumns{
+			Date:        "Дата операции",
+			Amount:      "Сумма операции",
+			Currency:    "Валюта операции",
+			Description: "Описание",
+			Category:    "Категория",
+			MCC:         "MCC",
+			Status:      "Статус",
+		},
+	},
+	{
+		Name:        "sber",
+		Bank:        "Сбербанк",
+		DateFormats: []string{"02.01.2006 15:04:05", "02.01.2006 15:04", "02.01.2006"},
+		Currency:    defaultCurrency,
+		Columns: CSVColumns{
+			Date:        "Дата операции",
+			Amount:      "Сумма в валюте счёта",
+			Description: "Описание операции",
+			Category:    "Категория",
+		},
+	},
+	{
+		Name:        "alfa",
+		Bank:        "Альфа-Банк",
+		Delimiter:   ";",
+		DateFormats: []string{"02.01.06", "02.01.2006"},
+		Columns: CSVColumns{
+			Date:        "Дата операции",
+			Debit:       "Расход",
+			Credit:      "Приход",
+			Currency:    "Валюта",
+			Description: "Описание операции",
+		},
+	},
+}
+
+// headerSearchRows is the number of leading rows searched for the header: exports often start with account details
+const headerSearchRows = 20
+
+// LoadProfiles reads CSV profiles from a JSON file holding an array of profiles
+func LoadProfiles(path string) ([]CSVProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV profiles: %w", err)
+	}
+
+	var profiles []CSVProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse CSV profiles: %w", err)
+	}
+
+	for i, p := range profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("CSV profile %d (%q): %w", i, p.Name, err)
+		}
+	}
+	return profiles, nil
+}
+
+func (p *CSVProfile) validate() error {
+	switch {
+	case p.Name == "":
+		return errors.New("name is required")
+	case p.Columns.Date == "" || p.Columns.Description == "":
+		return errors.New("date and description columns are required")
+	case p.Columns.Amount == "" && (p.Columns.Debit == "" || p.Columns.Credit == ""):
+		return errors.New("either the amount column or the debit and credit columns are required")
+	case len(p.DateFormats) == 0:
+		return errors.New("date_formats are required")
+	case p.Columns.Currency == "" && p.Currency == "":
+		return errors.New("either the currency column or a currency is required")
+	case len([]rune(p.Delimiter)) > 1:
+		return errors.New("delimiter must be one character")
+	}
+	if _, err := decodeText(nil, p.Encoding); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseCSV reads the file with the first matching profile
+func (i *Importer) parseCSV(data []byte) (*Statement, error) {
+	for _, profile := range i.profiles {
+		text, err := decodeText(data, profile.Encoding)
+		if err != nil {
+			continue
+		}
+
+		for _, delimiter := range profile.delimiters() {
+			records, header, ok := findHeader(text, delimiter, &profile)
+			if !ok {
+				continue
+			}
+
+			transactions, err := profile.parseRows(records, header)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+			}
+			return &Statement{Profile: profile.Name, Text: text, Transactions: transactions}, nil
+		}
+	}
+
+	return nil, errors.New("no CSV profile matches the header of the file")
+}
+
+func (p *CSVProfile) delimiters() []rune {
+	if p.Delimiter != "" {
+		return []rune(p.Delimiter)[:1]
+	}
+	return []rune{';', ',', '\t'}
+}
+
+// findHeader reads the rows of text and locates the header of the profile.
+// It returns the rows after the header and the indexes of the profile's columns.
+func findHeader(text string, delimiter rune, p *CSVProfile) ([][]string, map[string]int, bool) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	for row := 0; row < headerSearchRows; row++ {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, nil, false
+		}
+
+		header := make(map[string]int, len(record))
+		for index, name := range record {
+			header[normalizeHeader(name)] = index
+		}
+		if !p.matches(header) {
+			continue
+		}
+
+		var records [][]string
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, nil, false
+			}
+			records = append(records, record)
+		}
+		return records, header, true
+	}
+	return nil, nil, false
+}
+
+func normalizeHeader(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.ReplaceAll(name, "ё", "е")
+}
+
+// matches reports whether the header has all configured columns of the profile
+func (p *CSVProfile) matches(header map[string]int) bool {
+	for _, column := range p.columnNames() {
+		if _, ok := header[normalizeHeader(column)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *CSVProfile) columnNames() []string {
+	c := p.Columns
+	var names []string
+	for _, name := range []string{c.Date, c.Amount, c.Debit, c.Credit, c.Currency, c.Description, c.Category, c.MCC, c.Status} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseRows converts the rows after the header. Rows without a date (blank lines, totals)
+// and rows with a rejected status are skipped; any other unreadable row fails the import,
+// so a statement is never imported partially.
+func (p *CSVProfile) parseRows(records [][]string, header map[string]int) ([]Transaction, error) {
+	cell := func(record []string, column string) string {
+		if column == "" {
+			return ""
+		}
+		index := header[normalizeHeader(column)]
+		if index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+
+	var transactions []Transaction
+	for n, record := range records {
+		if cell(record, p.Columns.Date) == "" {
+			continue
+		}
+		if p.Columns.Status != "" && len(p.Statuses) > 0 && !containsFold(p.Statuses, cell(record, p.Columns.Status)) {
+			continue
+		}
+
+		row := n + 1
+		date, err := parseDate(cell(record, p.Columns.Date), p.DateFormats)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		amount, err := p.amount(record, cell)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		currency := strings.ToUpper(cell(record, p.Columns.Currency))
+		switch currency {
+		case "":
+			currency = strings.ToUpper(p.Currency)
+		case "RUR":
+			currency = defaultCurrency
+		}
+
+		transactions = append(transactions, Transaction{
+			Date:         date,
+			Amount:       amount,
+			Currency:     currency,
+			Description:  cell(record, p.Columns.Description),
+			BankCategory: cell(record, p.Columns.Category),
+			MCC:          cell(record, p.Columns.MCC),
+			Bank:         p.Bank,
+		})
+	}
+	return transactions, nil
+}
+
+// amount returns the signed amount of a row
+func (p *CSVProfile) amount(record []string, cell func([]string, string) string) (float64, error) {
+	if p.Columns.Amount != "" {
+		return parseAmount(cell(record, p.Columns.Amount))
+	}
+
+	var debit, credit float64
+	var err error
+	if value := cell(record, p.Columns.Debit); value != "" {
+		if debit, err = parseAmount(value); err != nil {
+			return 0, err
+		}
+	}
+	if value := cell(record, p.Columns.Credit); value != "" {
+		if credit, err = parseAmount(value); err != nil {
+			return 0, err
+		}
+	}
+	if debit < 0 {
+		debit = -debit
+	}
+	return credit - debit, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
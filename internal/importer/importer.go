@@ -0,0 +1,211 @@
+// Package importer reads machine-readable bank statements: CSV exports of Russian banks,
+// OFX/QFX, QIF and the 1C:Enterprise client-bank exchange format (1CClientBankExchange).
+//
+// Unlike OCR and model extraction the result is exact: amounts, currencies and dates
+// are taken from the file as is. Only categorization is left to the caller.
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Format is the format of a statement file
+type Format string
+
+const (
+	FormatCSV Format = "csv"
+	FormatOFX Format = "ofx"
+	FormatQIF Format = "qif"
+	Format1C  Format = "1c"
+)
+
+// defaultCurrency is used for formats that do not state the currency: QIF and 1C statements of ruble accounts
+const defaultCurrency = "RUB"
+
+// ErrUnsupported is returned by Parse when the file is not a statement in a known format
+var ErrUnsupported = errors.New("unsupported statement format")
+
+// Transaction is one operation of a statement
+type Transaction struct {
+	Date         time.Time
+	Amount       float64 // negative for debits (outgoing operations), positive for credits
+	Currency     string  // ISO 4217 code
+	Description  string
+	BankCategory string // category assigned by the bank, if the export has one
+	MCC          string
+	Bank         string
+	ID           string // identifier of the operation in the file, e.g. OFX FITID; may be empty
+}
+
+// Debit reports whether the operation is outgoing
+func (t *Transaction) Debit() bool {
+	return t.Amount < 0
+}
+
+// Statement is a parsed statement file
+type Statement struct {
+	Format       Format
+	Profile      string // name of the matched CSV profile
+	Text         string // file contents decoded to UTF-8
+	Transactions []Transaction
+}
+
+// statementExtensions are the file extensions of formats the importer may read.
+// 1C exports are plain .txt files; their content is checked by Parse.
+var statementExtensions = map[string]bool{
+	".csv": true,
+	".ofx": true,
+	".qfx": true,
+	".qif": true,
+	".txt": true,
+}
+
+// Supports reports whether a file with this name may be a statement file
+func Supports(fileName string) bool {
+	return statementExtensions[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// Detect returns the format of a statement file, or an empty string if it is not recognized.
+// Signatures in the content win over the extension.
+func Detect(fileName string, data []byte) Format {
+	head := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	upper := bytes.ToUpper(head)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("1CClientBankExchange")):
+		return Format1C
+	case bytes.Contains(upper, []byte("OFXHEADER")) || bytes.Contains(upper, []byte("<OFX>")):
+		return FormatOFX
+	case bytes.HasPrefix(upper, []byte("!TYPE:")) || bytes.HasPrefix(upper, []byte("!ACCOUNT")):
+		return FormatQIF
+	case strings.EqualFold(filepath.Ext(fileName), ".csv"):
+		return FormatCSV
+	}
+	return ""
+}
+
+// Importer parses statement files
+type Importer struct {
+	profiles []CSVProfile
+}
+
+// New creates an importer. CSV profiles are tried in order: the given ones first,
+// then the built-in DefaultProfiles.
+func New(profiles []CSVProfile) *Importer {
+	return &Importer{
+		profiles: append(append([]CSVProfile(nil), profiles...), DefaultProfiles...),
+	}
+}
+
+// Parse reads a statement file
+func (i *Importer) Parse(fileName string, data []byte) (*Statement, error) {
+	var statement *Statement
+	var err error
+
+	format := Detect(fileName, data)
+	switch format {
+	case FormatCSV:
+		statement, err = i.parseCSV(data)
+	case FormatOFX:
+		statement, err = parseOFX(data)
+	case FormatQIF:
+		statement, err = parseQIF(data)
+	case Format1C:
+		statement, err = parse1C(data)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s statement: %w", format, err)
+	}
+
+	statement.Format = format
+	return statement, nil
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// decodeText converts file contents to UTF-8. encoding is utf-8, windows-1251 or cp866;
+// when it is empty, invalid UTF-8 is taken for windows-1251, the usual encoding of Russian bank exports.
+func decodeText(data []byte, encoding string) (string, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	switch strings.ToLower(strings.ReplaceAll(encoding, "_", "-")) {
+	case "":
+		if utf8.Valid(data) {
+			return string(data), nil
+		}
+		return decodeCharmap(data, charmap.Windows1251)
+	case "utf-8", "utf8":
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("file is not valid UTF-8")
+		}
+		return string(data), nil
+	case "windows-1251", "cp1251", "1251":
+		return decodeCharmap(data, charmap.Windows1251)
+	case "cp866", "ibm866", "866":
+		return decodeCharmap(data, charmap.CodePage866)
+	default:
+		return "", fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+func decodeCharmap(data []byte, cm *charmap.Charmap) (string, error) {
+	decoded, err := cm.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s: %w", cm, err)
+	}
+	return string(decoded), nil
+}
+
+// parseAmount parses amounts as written by banks: "-1 234,56", "1234.56", "+1,234.56", "−500".
+// When both separators are present, the last one is the decimal separator.
+func parseAmount(s string) (float64, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ' ', ' ', '\'':
+			return -1
+		case '−', '–':
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(s))
+
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case comma > dot:
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	case dot > comma && comma != -1:
+		s = strings.ReplaceAll(s, ",", "")
+	}
+
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
+
+// parseDate parses a date with the first matching layout
+func parseDate(value string, layouts []string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
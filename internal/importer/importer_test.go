@@ -0,0 +1,232 @@
+package importer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		file    string
+		format  Format
+		profile string
+		want    []Transaction
+	}{
+		{
+			// The FAILED operation is skipped by the status filter
+			file:    "tinkoff.csv",
+			format:  FormatCSV,
+			profile: "tinkoff",
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 20, 18, 45, 12, 0, time.UTC), Amount: -1234.56, Currency: "RUB", Description: "Пятёрочка", BankCategory: "Супермаркеты", MCC: "5411", Bank: "Тинькофф"},
+				{Date: time.Date(2024, 3, 18, 9, 15, 0, 0, time.UTC), Amount: 50000, Currency: "RUB", Description: "Зарплата", BankCategory: "Пополнения", Bank: "Тинькофф"},
+				{Date: time.Date(2024, 3, 17, 21, 5, 33, 0, time.UTC), Amount: -25, Currency: "USD", Description: "Apple.com", BankCategory: "Сервис", MCC: "5734", Bank: "Тинькофф"},
+			},
+		},
+		{
+			// windows-1251, account details above the header and a totals row without a date
+			file:    "sber.csv",
+			format:  FormatCSV,
+			profile: "sber",
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC), Amount: -2345.67, Currency: "RUB", Description: "ПЕРЕКРЁСТОК Москва RUS", BankCategory: "Супермаркеты", Bank: "Сбербанк"},
+				{Date: time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC), Amount: 10000, Currency: "RUB", Description: "Перевод от И. Иванова", BankCategory: "Перевод", Bank: "Сбербанк"},
+			},
+		},
+		{
+			// Separate debit and credit columns, RUR is RUB
+			file:    "alfa.csv",
+			format:  FormatCSV,
+			profile: "alfa",
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Amount: -450, Currency: "RUB", Description: "Оплата Яндекс.Такси", Bank: "Альфа-Банк"},
+				{Date: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), Amount: 10000, Currency: "RUB", Description: "Перевод от Петрова П.П.", Bank: "Альфа-Банк"},
+			},
+		},
+		{
+			// OFX 1.x SGML in windows-1251: DTUSER wins over DTPOSTED, entities are decoded
+			file:   "statement_sgml.ofx",
+			format: FormatOFX,
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), Amount: -1500, Currency: "RUB", Description: "Магазин & Ко Покупка продуктов", Bank: "Банк Точка", ID: "101"},
+				{Date: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), Amount: 25000, Currency: "RUB", Description: "Зарплата", Bank: "Банк Точка", ID: "102"},
+			},
+		},
+		{
+			// OFX 2.x XML: a memo equal to the name is not repeated, CURSYM overrides CURDEF
+			file:   "statement_xml.ofx",
+			format: FormatOFX,
+			want: []Transaction{
+				{Date: time.Date(2024, 2, 12, 0, 0, 0, 0, time.UTC), Amount: -12.5, Currency: "EUR", Description: "Café de Flore", ID: "A-1"},
+				{Date: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC), Amount: -30, Currency: "USD", Description: "Amazon", ID: "A-2"},
+			},
+		},
+		{
+			// Month-first dates, an apostrophe before the year and spaces in the date;
+			// the category list is not read as transactions
+			file:   "statement.qif",
+			format: FormatQIF,
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), Amount: -1234.56, Currency: "RUB", Description: "Перекрёсток Продукты на неделю", BankCategory: "Продукты", ID: "1001"},
+				{Date: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), Amount: 1000, Currency: "RUB", Description: "Зарплата"},
+				{Date: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Amount: -99.9, Currency: "RUB", Description: "Кино"},
+			},
+		},
+		{
+			// A payment from the statement account is a debit dated by ДатаСписано,
+			// a payment to it is a credit dated by ДатаПоступило
+			file:   "statement_1c.txt",
+			format: Format1C,
+			want: []Transaction{
+				{Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Amount: -12500, Currency: "RUB", Description: `ООО "Поставщик": Оплата по счёту №7`, Bank: "ПАО Сбербанк", ID: "15"},
+				{Date: time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC), Amount: 40000, Currency: "RUB", Description: "ИП Иванов И.И.", Bank: "ПАО Сбербанк", ID: "3"},
+			},
+		},
+	}
+
+	importer := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statement, err := importer.Parse(tt.file, data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if statement.Format != tt.format || statement.Profile != tt.profile {
+				t.Errorf("got format %q, profile %q, want %q, %q", statement.Format, statement.Profile, tt.format, tt.profile)
+			}
+			if len(statement.Transactions) != len(tt.want) {
+				t.Fatalf("got %d transactions, want %d: %+v", len(statement.Transactions), len(tt.want), statement.Transactions)
+			}
+			for i, want := range tt.want {
+				if got := statement.Transactions[i]; got != want {
+					t.Errorf("transaction %d:\n got %+v\nwant %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParse1CWithoutAccounts(t *testing.T) {
+	// Without statement accounts the direction is taken from the write-off date
+	data := []byte("1CClientBankExchange\nКодировка=UTF-8\n" +
+		"СекцияДокумент=Платежное поручение\nНомер=1\nДата=01.04.2024\nСумма=100.50\nПолучатель1=ООО \"Связь\"\nДатаСписано=02.04.2024\nКонецДокумента\n" +
+		"СекцияДокумент=Платежное поручение\nНомер=2\nДата=03.04.2024\nСумма=200\nПлательщик=ООО \"Клиент\"\nКонецДокумента\n")
+
+	statement, err := New(nil).Parse("kl_to_1c.txt", data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(statement.Transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(statement.Transactions))
+	}
+	if tx := statement.Transactions[0]; tx.Amount != -100.5 || tx.Date.Day() != 2 || tx.Description != `ООО "Связь"` {
+		t.Errorf("unexpected debit %+v", tx)
+	}
+	if tx := statement.Transactions[1]; tx.Amount != 200 || tx.Date.Day() != 3 || tx.Description != `ООО "Клиент"` {
+		t.Errorf("unexpected credit %+v", tx)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"unknown CSV header", "export.csv", "Дата;Сумма\n01.03.2024;100\n"},
+		{"invalid CSV amount", "export.csv", "Дата операции;Расход;Приход;Валюта;Описание операции\n01.03.24;много;;RUR;Покупка\n"},
+		{"unterminated OFX transaction", "bank.ofx", "<OFX><BANKTRANLIST><STMTTRN><DTPOSTED>20240301<TRNAMT>1"},
+		{"OFX without transactions", "bank.ofx", "OFXHEADER:100\n<OFX></OFX>"},
+		{"invalid QIF date", "bank.qif", "!Type:Bank\nD31/12/2024\nT10\n^\n"},
+		{"unterminated 1C document", "kl_to_1c.txt", "1CClientBankExchange\nКодировка=UTF-8\nСекцияДокумент=Платежное поручение\nСумма=1\n"},
+	}
+
+	importer := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if statement, err := importer.Parse(tt.file, []byte(tt.data)); err == nil {
+				t.Errorf("Parse succeeded: %+v", statement.Transactions)
+			}
+		})
+	}
+
+	if _, err := importer.Parse("notes.txt", []byte("просто текст")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v for a text file, want ErrUnsupported", err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		data     string
+		want     Format
+	}{
+		{"1C", "kl_to_1c.txt", "1CClientBankExchange\nВерсияФормата=1.03\n", Format1C},
+		{"1C with BOM", "kl_to_1c.txt", "\ufeff1CClientBankExchange\n", Format1C},
+		{"OFX 1.x", "statement.txt", "OFXHEADER:100\nDATA:OFXSGML\n", FormatOFX},
+		{"OFX 2.x", "statement.xml", `<?xml version="1.0"?><?OFX OFXHEADER="200"?>`, FormatOFX},
+		{"OFX without header", "statement.qfx", "\n  <ofx><SIGNONMSGSRSV1>", FormatOFX},
+		{"QIF", "statement.txt", "!Type:Bank\nD3/15'24\n", FormatQIF},
+		{"QIF account list", "statement.qif", "!Account\nNКарта\n^\n", FormatQIF},
+		{"CSV by extension", "Export.CSV", "Дата операции;Сумма\n", FormatCSV},
+		{"content wins over extension", "statement.csv", "OFXHEADER:100\n", FormatOFX},
+		{"unknown text", "notes.txt", "Дата операции;Сумма\n", ""},
+		{"QIF signature not at the start", "notes.txt", "Заметки\n!Type:Bank\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.fileName, []byte(tt.data)); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.fileName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"1234.56", 1234.56},
+		{"1234,56", 1234.56},
+		{"1 234,56", 1234.56},
+		{"-1 234,56", -1234.56},
+		{"1\u00a0234,56", 1234.56},
+		{"1\u202f234,56", 1234.56},
+		{"1,234.56", 1234.56},
+		{"+1,234.56", 1234.56},
+		{"1.234,56", 1234.56},
+		{"1'234.56", 1234.56},
+		{"−500", -500},
+		{"–500,00", -500},
+		{" 12 ", 12},
+		{"0", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in)
+		if err != nil {
+			t.Errorf("parseAmount(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAmount(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "12 руб", "1,2,3.4.5"} {
+		if got, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q) = %v, want an error", in, got)
+		}
+	}
+}
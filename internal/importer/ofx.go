@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ofxCharset1251 marks OFX 1.x files in windows-1251 (CHARSET:1251 in the SGML header)
+var ofxCharset1251 = regexp.MustCompile(`(?i)CHARSET:\s*(1251|WINDOWS-1251)`)
+
+// parseOFX reads OFX and QFX files. Both the SGML form of OFX 1.x, where elements are not
+// closed, and the XML form of OFX 2.x are read: a value is the text after the tag up to the next tag.
+// Tags are matched as written by the specification, in upper case.
+func parseOFX(data []byte) (*Statement, error) {
+	encoding := ""
+	if ofxCharset1251.Match(data[:min(len(data), 1024)]) {
+		encoding = "windows-1251"
+	}
+	text, err := decodeText(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	currency := strings.ToUpper(ofxValue(text, "CURDEF"))
+	if currency == "" {
+		currency = defaultCurrency
+	}
+	bank := ofxValue(text, "ORG")
+
+	var transactions []Transaction
+	for offset := 0; ; {
+		start := strings.Index(text[offset:], "<STMTTRN>")
+		if start == -1 {
+			break
+		}
+		start += offset
+		end := strings.Index(text[start:], "</STMTTRN>")
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated STMTTRN at offset %d", start)
+		}
+		end += start
+		block := text[start:end]
+		offset = end
+
+		tx, err := ofxTransaction(block, currency, bank)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %w", len(transactions)+1, err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if len(transactions) == 0 && !strings.Contains(text, "<BANKTRANLIST>") {
+		return nil, fmt.Errorf("no statement transactions found")
+	}
+
+	return &Statement{Text: text, Transactions: transactions}, nil
+}
+
+func ofxTransaction(block, currency, bank string) (Transaction, error) {
+	// DTUSER is the date of the operation, DTPOSTED the date it was posted to the account
+	date := ofxValue(block, "DTUSER")
+	if date == "" {
+		date = ofxValue(block, "DTPOSTED")
+	}
+	if len(date) < 8 {
+		return Transaction{}, fmt.Errorf("invalid date %q", date)
+	}
+	parsed, err := parseDate(date[:8], []string{"20060102"})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	amount, err := parseAmount(ofxValue(block, "TRNAMT"))
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	if symbol := ofxValue(block, "CURSYM"); symbol != "" {
+		currency = strings.ToUpper(symbol)
+	}
+	if currency == "RUR" {
+		currency = defaultCurrency
+	}
+
+	description := ofxValue(block, "NAME")
+	if memo := ofxValue(block, "MEMO"); memo != "" && memo != description {
+		description = strings.TrimSpace(description + " " + memo)
+	}
+
+	return Transaction{
+		Date:        parsed,
+		Amount:      amount,
+		Currency:    currency,
+		Description: description,
+		Bank:        bank,
+		ID:          ofxValue(block, "FITID"),
+	}, nil
+}
+
+// ofxValue returns the value of the first element with the tag, with SGML entities decoded
+func ofxValue(text, tag string) string {
+	open := "<" + tag + ">"
+	start := strings.Index(text, open)
+	if start == -1 {
+		return ""
+	}
+	value := text[start+len(open):]
+	if end := strings.IndexAny(value, "<\r\n"); end != -1 {
+		value = value[:end]
+	}
+	return strings.TrimSpace(ofxEntities.Replace(value))
+}
+
+var ofxEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ")
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// oneCEncoding reads the Кодировка header of a 1C file; it is ASCII, so it can be matched before decoding
+var oneCEncoding = regexp.MustCompile(`(?m)^\S*=(Windows|DOS|UTF-8)\s*$`)
+
+// parse1C reads statements in the 1CClientBankExchange format exported by bank clients for 1C:Enterprise:
+// Key=Value lines with a header, account sections (СекцияРасчСчет) and documents (СекцияДокумент).
+// An operation is a debit when the payer account is one of the statement accounts.
+func parse1C(data []byte) (*Statement, error) {
+	encoding := "windows-1251"
+	if match := oneCEncoding.FindSubmatch(data[:min(len(data), 1024)]); match != nil {
+		switch strings.ToUpper(string(match[1])) {
+		case "DOS":
+			encoding = "cp866"
+		case "UTF-8":
+			encoding = "utf-8"
+		}
+	}
+	text, err := decodeText(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make(map[string]bool)
+	var documents []map[string]string
+	var document map[string]string
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case key == "СекцияДокумент":
+			document = map[string]string{}
+		case key == "КонецДокумента":
+			if document != nil {
+				documents = append(documents, document)
+			}
+			document = nil
+		case document != nil:
+			document[key] = value
+		case key == "РасчСчет" && value != "":
+			accounts[value] = true
+		}
+	}
+
+	if document != nil {
+		return nil, fmt.Errorf("document %d is not terminated with КонецДокумента", len(documents)+1)
+	}
+
+	transactions := make([]Transaction, 0, len(documents))
+	for i, doc := range documents {
+		tx, err := oneCTransaction(doc, accounts)
+		if err != nil {
+			return nil, fmt.Errorf("document %d (№%s): %w", i+1, doc["Номер"], err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	return &Statement{Text: text, Transactions: transactions}, nil
+}
+
+func oneCTransaction(doc map[string]string, accounts map[string]bool) (Transaction, error) {
+	amount, err := parseAmount(doc["Сумма"])
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	// Without statement accounts in the header the direction is taken from the write-off and receipt dates
+	debit := accounts[doc["ПлательщикСчет"]]
+	if len(accounts) == 0 {
+		debit = doc["ДатаСписано"] != ""
+	}
+
+	date := doc["ДатаПоступило"]
+	counterparty := firstNonEmpty(doc["Плательщик1"], doc["Плательщик"])
+	bank := firstNonEmpty(doc["ПолучательБанк1"], doc["ПолучательБанк"])
+	if debit {
+		amount = -amount
+		date = doc["ДатаСписано"]
+		counterparty = firstNonEmpty(doc["Получатель1"], doc["Получатель"])
+		bank = firstNonEmpty(doc["ПлательщикБанк1"], doc["ПлательщикБанк"])
+	}
+	if date == "" {
+		date = doc["Дата"]
+	}
+
+	parsed, err := parseDate(date, []string{"02.01.2006"})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	description := counterparty
+	if purpose := doc["НазначениеПлатежа"]; purpose != "" {
+		description = strings.TrimSpace(description + ": " + purpose)
+	}
+
+	return Transaction{
+		Date:        parsed,
+		Amount:      amount,
+		Currency:    defaultCurrency,
+		Description: strings.TrimPrefix(description, ": "),
+		Bank:        bank,
+		ID:          doc["Номер"],
+	}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// qifDateFormats are the date layouts of QIF exports. Slashed dates are month first,
+// as written by Quicken; an apostrophe before the year is replaced with a slash.
+var qifDateFormats = []string{
+	"1/2/2006", "1/2/06",
+	"02.01.2006", "02.01.06",
+	"2006-01-02",
+}
+
+// parseQIF reads QIF files. QIF does not state the currency; amounts are taken to be in rubles.
+// Split lines and account lists are ignored: each record gives one transaction with its total.
+func parseQIF(data []byte) (*Statement, error) {
+	text, err := decodeText(data, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	var tx Transaction
+	var date, amount string
+	var memo string
+	inTransactions := false
+
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == '!' {
+			// Header lines: !Type:Bank, !Type:CCard, !Account, !Option:...
+			header := strings.ToLower(line)
+			inTransactions = strings.HasPrefix(header, "!type:") && !strings.HasPrefix(header, "!type:cat") &&
+				!strings.HasPrefix(header, "!type:class") && !strings.HasPrefix(header, "!type:memorized")
+			continue
+		}
+
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'D':
+			date = value
+		case 'T', 'U':
+			amount = value
+		case 'P':
+			tx.Description = value
+		case 'M':
+			memo = value
+		case 'L':
+			tx.BankCategory = strings.Trim(value, "[]")
+		case 'N':
+			tx.ID = value
+		case '^':
+			if inTransactions {
+				if tx.Description == "" {
+					tx.Description = memo
+				} else if memo != "" && memo != tx.Description {
+					tx.Description += " " + memo
+				}
+
+				tx.Date, err = parseDate(strings.ReplaceAll(strings.ReplaceAll(date, " ", ""), "'", "/"), qifDateFormats)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", n+1, err)
+				}
+				tx.Amount, err = parseAmount(amount)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", n+1, err)
+				}
+				tx.Currency = defaultCurrency
+				transactions = append(transactions, tx)
+			}
+			tx, date, amount, memo = Transaction{}, "", "", ""
+		}
+	}
+
+	return &Statement{Text: text, Transactions: transactions}, nil
+}
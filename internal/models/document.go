@@ -9,20 +9,34 @@ import (
 type DocumentType string
 
 const (
-	DocumentTypeReceipt   DocumentType = "receipt"
-	DocumentTypeStatement DocumentType = "statement"
+	DocumentTypeReceipt    DocumentType = "receipt"
+	DocumentTypeStatement  DocumentType = "statement"
 	DocumentTypeScreenshot DocumentType = "screenshot"
 )
 
-type Document struct {
-	ID          uuid.UUID   `db:"id"`
-	UserID      uuid.UUID   `db:"user_id"`
-	Type        DocumentType `db:"type"`
-	FileName    string      `db:"file_name"`
-	FileSize    int64       `db:"file_size"`
-	FileURL     string      `db:"file_url"`
-	ExtractedText string    `db:"extracted_text"`
-	CreatedAt   time.Time   `db:"created_at"`
-	UpdatedAt   time.Time   `db:"updated_at"`
+// Valid reports whether t is one of the document types
+func (t DocumentType) Valid() bool {
+	switch t {
+	case DocumentTypeReceipt, DocumentTypeStatement, DocumentTypeScreenshot:
+		return true
+	}
+	return false
 }
 
+type Document struct {
+	ID                uuid.UUID    `db:"id"`
+	UserID            uuid.UUID    `db:"user_id"`
+	Type              DocumentType `db:"type"` // type chosen by the user; empty when it is left to detection
+	FileName          string       `db:"file_name"`
+	FileSize          int64        `db:"file_size"`
+	FileURL           string       `db:"file_url"`
+	StorageKey        string       `db:"storage_key"`  // content-addressed key of the file in the document storage
+	ContentHash       string       `db:"content_hash"` // hex SHA-256 of the file; empty for documents uploaded before it was stored
+	ExtractedText     string       `db:"extracted_text"`
+	DetectedType      DocumentType `db:"detected_type"`      // type detected after OCR; empty until the document is processed
+	TypeConfidence    float64      `db:"type_confidence"`    // confidence of the detected type from 0 to 1
+	ProcessingVersion string       `db:"processing_version"` // OCR and prompt revisions that produced the stored results
+	ProcessedAt       *time.Time   `db:"processed_at"`
+	CreatedAt         time.Time    `db:"created_at"`
+	UpdatedAt         time.Time    `db:"updated_at"`
+}
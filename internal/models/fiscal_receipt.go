@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FiscalReceipt is the fiscal data decoded from the QR code of a receipt
+type FiscalReceipt struct {
+	ID            uuid.UUID  `db:"id"`
+	DocumentID    uuid.UUID  `db:"document_id"`
+	TransactionID *uuid.UUID `db:"transaction_id"` // транзакция, дата и сумма которой взяты из QR-кода
+	UserID        uuid.UUID  `db:"user_id"`
+	FN            string     `db:"fn"`             // номер фискального накопителя
+	FD            string     `db:"fd"`             // номер фискального документа
+	FP            string     `db:"fp"`             // фискальный признак документа
+	OperationType int        `db:"operation_type"` // 1 - приход, 2 - возврат прихода, 3 - расход, 4 - возврат расхода
+	Total         float64    `db:"total"`
+	IssuedAt      time.Time  `db:"issued_at"` // местное время с чека
+	Raw           string     `db:"raw"`       // исходный текст QR-кода
+	CreatedAt     time.Time  `db:"created_at"`
+}
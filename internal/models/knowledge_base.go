@@ -14,14 +14,33 @@ const (
 	KnowledgeTypeEducation  KnowledgeType = "education"
 )
 
-type KnowledgeBase struct {
-	ID          uuid.UUID    `db:"id"`
-	Type        KnowledgeType `db:"type"`
-	Title       string       `db:"title"`
-	Content     string       `db:"content"`
-	Embedding   []float32    `db:"embedding"` // векторное представление
-	Metadata    string       `db:"metadata"`  // JSON с дополнительными данными
-	CreatedAt   time.Time    `db:"created_at"`
-	UpdatedAt   time.Time    `db:"updated_at"`
+// KnowledgeSource is a document the knowledge base was built from
+type KnowledgeSource struct {
+	ID         uuid.UUID     `db:"id"`
+	Type       KnowledgeType `db:"type"`
+	Title      string        `db:"title"`
+	SourceFile string        `db:"source_file"`
+	FileHash   string        `db:"file_hash"`
+	PageCount  int           `db:"page_count"`
+	Metadata   string        `db:"metadata"`
+	CreatedAt  time.Time     `db:"created_at"`
+	UpdatedAt  time.Time     `db:"updated_at"`
 }
 
+// KnowledgeBase is a chunk of a knowledge source
+type KnowledgeBase struct {
+	ID             uuid.UUID     `db:"id"`
+	SourceID       *uuid.UUID    `db:"source_id"`   // nil для записей, созданных до разбиения на чанки
+	ChunkIndex     int           `db:"chunk_index"` // позиция чанка в источнике
+	PageStart      int           `db:"page_start"`  // 0, если страница неизвестна
+	PageEnd        int           `db:"page_end"`
+	TokenCount     int           `db:"token_count"`
+	Type           KnowledgeType `db:"type"`
+	Title          string        `db:"title"`
+	Content        string        `db:"content"`
+	Embedding      []float32     `db:"embedding"`       // векторное представление
+	EmbeddingModel string        `db:"embedding_model"` // модель, которой получен embedding
+	Metadata       string        `db:"metadata"`        // JSON с дополнительными данными
+	CreatedAt      time.Time     `db:"created_at"`
+	UpdatedAt      time.Time     `db:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+type JobStage string
+
+const (
+	JobStageOCR             JobStage = "ocr"
+	JobStageAnalysis        JobStage = "analysis"
+	JobStageRecommendations JobStage = "recommendations"
+)
+
+type ProcessingJob struct {
+	ID         uuid.UUID  `db:"id"`
+	DocumentID uuid.UUID  `db:"document_id"`
+	UserID     uuid.UUID  `db:"user_id"`
+	Status     JobStatus  `db:"status"`
+	Stage      JobStage   `db:"stage"`    // пустая строка, пока задача в очереди
+	Progress   int        `db:"progress"` // 0-100
+	Force      bool       `db:"force"`    // re-process even if results of the current version exist
+	Error      string     `db:"error"`
+	Result     []byte     `db:"result"` // JSON с dto.ProcessDocumentResponse
+	CreatedAt  time.Time  `db:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+	StartedAt  *time.Time `db:"started_at"`
+	FinishedAt *time.Time `db:"finished_at"`
+}
@@ -7,13 +7,29 @@ import (
 )
 
 type Recommendation struct {
-	ID            uuid.UUID `db:"id"`
-	TransactionID uuid.UUID `db:"transaction_id"`
-	UserID        uuid.UUID `db:"user_id"`
-	Title         string    `db:"title"`
-	Description   string    `db:"description"`
-	PotentialSavings float64 `db:"potential_savings"`
-	Source        string    `db:"source"` // источник из базы знаний
-	CreatedAt     time.Time `db:"created_at"`
+	ID               uuid.UUID `db:"id"`
+	TransactionID    uuid.UUID `db:"transaction_id"`
+	UserID           uuid.UUID `db:"user_id"`
+	Title            string    `db:"title"`
+	Description      string    `db:"description"`
+	PotentialSavings float64   `db:"potential_savings"`
+	SavingsBasis     string    `db:"savings_basis"` // расчёт, на котором основана оценка экономии
+	Confidence       float64   `db:"confidence"`    // уверенность модели, 0..1
+	Source           string    `db:"source"`        // источник из базы знаний
+	CreatedAt        time.Time `db:"created_at"`
+
+	TransactionIDs []uuid.UUID              `db:"-"` // все транзакции, к которым относится рекомендация; TransactionID - основная из них
+	Citations      []RecommendationCitation `db:"-"` // записи базы знаний, на которые сослалась модель
 }
 
+// RecommendationCitation links a recommendation to a knowledge base entry it cites
+type RecommendationCitation struct {
+	RecommendationID uuid.UUID     `db:"recommendation_id"`
+	Number           int           `db:"number"`       // номер пункта контекста [n] в промпте
+	KnowledgeID      *uuid.UUID    `db:"knowledge_id"` // nil, если запись удалена при повторном seed
+	Type             KnowledgeType `db:"type"`
+	Title            string        `db:"title"`
+	SourceFile       string        `db:"source_file"`
+	PageStart        int           `db:"page_start"`
+	PageEnd          int           `db:"page_end"`
+}
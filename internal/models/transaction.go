@@ -9,27 +9,79 @@ import (
 type TransactionCategory string
 
 const (
-	CategoryFood        TransactionCategory = "food"
-	CategoryTransport   TransactionCategory = "transport"
-	CategoryUtilities   TransactionCategory = "utilities"
-	CategoryShopping    TransactionCategory = "shopping"
+	CategoryFood          TransactionCategory = "food"
+	CategoryTransport     TransactionCategory = "transport"
+	CategoryUtilities     TransactionCategory = "utilities"
+	CategoryShopping      TransactionCategory = "shopping"
 	CategoryEntertainment TransactionCategory = "entertainment"
-	CategoryHealthcare  TransactionCategory = "healthcare"
-	CategoryEducation   TransactionCategory = "education"
-	CategoryOther       TransactionCategory = "other"
+	CategoryHealthcare    TransactionCategory = "healthcare"
+	CategoryEducation     TransactionCategory = "education"
+	CategoryFees          TransactionCategory = "fees" // банковские комиссии и плата за обслуживание
+	CategoryOther         TransactionCategory = "other"
 )
 
+// TransactionCategories lists all categories in the order they are shown to the model
+var TransactionCategories = []TransactionCategory{
+	CategoryFood,
+	CategoryTransport,
+	CategoryUtilities,
+	CategoryShopping,
+	CategoryEntertainment,
+	CategoryHealthcare,
+	CategoryEducation,
+	CategoryFees,
+	CategoryOther,
+}
+
+// Valid reports whether c is one of TransactionCategories
+func (c TransactionCategory) Valid() bool {
+	for _, category := range TransactionCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
 type Transaction struct {
-	ID          uuid.UUID          `db:"id"`
-	DocumentID  uuid.UUID          `db:"document_id"`
-	UserID      uuid.UUID          `db:"user_id"`
-	Amount      float64            `db:"amount"`
-	Currency    string             `db:"currency"`
-	Description string             `db:"description"`
-	Category    TransactionCategory `db:"category"`
-	LLMDescription string          `db:"llm_description"`
-	Date        time.Time          `db:"date"`
-	CreatedAt   time.Time          `db:"created_at"`
-	UpdatedAt   time.Time          `db:"updated_at"`
+	ID             uuid.UUID           `db:"id"`
+	DocumentID     uuid.UUID           `db:"document_id"`
+	UserID         uuid.UUID           `db:"user_id"`
+	Amount         float64             `db:"amount"`
+	Currency       string              `db:"currency"`
+	Description    string              `db:"description"`
+	Category       TransactionCategory `db:"category"`
+	LLMDescription string              `db:"llm_description"`
+	Bank           string              `db:"bank"` // банк карты или счёта, пусто если неизвестен
+	Date           time.Time           `db:"date"`
+	CreatedAt      time.Time           `db:"created_at"`
+	UpdatedAt      time.Time           `db:"updated_at"`
+
+	Items []TransactionItem `db:"-"` // позиции чека, пусто для других документов
 }
 
+// VATRate is the VAT rate printed on a receipt line
+type VATRate string
+
+const (
+	VATRateUnknown VATRate = ""
+	VATRate20      VATRate = "20%"
+	VATRate18      VATRate = "18%" // ставка до 2019 года
+	VATRate10      VATRate = "10%"
+	VATRate0       VATRate = "0%"
+	VATRateNone    VATRate = "none" // без НДС
+)
+
+// TransactionItem is a line item of a receipt transaction
+type TransactionItem struct {
+	ID            uuid.UUID           `db:"id"`
+	TransactionID uuid.UUID           `db:"transaction_id"`
+	Position      int                 `db:"position"` // порядковый номер позиции в чеке, с 1
+	Name          string              `db:"name"`
+	Quantity      float64             `db:"quantity"`
+	UnitPrice     float64             `db:"unit_price"`
+	Total         float64             `db:"total"` // стоимость позиции с учётом скидки
+	VATRate       VATRate             `db:"vat_rate"`
+	Category      TransactionCategory `db:"category"`
+	CreatedAt     time.Time           `db:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ValidationFailure is a model answer rejected while processing a document
+type ValidationFailure struct {
+	ID         uuid.UUID `db:"id"`
+	DocumentID uuid.UUID `db:"document_id"`
+	Stage      JobStage  `db:"stage"`   // этап обработки, на котором получен ответ
+	Attempt    int       `db:"attempt"` // номер попытки, начиная с 1
+	Errors     []string  `db:"errors"`  // ошибки разбора и проверки
+	Response   string    `db:"response"`
+	CreatedAt  time.Time `db:"created_at"`
+}
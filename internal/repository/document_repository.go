@@ -4,12 +4,17 @@ import (
 	"context"
 	"rag-iishka/internal/models"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/Masterminds/squirrel"
 	"go.uber.org/zap"
 )
 
+// documentColumns are the selected columns of documents; content_hash is NULL for documents uploaded before it was stored,
+// type is NULL when the user left it to detection and the detected type is NULL until the document is processed
+var documentColumns = []string{"id", "user_id", "COALESCE(type, '')", "file_name", "file_size", "file_url", "storage_key", "COALESCE(content_hash, '')", "extracted_text", "COALESCE(detected_type, '')", "COALESCE(type_confidence, 0)", "processing_version", "processed_at", "created_at", "updated_at"}
+
 type DocumentRepository struct {
 	db     *pgxpool.Pool
 	logger *zap.Logger
@@ -22,23 +27,29 @@ func NewDocumentRepository(db *pgxpool.Pool, logger *zap.Logger) *DocumentReposi
 	}
 }
 
-func (r *DocumentRepository) Create(ctx context.Context, doc *models.Document) error {
+// Create inserts a document unless the user already has one with the same content hash.
+// It reports whether the document was inserted.
+func (r *DocumentRepository) Create(ctx context.Context, doc *models.Document) (bool, error) {
 	query := squirrel.Insert("documents").
-		Columns("id", "user_id", "type", "file_name", "file_size", "file_url", "extracted_text", "created_at", "updated_at").
-		Values(doc.ID, doc.UserID, doc.Type, doc.FileName, doc.FileSize, doc.FileURL, doc.ExtractedText, doc.CreatedAt, doc.UpdatedAt).
+		Columns("id", "user_id", "type", "file_name", "file_size", "file_url", "storage_key", "content_hash", "extracted_text", "created_at", "updated_at").
+		Values(doc.ID, doc.UserID, squirrel.Expr("NULLIF(?, '')", doc.Type), doc.FileName, doc.FileSize, doc.FileURL, doc.StorageKey, doc.ContentHash, doc.ExtractedText, doc.CreatedAt, doc.UpdatedAt).
+		Suffix("ON CONFLICT (user_id, content_hash) DO NOTHING").
 		PlaceholderFormat(squirrel.Dollar)
 
 	sql, args, err := query.ToSql()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
-	return err
+	tag, err := conn(ctx, r.db).Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
 
 func (r *DocumentRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Document, error) {
-	query := squirrel.Select("id", "user_id", "type", "file_name", "file_size", "file_url", "extracted_text", "created_at", "updated_at").
+	query := squirrel.Select(documentColumns...).
 		From("documents").
 		Where(squirrel.Eq{"id": id}).
 		PlaceholderFormat(squirrel.Dollar)
@@ -48,15 +59,22 @@ func (r *DocumentRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 		return nil, err
 	}
 
-	var doc models.Document
-	err = r.db.QueryRow(ctx, sql, args...).Scan(
-		&doc.ID, &doc.UserID, &doc.Type, &doc.FileName, &doc.FileSize, &doc.FileURL, &doc.ExtractedText, &doc.CreatedAt, &doc.UpdatedAt,
-	)
+	return scanDocument(conn(ctx, r.db).QueryRow(ctx, sql, args...))
+}
+
+// GetByContentHash returns the user's document with the given content hash
+func (r *DocumentRepository) GetByContentHash(ctx context.Context, userID uuid.UUID, contentHash string) (*models.Document, error) {
+	query := squirrel.Select(documentColumns...).
+		From("documents").
+		Where(squirrel.Eq{"user_id": userID, "content_hash": contentHash}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	return &doc, nil
+	return scanDocument(conn(ctx, r.db).QueryRow(ctx, sql, args...))
 }
 
 func (r *DocumentRepository) UpdateExtractedText(ctx context.Context, id uuid.UUID, text string) error {
@@ -71,12 +89,33 @@ func (r *DocumentRepository) UpdateExtractedText(ctx context.Context, id uuid.UU
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+// MarkProcessed stores the extracted text, the detected type and the processing version of fresh results
+func (r *DocumentRepository) MarkProcessed(ctx context.Context, id uuid.UUID, text string, detectedType models.DocumentType, typeConfidence float64, version string) error {
+	query := squirrel.Update("documents").
+		Set("extracted_text", text).
+		Set("detected_type", squirrel.Expr("NULLIF(?, '')", detectedType)).
+		Set("type_confidence", typeConfidence).
+		Set("processing_version", version).
+		Set("processed_at", squirrel.Expr("NOW()")).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"id": id}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
 	return err
 }
 
 func (r *DocumentRepository) ListByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.Document, error) {
-	query := squirrel.Select("id", "user_id", "type", "file_name", "file_size", "file_url", "extracted_text", "created_at", "updated_at").
+	query := squirrel.Select(documentColumns...).
 		From("documents").
 		Where(squirrel.Eq{"user_id": userID}).
 		OrderBy("created_at DESC").
@@ -89,7 +128,7 @@ func (r *DocumentRepository) ListByUserID(ctx context.Context, userID uuid.UUID,
 		return nil, err
 	}
 
-	rows, err := r.db.Query(ctx, sql, args...)
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -97,15 +136,24 @@ func (r *DocumentRepository) ListByUserID(ctx context.Context, userID uuid.UUID,
 
 	var documents []*models.Document
 	for rows.Next() {
-		var doc models.Document
-		if err := rows.Scan(
-			&doc.ID, &doc.UserID, &doc.Type, &doc.FileName, &doc.FileSize, &doc.FileURL, &doc.ExtractedText, &doc.CreatedAt, &doc.UpdatedAt,
-		); err != nil {
+		doc, err := scanDocument(rows)
+		if err != nil {
 			return nil, err
 		}
-		documents = append(documents, &doc)
+		documents = append(documents, doc)
 	}
 
-	return documents, nil
+	return documents, rows.Err()
 }
 
+func scanDocument(row pgx.Row) (*models.Document, error) {
+	var doc models.Document
+	err := row.Scan(
+		&doc.ID, &doc.UserID, &doc.Type, &doc.FileName, &doc.FileSize, &doc.FileURL, &doc.StorageKey, &doc.ContentHash,
+		&doc.ExtractedText, &doc.DetectedType, &doc.TypeConfidence, &doc.ProcessingVersion, &doc.ProcessedAt, &doc.CreatedAt, &doc.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
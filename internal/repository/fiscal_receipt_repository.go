@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"rag-iishka/internal/models"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var fiscalReceiptColumns = []string{"id", "document_id", "transaction_id", "user_id", "fn", "fd", "fp", "operation_type", "total", "issued_at", "raw", "created_at"}
+
+type FiscalReceiptRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewFiscalReceiptRepository(db *pgxpool.Pool, logger *zap.Logger) *FiscalReceiptRepository {
+	return &FiscalReceiptRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create inserts a receipt unless the user already has one with the same fn, fd and fp.
+// It reports whether the receipt was inserted.
+func (r *FiscalReceiptRepository) Create(ctx context.Context, receipt *models.FiscalReceipt) (bool, error) {
+	query := squirrel.Insert("fiscal_receipts").
+		Columns(fiscalReceiptColumns...).
+		Values(receipt.ID, receipt.DocumentID, receipt.TransactionID, receipt.UserID, receipt.FN, receipt.FD, receipt.FP, receipt.OperationType, receipt.Total, receipt.IssuedAt, receipt.Raw, receipt.CreatedAt).
+		Suffix("ON CONFLICT (user_id, fn, fd, fp) DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := conn(ctx, r.db).Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetByFiscalID returns the user's receipt with the given fiscal identifiers
+func (r *FiscalReceiptRepository) GetByFiscalID(ctx context.Context, userID uuid.UUID, fn, fd, fp string) (*models.FiscalReceipt, error) {
+	query := squirrel.Select(fiscalReceiptColumns...).
+		From("fiscal_receipts").
+		Where(squirrel.Eq{"user_id": userID, "fn": fn, "fd": fd, "fp": fp}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanFiscalReceipt(conn(ctx, r.db).QueryRow(ctx, sql, args...))
+}
+
+// GetByDocumentID returns the receipt decoded from the document;
+// pgx.ErrNoRows if no receipt QR code was found in it
+func (r *FiscalReceiptRepository) GetByDocumentID(ctx context.Context, documentID uuid.UUID) (*models.FiscalReceipt, error) {
+	query := squirrel.Select(fiscalReceiptColumns...).
+		From("fiscal_receipts").
+		Where(squirrel.Eq{"document_id": documentID}).
+		Limit(1).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return scanFiscalReceipt(conn(ctx, r.db).QueryRow(ctx, sql, args...))
+}
+
+func (r *FiscalReceiptRepository) DeleteByDocumentID(ctx context.Context, documentID uuid.UUID) error {
+	query := squirrel.Delete("fiscal_receipts").
+		Where(squirrel.Eq{"document_id": documentID}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+func scanFiscalReceipt(row pgx.Row) (*models.FiscalReceipt, error) {
+	var receipt models.FiscalReceipt
+	err := row.Scan(
+		&receipt.ID, &receipt.DocumentID, &receipt.TransactionID, &receipt.UserID, &receipt.FN, &receipt.FD, &receipt.FP,
+		&receipt.OperationType, &receipt.Total, &receipt.IssuedAt, &receipt.Raw, &receipt.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
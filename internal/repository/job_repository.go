@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rag-iishka/internal/models"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+var jobColumns = []string{
+	"id", "document_id", "user_id", "status", "stage", "progress", "force", "error", "result",
+	"created_at", "updated_at", "started_at", "finished_at",
+}
+
+type JobRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewJobRepository(db *pgxpool.Pool, logger *zap.Logger) *JobRepository {
+	return &JobRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *JobRepository) Create(ctx context.Context, job *models.ProcessingJob) error {
+	query := squirrel.Insert("processing_jobs").
+		Columns("id", "document_id", "user_id", "status", "stage", "progress", "force", "error", "created_at", "updated_at").
+		Values(job.ID, job.DocumentID, job.UserID, job.Status, job.Stage, job.Progress, job.Force, job.Error, job.CreatedAt, job.UpdatedAt).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, sql, args...)
+	return err
+}
+
+func (r *JobRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.ProcessingJob, error) {
+	query := squirrel.Select(jobColumns...).
+		From("processing_jobs").
+		Where(squirrel.Eq{"id": id}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.ProcessingJob
+	err = r.db.QueryRow(ctx, sql, args...).Scan(
+		&job.ID, &job.DocumentID, &job.UserID, &job.Status, &job.Stage, &job.Progress, &job.Force, &job.Error, &job.Result,
+		&job.CreatedAt, &job.UpdatedAt, &job.StartedAt, &job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// GetActiveByDocumentID returns a pending or running job for the document, if any
+func (r *JobRepository) GetActiveByDocumentID(ctx context.Context, documentID uuid.UUID) (*models.ProcessingJob, error) {
+	query := squirrel.Select(jobColumns...).
+		From("processing_jobs").
+		Where(squirrel.Eq{
+			"document_id": documentID,
+			"status":      []models.JobStatus{models.JobStatusPending, models.JobStatusRunning},
+		}).
+		OrderBy("created_at DESC").
+		Limit(1).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var job models.ProcessingJob
+	err = r.db.QueryRow(ctx, sql, args...).Scan(
+		&job.ID, &job.DocumentID, &job.UserID, &job.Status, &job.Stage, &job.Progress, &job.Force, &job.Error, &job.Result,
+		&job.CreatedAt, &job.UpdatedAt, &job.StartedAt, &job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ListPendingIDs returns IDs of queued jobs, oldest first
+func (r *JobRepository) ListPendingIDs(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	query := squirrel.Select("id").
+		From("processing_jobs").
+		Where(squirrel.Eq{"status": models.JobStatusPending}).
+		OrderBy("created_at ASC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Claim atomically moves a pending job to running.
+// It returns false if the job was already taken by another worker.
+// The returned start time identifies this run in the later updates of the job.
+func (r *JobRepository) Claim(ctx context.Context, id uuid.UUID) (time.Time, bool, error) {
+	query := squirrel.Update("processing_jobs").
+		Set("status", models.JobStatusRunning).
+		Set("started_at", squirrel.Expr("NOW()")).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"id": id, "status": models.JobStatusPending}).
+		Suffix("RETURNING started_at").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var startedAt time.Time
+	if err := r.db.QueryRow(ctx, sql, args...).Scan(&startedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+
+	return startedAt, true, nil
+}
+
+// runWhere matches the job only while it is still in the run started at startedAt,
+// so a run that was re-queued as stale cannot overwrite the state of a newer run
+func runWhere(id uuid.UUID, startedAt time.Time) squirrel.Eq {
+	return squirrel.Eq{"id": id, "status": models.JobStatusRunning, "started_at": startedAt}
+}
+
+func (r *JobRepository) UpdateProgress(ctx context.Context, id uuid.UUID, startedAt time.Time, stage models.JobStage, progress int) error {
+	query := squirrel.Update("processing_jobs").
+		Set("stage", stage).
+		Set("progress", progress).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(runWhere(id, startedAt)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, sql, args...)
+	return err
+}
+
+// MarkCompleted stores the result of the run started at startedAt.
+// It returns false if the job no longer belongs to that run.
+func (r *JobRepository) MarkCompleted(ctx context.Context, id uuid.UUID, startedAt time.Time, result []byte) (bool, error) {
+	query := squirrel.Update("processing_jobs").
+		Set("status", models.JobStatusCompleted).
+		Set("progress", 100).
+		Set("result", result).
+		Set("finished_at", squirrel.Expr("NOW()")).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(runWhere(id, startedAt)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := r.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// MarkFailed stores the error of the run started at startedAt.
+// It returns false if the job no longer belongs to that run.
+func (r *JobRepository) MarkFailed(ctx context.Context, id uuid.UUID, startedAt time.Time, errMsg string) (bool, error) {
+	query := squirrel.Update("processing_jobs").
+		Set("status", models.JobStatusFailed).
+		Set("error", errMsg).
+		Set("finished_at", squirrel.Expr("NOW()")).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(runWhere(id, startedAt)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := r.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// RequeueStale returns running jobs that have not reported progress for longer
+// than staleAfter back to pending. Such jobs belong to a worker that was killed.
+func (r *JobRepository) RequeueStale(ctx context.Context, staleAfter time.Duration) (int64, error) {
+	query := squirrel.Update("processing_jobs").
+		Set("status", models.JobStatusPending).
+		Set("stage", "").
+		Set("progress", 0).
+		Set("started_at", nil).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"status": models.JobStatusRunning}).
+		Where(squirrel.Lt{"updated_at": time.Now().Add(-staleAfter)}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := r.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Release returns a running job to the queue, e.g. when the worker is shutting down
+func (r *JobRepository) Release(ctx context.Context, id uuid.UUID, startedAt time.Time) error {
+	query := squirrel.Update("processing_jobs").
+		Set("status", models.JobStatusPending).
+		Set("stage", "").
+		Set("progress", 0).
+		Set("started_at", nil).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(runWhere(id, startedAt)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, sql, args...)
+	return err
+}
@@ -2,15 +2,50 @@ package repository
 
 import (
 	"context"
-	"rag-iishka/internal/models"
+	"encoding/json"
 	"math"
+	"rag-iishka/internal/models"
 
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pgvector/pgvector-go"
 	"go.uber.org/zap"
 )
 
+// knowledgeColumns are selected by all read queries; the embedding itself is never read back
+var knowledgeColumns = []string{
+	"id", "source_id", "chunk_index", "page_start", "page_end", "token_count",
+	"type", "title", "content", "metadata", "created_at", "updated_at",
+}
+
+// knowledgeInsertBatchSize keeps a multi-row insert below the PostgreSQL limit of 65535 parameters
+const knowledgeInsertBatchSize = 1000
+
+// EmbeddingDimensions is the size of the knowledge_base.embedding column, vector(1024).
+// Embeddings of another size are rejected by PostgreSQL.
+const EmbeddingDimensions = 1024
+
+// KnowledgeFilter restricts knowledge search to a part of the knowledge base
+type KnowledgeFilter struct {
+	Type     *models.KnowledgeType
+	Metadata map[string]string // values the metadata JSON must contain, e.g. {"bank": "Сбербанк"}
+}
+
+func (f KnowledgeFilter) apply(query squirrel.SelectBuilder) (squirrel.SelectBuilder, error) {
+	if f.Type != nil {
+		query = query.Where(squirrel.Eq{"type": *f.Type})
+	}
+	if len(f.Metadata) > 0 {
+		metadata, err := json.Marshal(f.Metadata)
+		if err != nil {
+			return query, err
+		}
+		query = query.Where("metadata @> ?::jsonb", string(metadata))
+	}
+	return query, nil
+}
+
 type KnowledgeRepository struct {
 	db     *pgxpool.Pool
 	logger *zap.Logger
@@ -24,15 +59,64 @@ func NewKnowledgeRepository(db *pgxpool.Pool, logger *zap.Logger) *KnowledgeRepo
 }
 
 func (r *KnowledgeRepository) Create(ctx context.Context, kb *models.KnowledgeBase) error {
-	// Convert []float32 to pgvector format
-	embeddingArray := pgtype.FlatArray[float32]{}
-	for _, v := range kb.Embedding {
-		embeddingArray = append(embeddingArray, v)
+	return r.CreateBatch(ctx, []*models.KnowledgeBase{kb})
+}
+
+// CreateBatch inserts the chunks of a source
+func (r *KnowledgeRepository) CreateBatch(ctx context.Context, entries []*models.KnowledgeBase) error {
+	for start := 0; start < len(entries); start += knowledgeInsertBatchSize {
+		end := start + knowledgeInsertBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		builder := squirrel.Insert("knowledge_base").
+			Columns("id", "source_id", "chunk_index", "page_start", "page_end", "token_count",
+				"type", "title", "content", "embedding", "embedding_model", "metadata", "created_at", "updated_at").
+			PlaceholderFormat(squirrel.Dollar)
+
+		for _, kb := range entries[start:end] {
+			builder = builder.Values(kb.ID, kb.SourceID, kb.ChunkIndex, kb.PageStart, kb.PageEnd, kb.TokenCount,
+				kb.Type, kb.Title, kb.Content, toVector(kb.Embedding), kb.EmbeddingModel, toJSONB(kb.Metadata), kb.CreatedAt, kb.UpdatedAt)
+		}
+
+		sql, args, err := builder.ToSql()
+		if err != nil {
+			return err
+		}
+
+		if _, err := conn(ctx, r.db).Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteLegacyBySourceFile removes entries of a source file created before chunking,
+// when the whole file was stored as one entry without a source
+func (r *KnowledgeRepository) DeleteLegacyBySourceFile(ctx context.Context, sourceFile string) error {
+	query := squirrel.Delete("knowledge_base").
+		Where(squirrel.Eq{"source_id": nil}).
+		Where(squirrel.Expr("metadata->>'source_file' = ?", sourceFile)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
 	}
 
-	query := squirrel.Insert("knowledge_base").
-		Columns("id", "type", "title", "content", "embedding", "metadata", "created_at", "updated_at").
-		Values(kb.ID, kb.Type, kb.Title, kb.Content, embeddingArray, kb.Metadata, kb.CreatedAt, kb.UpdatedAt).
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+// UpdateEmbedding stores the embedding of an existing entry
+func (r *KnowledgeRepository) UpdateEmbedding(ctx context.Context, kb *models.KnowledgeBase) error {
+	query := squirrel.Update("knowledge_base").
+		Set("embedding", toVector(kb.Embedding)).
+		Set("embedding_model", kb.EmbeddingModel).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"id": kb.ID}).
 		PlaceholderFormat(squirrel.Dollar)
 
 	sql, args, err := query.ToSql()
@@ -40,76 +124,90 @@ func (r *KnowledgeRepository) Create(ctx context.Context, kb *models.KnowledgeBa
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
 	return err
 }
 
-func (r *KnowledgeRepository) SearchSimilar(ctx context.Context, embedding []float32, topK int, knowledgeType *models.KnowledgeType) ([]*models.KnowledgeBase, error) {
-	// Convert []float32 to pgvector format
-	embeddingArray := pgtype.FlatArray[float32]{}
-	for _, v := range embedding {
-		embeddingArray = append(embeddingArray, v)
+// ListWithoutEmbedding returns entries that have no embedding of the given model yet
+func (r *KnowledgeRepository) ListWithoutEmbedding(ctx context.Context, model string, limit int) ([]*models.KnowledgeBase, error) {
+	query := squirrel.Select(knowledgeColumns...).
+		From("knowledge_base").
+		Where(squirrel.Or{
+			squirrel.Eq{"embedding": nil},
+			squirrel.NotEq{"embedding_model": model},
+		}).
+		OrderBy("created_at ASC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
 	}
 
-	// Build query with cosine similarity
-	query := squirrel.Select("id", "type", "title", "content", "embedding", "metadata", "created_at", "updated_at",
-		"(embedding <=> $1) as distance").
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanKnowledge(rows)
+}
+
+// SearchSimilar returns the entries closest to the embedding by cosine distance.
+// Only entries embedded with the same model are compared.
+func (r *KnowledgeRepository) SearchSimilar(ctx context.Context, embedding []float32, model string, topK int, filter KnowledgeFilter) ([]*models.KnowledgeBase, error) {
+	query := squirrel.Select(knowledgeColumns...).
 		From("knowledge_base").
-		OrderBy("distance ASC").
+		Where(squirrel.NotEq{"embedding": nil}).
+		Where(squirrel.Eq{"embedding_model": model}).
+		OrderByClause("embedding <=> ?", pgvector.NewVector(embedding)).
 		Limit(uint64(topK)).
 		PlaceholderFormat(squirrel.Dollar)
 
-	if knowledgeType != nil {
-		query = query.Where(squirrel.Eq{"type": *knowledgeType})
+	query, err := filter.apply(query)
+	if err != nil {
+		return nil, err
 	}
 
-	// Note: This is a simplified version. In production, you'd use pgvector's <=> operator
-	// For now, we'll use a basic text search approach
 	sql, args, err := query.ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	// For now, return empty results as pgvector integration requires additional setup
-	// In production, you would use: SELECT *, embedding <=> $1::vector AS distance FROM knowledge_base ORDER BY distance LIMIT $2
-	rows, err := r.db.Query(ctx, sql, args...)
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []*models.KnowledgeBase
-	for rows.Next() {
-		var kb models.KnowledgeBase
-		var distance float64
-		var embeddingData pgtype.FlatArray[float32]
-		
-		if err := rows.Scan(
-			&kb.ID, &kb.Type, &kb.Title, &kb.Content, &embeddingData, &kb.Metadata, &kb.CreatedAt, &kb.UpdatedAt, &distance,
-		); err != nil {
-			return nil, err
-		}
-		
-		kb.Embedding = []float32(embeddingData)
-		results = append(results, &kb)
-	}
-
-	return results, nil
+	return scanKnowledge(rows)
 }
 
-// SimpleTextSearch performs a basic text search (fallback when embeddings are not available)
-func (r *KnowledgeRepository) SimpleTextSearch(ctx context.Context, queryText string, topK int, knowledgeType *models.KnowledgeType) ([]*models.KnowledgeBase, error) {
-	query := squirrel.Select("id", "type", "title", "content", "embedding", "metadata", "created_at", "updated_at").
+// FullTextSearch ranks entries by ts_rank_cd over the Russian full-text indexes on title and content.
+// queryText uses websearch_to_tsquery syntax; matches in the title weigh twice as much as in the content.
+func (r *KnowledgeRepository) FullTextSearch(ctx context.Context, queryText string, topK int, filter KnowledgeFilter) ([]*models.KnowledgeBase, error) {
+	const (
+		titleVector   = "to_tsvector('russian', title)"
+		contentVector = "to_tsvector('russian', content)"
+	)
+
+	query := squirrel.Select(knowledgeColumns...).
 		From("knowledge_base").
-		Where(squirrel.Or{
-			squirrel.ILike{"title": "%" + queryText + "%"},
-			squirrel.ILike{"content": "%" + queryText + "%"},
-		}).
+		Where(squirrel.Expr(
+			titleVector+" @@ websearch_to_tsquery('russian', ?) OR "+contentVector+" @@ websearch_to_tsquery('russian', ?)",
+			queryText, queryText,
+		)).
+		OrderByClause(
+			"2 * ts_rank_cd("+titleVector+", websearch_to_tsquery('russian', ?)) + ts_rank_cd("+contentVector+", websearch_to_tsquery('russian', ?)) DESC",
+			queryText, queryText,
+		).
 		Limit(uint64(topK)).
 		PlaceholderFormat(squirrel.Dollar)
 
-	if knowledgeType != nil {
-		query = query.Where(squirrel.Eq{"type": *knowledgeType})
+	query, err := filter.apply(query)
+	if err != nil {
+		return nil, err
 	}
 
 	sql, args, err := query.ToSql()
@@ -117,28 +215,50 @@ func (r *KnowledgeRepository) SimpleTextSearch(ctx context.Context, queryText st
 		return nil, err
 	}
 
-	rows, err := r.db.Query(ctx, sql, args...)
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanKnowledge(rows)
+}
+
+// scanKnowledge scans rows selected with knowledgeColumns
+func scanKnowledge(rows pgx.Rows) ([]*models.KnowledgeBase, error) {
 	var results []*models.KnowledgeBase
 	for rows.Next() {
 		var kb models.KnowledgeBase
-		var embeddingData pgtype.FlatArray[float32]
-		
+		var metadata *string
 		if err := rows.Scan(
-			&kb.ID, &kb.Type, &kb.Title, &kb.Content, &embeddingData, &kb.Metadata, &kb.CreatedAt, &kb.UpdatedAt,
+			&kb.ID, &kb.SourceID, &kb.ChunkIndex, &kb.PageStart, &kb.PageEnd, &kb.TokenCount,
+			&kb.Type, &kb.Title, &kb.Content, &metadata, &kb.CreatedAt, &kb.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
-		
-		kb.Embedding = []float32(embeddingData)
+		if metadata != nil {
+			kb.Metadata = *metadata
+		}
 		results = append(results, &kb)
 	}
 
-	return results, nil
+	return results, rows.Err()
+}
+
+// toVector converts an embedding to a pgvector value; an empty embedding is stored as NULL
+func toVector(embedding []float32) interface{} {
+	if len(embedding) == 0 {
+		return nil
+	}
+	return pgvector.NewVector(embedding)
+}
+
+// toJSONB converts a JSON string to a JSONB value; an empty string is stored as NULL
+func toJSONB(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
 }
 
 // Helper function to calculate cosine similarity (for fallback)
@@ -146,18 +266,17 @@ func cosineSimilarity(a, b []float32) float64 {
 	if len(a) != len(b) {
 		return 0
 	}
-	
+
 	var dotProduct, normA, normB float64
 	for i := range a {
 		dotProduct += float64(a[i] * b[i])
 		normA += float64(a[i] * a[i])
 		normB += float64(b[i] * b[i])
 	}
-	
+
 	if normA == 0 || normB == 0 {
 		return 0
 	}
-	
+
 	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
 }
-
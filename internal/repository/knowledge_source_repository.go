@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"rag-iishka/internal/models"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type KnowledgeSourceRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewKnowledgeSourceRepository(db *pgxpool.Pool, logger *zap.Logger) *KnowledgeSourceRepository {
+	return &KnowledgeSourceRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *KnowledgeSourceRepository) Create(ctx context.Context, src *models.KnowledgeSource) error {
+	query := squirrel.Insert("knowledge_sources").
+		Columns("id", "type", "title", "source_file", "file_hash", "page_count", "metadata", "created_at", "updated_at").
+		Values(src.ID, src.Type, src.Title, src.SourceFile, src.FileHash, src.PageCount, toJSONB(src.Metadata), src.CreatedAt, src.UpdatedAt).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+// GetBySourceFile returns pgx.ErrNoRows if the file has not been seeded yet
+func (r *KnowledgeSourceRepository) GetBySourceFile(ctx context.Context, sourceFile string) (*models.KnowledgeSource, error) {
+	query := squirrel.Select("id", "type", "title", "source_file", "file_hash", "page_count", "metadata", "created_at", "updated_at").
+		From("knowledge_sources").
+		Where(squirrel.Eq{"source_file": sourceFile}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var src models.KnowledgeSource
+	var metadata *string
+	err = conn(ctx, r.db).QueryRow(ctx, sql, args...).Scan(
+		&src.ID, &src.Type, &src.Title, &src.SourceFile, &src.FileHash, &src.PageCount, &metadata, &src.CreatedAt, &src.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		src.Metadata = *metadata
+	}
+
+	return &src, nil
+}
+
+// Delete removes the source; its chunks are removed by ON DELETE CASCADE
+func (r *KnowledgeSourceRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := squirrel.Delete("knowledge_sources").
+		Where(squirrel.Eq{"id": id}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"rag-iishka/internal/models"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/Masterminds/squirrel"
 	"go.uber.org/zap"
 )
 
@@ -23,31 +23,80 @@ func NewRecommendationRepository(db *pgxpool.Pool, logger *zap.Logger) *Recommen
 }
 
 func (r *RecommendationRepository) Create(ctx context.Context, rec *models.Recommendation) error {
-	query := squirrel.Insert("recommendations").
-		Columns("id", "transaction_id", "user_id", "title", "description", "potential_savings", "source", "created_at").
-		Values(rec.ID, rec.TransactionID, rec.UserID, rec.Title, rec.Description, rec.PotentialSavings, rec.Source, rec.CreatedAt).
+	return r.CreateBatch(ctx, []*models.Recommendation{rec})
+}
+
+// CreateBatch inserts recommendations with their transactions and citations.
+// Call it within Transactor.WithinTx so that a recommendation is never stored without its citations.
+func (r *RecommendationRepository) CreateBatch(ctx context.Context, recommendations []*models.Recommendation) error {
+	if len(recommendations) == 0 {
+		return nil
+	}
+
+	builder := squirrel.Insert("recommendations").
+		Columns("id", "transaction_id", "user_id", "title", "description", "potential_savings", "savings_basis", "confidence", "source", "created_at").
 		PlaceholderFormat(squirrel.Dollar)
 
-	sql, args, err := query.ToSql()
+	for _, rec := range recommendations {
+		builder = builder.Values(rec.ID, rec.TransactionID, rec.UserID, rec.Title, rec.Description, rec.PotentialSavings, rec.SavingsBasis, rec.Confidence, rec.Source, rec.CreatedAt)
+	}
+
+	sql, args, err := builder.ToSql()
 	if err != nil {
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
-	return err
+	if _, err := conn(ctx, r.db).Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+
+	if err := r.createTransactionLinks(ctx, recommendations); err != nil {
+		return err
+	}
+
+	return r.createCitations(ctx, recommendations)
 }
 
-func (r *RecommendationRepository) CreateBatch(ctx context.Context, recommendations []*models.Recommendation) error {
-	if len(recommendations) == 0 {
-		return nil
+// createTransactionLinks stores the transactions each recommendation covers;
+// the main transaction is always linked, even if TransactionIDs is empty
+func (r *RecommendationRepository) createTransactionLinks(ctx context.Context, recommendations []*models.Recommendation) error {
+	builder := squirrel.Insert("recommendation_transactions").
+		Columns("recommendation_id", "transaction_id").
+		Suffix("ON CONFLICT DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar)
+
+	for _, rec := range recommendations {
+		builder = builder.Values(rec.ID, rec.TransactionID)
+		for _, transactionID := range rec.TransactionIDs {
+			if transactionID != rec.TransactionID {
+				builder = builder.Values(rec.ID, transactionID)
+			}
+		}
 	}
 
-	builder := squirrel.Insert("recommendations").
-		Columns("id", "transaction_id", "user_id", "title", "description", "potential_savings", "source", "created_at").
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+func (r *RecommendationRepository) createCitations(ctx context.Context, recommendations []*models.Recommendation) error {
+	builder := squirrel.Insert("recommendation_citations").
+		Columns("recommendation_id", "number", "knowledge_id", "type", "title", "source_file", "page_start", "page_end").
 		PlaceholderFormat(squirrel.Dollar)
 
+	count := 0
 	for _, rec := range recommendations {
-		builder = builder.Values(rec.ID, rec.TransactionID, rec.UserID, rec.Title, rec.Description, rec.PotentialSavings, rec.Source, rec.CreatedAt)
+		for _, c := range rec.Citations {
+			builder = builder.Values(rec.ID, c.Number, c.KnowledgeID, c.Type, c.Title, c.SourceFile, c.PageStart, c.PageEnd)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
 	}
 
 	sql, args, err := builder.ToSql()
@@ -55,15 +104,35 @@ func (r *RecommendationRepository) CreateBatch(ctx context.Context, recommendati
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
 	return err
 }
 
+// GetByTransactionID returns recommendations covering the transaction,
+// including document-level ones where it is not the main transaction
 func (r *RecommendationRepository) GetByTransactionID(ctx context.Context, transactionID uuid.UUID) ([]*models.Recommendation, error) {
-	query := squirrel.Select("id", "transaction_id", "user_id", "title", "description", "potential_savings", "source", "created_at").
-		From("recommendations").
-		Where(squirrel.Eq{"transaction_id": transactionID}).
-		OrderBy("potential_savings DESC").
+	query := squirrel.Select("r.id", "r.transaction_id", "r.user_id", "r.title", "r.description", "r.potential_savings", "r.savings_basis", "r.confidence", "r.source", "r.created_at").
+		From("recommendations r").
+		Join("recommendation_transactions rt ON rt.recommendation_id = r.id").
+		Where(squirrel.Eq{"rt.transaction_id": transactionID}).
+		OrderBy("r.potential_savings DESC").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.query(ctx, sql, args...)
+}
+
+// GetByDocumentID returns recommendations for all transactions of the document
+func (r *RecommendationRepository) GetByDocumentID(ctx context.Context, documentID uuid.UUID) ([]*models.Recommendation, error) {
+	query := squirrel.Select("r.id", "r.transaction_id", "r.user_id", "r.title", "r.description", "r.potential_savings", "r.savings_basis", "r.confidence", "r.source", "r.created_at").
+		From("recommendations r").
+		Join("transactions t ON t.id = r.transaction_id").
+		Where(squirrel.Eq{"t.document_id": documentID}).
+		OrderBy("r.potential_savings DESC").
 		PlaceholderFormat(squirrel.Dollar)
 
 	sql, args, err := query.ToSql()
@@ -71,7 +140,12 @@ func (r *RecommendationRepository) GetByTransactionID(ctx context.Context, trans
 		return nil, err
 	}
 
-	rows, err := r.db.Query(ctx, sql, args...)
+	return r.query(ctx, sql, args...)
+}
+
+// query scans recommendations and loads their transactions and citations
+func (r *RecommendationRepository) query(ctx context.Context, sql string, args ...interface{}) ([]*models.Recommendation, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,13 +155,112 @@ func (r *RecommendationRepository) GetByTransactionID(ctx context.Context, trans
 	for rows.Next() {
 		var rec models.Recommendation
 		if err := rows.Scan(
-			&rec.ID, &rec.TransactionID, &rec.UserID, &rec.Title, &rec.Description, &rec.PotentialSavings, &rec.Source, &rec.CreatedAt,
+			&rec.ID, &rec.TransactionID, &rec.UserID, &rec.Title, &rec.Description, &rec.PotentialSavings, &rec.SavingsBasis, &rec.Confidence, &rec.Source, &rec.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
 		recommendations = append(recommendations, &rec)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := r.loadTransactionLinks(ctx, recommendations); err != nil {
+		return nil, err
+	}
+	if err := r.loadCitations(ctx, recommendations); err != nil {
+		return nil, err
+	}
 
 	return recommendations, nil
 }
 
+// loadTransactionLinks fills TransactionIDs of the recommendations with one query
+func (r *RecommendationRepository) loadTransactionLinks(ctx context.Context, recommendations []*models.Recommendation) error {
+	if len(recommendations) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*models.Recommendation, len(recommendations))
+	ids := make([]uuid.UUID, 0, len(recommendations))
+	for _, rec := range recommendations {
+		byID[rec.ID] = rec
+		ids = append(ids, rec.ID)
+	}
+
+	query := squirrel.Select("rt.recommendation_id", "rt.transaction_id").
+		From("recommendation_transactions rt").
+		Join("transactions t ON t.id = rt.transaction_id").
+		Where(squirrel.Eq{"rt.recommendation_id": ids}).
+		OrderBy("rt.recommendation_id", "t.amount DESC", "t.id").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recommendationID, transactionID uuid.UUID
+		if err := rows.Scan(&recommendationID, &transactionID); err != nil {
+			return err
+		}
+		if rec, ok := byID[recommendationID]; ok {
+			rec.TransactionIDs = append(rec.TransactionIDs, transactionID)
+		}
+	}
+
+	return rows.Err()
+}
+
+// loadCitations fills Citations of the recommendations with one query
+func (r *RecommendationRepository) loadCitations(ctx context.Context, recommendations []*models.Recommendation) error {
+	if len(recommendations) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*models.Recommendation, len(recommendations))
+	ids := make([]uuid.UUID, 0, len(recommendations))
+	for _, rec := range recommendations {
+		byID[rec.ID] = rec
+		ids = append(ids, rec.ID)
+	}
+
+	query := squirrel.Select("recommendation_id", "number", "knowledge_id", "type", "title", "source_file", "page_start", "page_end").
+		From("recommendation_citations").
+		Where(squirrel.Eq{"recommendation_id": ids}).
+		OrderBy("recommendation_id", "number").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.RecommendationCitation
+		if err := rows.Scan(
+			&c.RecommendationID, &c.Number, &c.KnowledgeID, &c.Type, &c.Title, &c.SourceFile, &c.PageStart, &c.PageEnd,
+		); err != nil {
+			return err
+		}
+		if rec, ok := byID[c.RecommendationID]; ok {
+			rec.Citations = append(rec.Citations, c)
+		}
+	}
+
+	return rows.Err()
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"rag-iishka/internal/models"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/Masterminds/squirrel"
 	"go.uber.org/zap"
 )
 
@@ -24,8 +24,8 @@ func NewTransactionRepository(db *pgxpool.Pool, logger *zap.Logger) *Transaction
 
 func (r *TransactionRepository) Create(ctx context.Context, tx *models.Transaction) error {
 	query := squirrel.Insert("transactions").
-		Columns("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "date", "created_at", "updated_at").
-		Values(tx.ID, tx.DocumentID, tx.UserID, tx.Amount, tx.Currency, tx.Description, tx.Category, tx.LLMDescription, tx.Date, tx.CreatedAt, tx.UpdatedAt).
+		Columns("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "bank", "date", "created_at", "updated_at").
+		Values(tx.ID, tx.DocumentID, tx.UserID, tx.Amount, tx.Currency, tx.Description, tx.Category, tx.LLMDescription, tx.Bank, tx.Date, tx.CreatedAt, tx.UpdatedAt).
 		PlaceholderFormat(squirrel.Dollar)
 
 	sql, args, err := query.ToSql()
@@ -33,7 +33,7 @@ func (r *TransactionRepository) Create(ctx context.Context, tx *models.Transacti
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
 	return err
 }
 
@@ -43,11 +43,40 @@ func (r *TransactionRepository) CreateBatch(ctx context.Context, transactions []
 	}
 
 	builder := squirrel.Insert("transactions").
-		Columns("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "date", "created_at", "updated_at").
+		Columns("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "bank", "date", "created_at", "updated_at").
+		PlaceholderFormat(squirrel.Dollar)
+
+	for _, tx := range transactions {
+		builder = builder.Values(tx.ID, tx.DocumentID, tx.UserID, tx.Amount, tx.Currency, tx.Description, tx.Category, tx.LLMDescription, tx.Bank, tx.Date, tx.CreatedAt, tx.UpdatedAt)
+	}
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn(ctx, r.db).Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+
+	return r.createItems(ctx, transactions)
+}
+
+// createItems inserts receipt line items of the transactions
+func (r *TransactionRepository) createItems(ctx context.Context, transactions []*models.Transaction) error {
+	builder := squirrel.Insert("transaction_items").
+		Columns("id", "transaction_id", "position", "name", "quantity", "unit_price", "total", "vat_rate", "category", "created_at").
 		PlaceholderFormat(squirrel.Dollar)
 
+	count := 0
 	for _, tx := range transactions {
-		builder = builder.Values(tx.ID, tx.DocumentID, tx.UserID, tx.Amount, tx.Currency, tx.Description, tx.Category, tx.LLMDescription, tx.Date, tx.CreatedAt, tx.UpdatedAt)
+		for _, item := range tx.Items {
+			builder = builder.Values(item.ID, tx.ID, item.Position, item.Name, item.Quantity, item.UnitPrice, item.Total, item.VATRate, item.Category, item.CreatedAt)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
 	}
 
 	sql, args, err := builder.ToSql()
@@ -55,12 +84,54 @@ func (r *TransactionRepository) CreateBatch(ctx context.Context, transactions []
 		return err
 	}
 
-	_, err = r.db.Exec(ctx, sql, args...)
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
 	return err
 }
 
+// DeleteByDocumentID removes all transactions of the document.
+// Their items and recommendations are removed by ON DELETE CASCADE.
+func (r *TransactionRepository) DeleteByDocumentID(ctx context.Context, documentID uuid.UUID) error {
+	query := squirrel.Delete("transactions").
+		Where(squirrel.Eq{"document_id": documentID}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+func (r *TransactionRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Transaction, error) {
+	query := squirrel.Select("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "bank", "date", "created_at", "updated_at").
+		From("transactions").
+		Where(squirrel.Eq{"id": id}).
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var tx models.Transaction
+	err = conn(ctx, r.db).QueryRow(ctx, sql, args...).Scan(
+		&tx.ID, &tx.DocumentID, &tx.UserID, &tx.Amount, &tx.Currency, &tx.Description, &tx.Category, &tx.LLMDescription, &tx.Bank, &tx.Date, &tx.CreatedAt, &tx.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.loadItems(ctx, []*models.Transaction{&tx}); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
 func (r *TransactionRepository) GetByDocumentID(ctx context.Context, documentID uuid.UUID) ([]*models.Transaction, error) {
-	query := squirrel.Select("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "date", "created_at", "updated_at").
+	query := squirrel.Select("id", "document_id", "user_id", "amount", "currency", "description", "category", "llm_description", "bank", "date", "created_at", "updated_at").
 		From("transactions").
 		Where(squirrel.Eq{"document_id": documentID}).
 		OrderBy("date DESC").
@@ -71,7 +142,7 @@ func (r *TransactionRepository) GetByDocumentID(ctx context.Context, documentID
 		return nil, err
 	}
 
-	rows, err := r.db.Query(ctx, sql, args...)
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,13 +152,65 @@ func (r *TransactionRepository) GetByDocumentID(ctx context.Context, documentID
 	for rows.Next() {
 		var tx models.Transaction
 		if err := rows.Scan(
-			&tx.ID, &tx.DocumentID, &tx.UserID, &tx.Amount, &tx.Currency, &tx.Description, &tx.Category, &tx.LLMDescription, &tx.Date, &tx.CreatedAt, &tx.UpdatedAt,
+			&tx.ID, &tx.DocumentID, &tx.UserID, &tx.Amount, &tx.Currency, &tx.Description, &tx.Category, &tx.LLMDescription, &tx.Bank, &tx.Date, &tx.CreatedAt, &tx.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
 		transactions = append(transactions, &tx)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := r.loadItems(ctx, transactions); err != nil {
+		return nil, err
+	}
 
 	return transactions, nil
 }
 
+// loadItems fills Items of the transactions with one query
+func (r *TransactionRepository) loadItems(ctx context.Context, transactions []*models.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*models.Transaction, len(transactions))
+	ids := make([]uuid.UUID, 0, len(transactions))
+	for _, tx := range transactions {
+		byID[tx.ID] = tx
+		ids = append(ids, tx.ID)
+	}
+
+	query := squirrel.Select("id", "transaction_id", "position", "name", "quantity", "unit_price", "total", "vat_rate", "category", "created_at").
+		From("transaction_items").
+		Where(squirrel.Eq{"transaction_id": ids}).
+		OrderBy("transaction_id", "position").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.TransactionItem
+		if err := rows.Scan(
+			&item.ID, &item.TransactionID, &item.Position, &item.Name, &item.Quantity, &item.UnitPrice, &item.Total, &item.VATRate, &item.Category, &item.CreatedAt,
+		); err != nil {
+			return err
+		}
+		if tx, ok := byID[item.TransactionID]; ok {
+			tx.Items = append(tx.Items, item)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"rag-iishka/internal/models"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+type ValidationFailureRepository struct {
+	db     *pgxpool.Pool
+	logger *zap.Logger
+}
+
+func NewValidationFailureRepository(db *pgxpool.Pool, logger *zap.Logger) *ValidationFailureRepository {
+	return &ValidationFailureRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *ValidationFailureRepository) CreateBatch(ctx context.Context, failures []*models.ValidationFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	builder := squirrel.Insert("validation_failures").
+		Columns("id", "document_id", "stage", "attempt", "errors", "response", "created_at").
+		PlaceholderFormat(squirrel.Dollar)
+
+	for _, f := range failures {
+		errs, err := json.Marshal(f.Errors)
+		if err != nil {
+			return err
+		}
+		builder = builder.Values(f.ID, f.DocumentID, f.Stage, f.Attempt, string(errs), f.Response, f.CreatedAt)
+	}
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn(ctx, r.db).Exec(ctx, sql, args...)
+	return err
+}
+
+// GetByDocumentID returns failures of the document, oldest first
+func (r *ValidationFailureRepository) GetByDocumentID(ctx context.Context, documentID uuid.UUID) ([]*models.ValidationFailure, error) {
+	query := squirrel.Select("id", "document_id", "stage", "attempt", "errors", "response", "created_at").
+		From("validation_failures").
+		Where(squirrel.Eq{"document_id": documentID}).
+		OrderBy("created_at ASC", "attempt ASC").
+		PlaceholderFormat(squirrel.Dollar)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []*models.ValidationFailure
+	for rows.Next() {
+		var f models.ValidationFailure
+		var errs []byte
+		if err := rows.Scan(&f.ID, &f.DocumentID, &f.Stage, &f.Attempt, &errs, &f.Response, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(errs, &f.Errors); err != nil {
+			return nil, err
+		}
+		failures = append(failures, &f)
+	}
+
+	return failures, rows.Err()
+}
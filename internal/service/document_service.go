@@ -1,287 +1,1261 @@
 package service
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"rag-iishka/internal/dto"
+	"rag-iishka/internal/importer"
 	"rag-iishka/internal/models"
 	"rag-iishka/internal/repository"
+	"rag-iishka/pkg/config"
+	"rag-iishka/pkg/fiscal"
+	"rag-iishka/pkg/imagefile"
+	"rag-iishka/pkg/storage"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
+	"golang.org/x/text/encoding/charmap"
 )
 
+var (
+	ErrDocumentNotFound     = errors.New("document not found")
+	ErrDocumentAccessDenied = errors.New("document belongs to another user")
+	ErrTransactionNotFound  = errors.New("transaction not found")
+
+	// Upload validation errors of UploadDocument: files that neither OCR nor the statement importer
+	// can read, and files over UPLOAD_MAX_FILE_SIZE_MB or UPLOAD_MAX_PAGES
+	ErrUnsupportedFileFormat = errors.New("unsupported file format")
+	ErrFileTooLarge          = errors.New("file is too large")
+	ErrTooManyPages          = errors.New("too many pages")
+
+	// Errors of UploadDocuments: more files than UPLOAD_MAX_BATCH_FILES, and ZIP archives that cannot be read
+	ErrTooManyFiles   = errors.New("too many files")
+	ErrInvalidArchive = errors.New("invalid ZIP archive")
+)
+
+// Revisions of the processing pipeline. Bump the matching constant when OCR or
+// a prompt changes: documents processed by an older revision are then re-processed
+// even without force.
+const (
+	ocrRevision                  = 3
+	analysisPromptRevision       = 6
+	recommendationPromptRevision = 5
+)
+
+// ProcessingVersion identifies the pipeline revision stored with document results
+var ProcessingVersion = fmt.Sprintf("ocr.%d-analysis.%d-recommendations.%d",
+	ocrRevision, analysisPromptRevision, recommendationPromptRevision)
+
+// ProgressFunc receives the current processing stage and overall progress in percent
+type ProgressFunc func(stage models.JobStage, progress int)
+
 type DocumentService struct {
-	docRepo    *repository.DocumentRepository
-	txRepo     *repository.TransactionRepository
-	recRepo    *repository.RecommendationRepository
-	ocrService *OCRService
-	llmService *LLMService
-	recService *RecommendationService
-	uploadDir  string
-	logger     *zap.Logger
+	docRepo     *repository.DocumentRepository
+	txRepo      *repository.TransactionRepository
+	recRepo     *repository.RecommendationRepository
+	failRepo    *repository.ValidationFailureRepository
+	receiptRepo *repository.FiscalReceiptRepository
+	transactor  *repository.Transactor
+	ocrService  *OCRService
+	llmService  *LLMService
+	recService  *RecommendationService
+	importer    *importer.Importer
+	storage     storage.Storage
+	uploadCfg   *config.UploadConfig
+	logger      *zap.Logger
 }
 
 func NewDocumentService(
 	docRepo *repository.DocumentRepository,
 	txRepo *repository.TransactionRepository,
 	recRepo *repository.RecommendationRepository,
+	failRepo *repository.ValidationFailureRepository,
+	receiptRepo *repository.FiscalReceiptRepository,
+	transactor *repository.Transactor,
 	ocrService *OCRService,
 	llmService *LLMService,
 	recService *RecommendationService,
-	uploadDir string,
+	statementImporter *importer.Importer,
+	fileStorage storage.Storage,
+	uploadCfg *config.UploadConfig,
 	logger *zap.Logger,
 ) *DocumentService {
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		logger.Warn("Failed to create upload directory", zap.Error(err))
-	}
-
 	return &DocumentService{
-		docRepo:    docRepo,
-		txRepo:     txRepo,
-		recRepo:    recRepo,
-		ocrService: ocrService,
-		llmService: llmService,
-		recService: recService,
-		uploadDir:  uploadDir,
-		logger:     logger,
+		docRepo:     docRepo,
+		txRepo:      txRepo,
+		recRepo:     recRepo,
+		failRepo:    failRepo,
+		receiptRepo: receiptRepo,
+		transactor:  transactor,
+		ocrService:  ocrService,
+		llmService:  llmService,
+		recService:  recService,
+		importer:    statementImporter,
+		storage:     fileStorage,
+		uploadCfg:   uploadCfg,
+		logger:      logger,
 	}
 }
 
-// UploadDocument uploads and saves a document
+// UploadDocument uploads and saves a document.
+// The format is detected from the content, so files OCR cannot read are rejected here
+// with ErrUnsupportedFileFormat rather than when they are processed; files over the size
+// or page limits are rejected with ErrFileTooLarge and ErrTooManyPages.
+// A file the user has already uploaded is not stored again: the existing document is returned
+// with the duplicate flag.
 func (s *DocumentService) UploadDocument(ctx context.Context, userID uuid.UUID, file io.Reader, fileName string, docType models.DocumentType) (*dto.DocumentResponse, error) {
-	// Generate unique file name
-	fileID := uuid.New()
-	ext := filepath.Ext(fileName)
-	newFileName := fileID.String() + ext
-	filePath := filepath.Join(s.uploadDir, newFileName)
+	reader := bufio.NewReaderSize(file, imagefile.SniffLength)
+	head, err := reader.Peek(imagefile.SniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
 
-	// Save file
-	dst, err := os.Create(filePath)
+	ext, err := s.uploadExtension(fileName, head)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is spooled to compute its content hash before it is stored
+	spool, err := os.CreateTemp("", "upload-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer dst.Close()
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
 
-	fileSize, err := io.Copy(dst, file)
+	hash := sha256.New()
+	fileSize, err := io.Copy(io.MultiWriter(spool, hash), io.LimitReader(reader, s.uploadCfg.MaxFileSize+1))
 	if err != nil {
-		os.Remove(filePath)
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
+	if fileSize > s.uploadCfg.MaxFileSize {
+		return nil, fmt.Errorf("%w: the limit is %d MB", ErrFileTooLarge, s.uploadCfg.MaxFileSize>>20)
+	}
+
+	digest := hash.Sum(nil)
+	contentHash := hex.EncodeToString(digest)
+	if existing, err := s.docRepo.GetByContentHash(ctx, userID, contentHash); err == nil {
+		return s.duplicateDocument(existing), nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check for duplicate document: %w", err)
+	}
+
+	if format := imagefile.Detect(head); format != imagefile.FormatUnknown {
+		pages, err := s.ocrService.CountPages(spool.Name(), format)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedFileFormat, err)
+		}
+		if pages > s.uploadCfg.MaxPages {
+			return nil, fmt.Errorf("%w: %d pages, the limit is %d", ErrTooManyPages, pages, s.uploadCfg.MaxPages)
+		}
+	}
+
+	key := storage.ContentKey(digest, ext)
+	exists, err := s.storage.Exists(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check stored file: %w", err)
+	}
+	if !exists {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to save file: %w", err)
+		}
+		if err := s.storage.Put(ctx, key, spool, fileSize); err != nil {
+			return nil, fmt.Errorf("failed to store file: %w", err)
+		}
+	}
 
-	// Create document record
+	// Create document record. The stored file is kept if this fails:
+	// other documents with the same content may refer to it.
 	now := time.Now()
+	documentID := uuid.New()
 	doc := &models.Document{
-		ID:        fileID,
-		UserID:    userID,
-		Type:      docType,
-		FileName:  fileName,
-		FileSize:  fileSize,
-		FileURL:   "/uploads/" + newFileName,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
-	if err := s.docRepo.Create(ctx, doc); err != nil {
-		os.Remove(filePath)
+		ID:          documentID,
+		UserID:      userID,
+		Type:        docType,
+		FileName:    fileName,
+		FileSize:    fileSize,
+		FileURL:     "/api/v1/documents/" + documentID.String() + "/file",
+		StorageKey:  key,
+		ContentHash: contentHash,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	inserted, err := s.docRepo.Create(ctx, doc)
+	if err != nil {
 		return nil, fmt.Errorf("failed to create document record: %w", err)
 	}
+	if !inserted {
+		// The same file was uploaded concurrently
+		existing, err := s.docRepo.GetByContentHash(ctx, userID, contentHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get duplicate document: %w", err)
+		}
+		return s.duplicateDocument(existing), nil
+	}
 
-	return &dto.DocumentResponse{
-		ID:        doc.ID.String(),
-		Type:      string(doc.Type),
-		FileName:  doc.FileName,
-		FileSize:  doc.FileSize,
-		FileURL:   doc.FileURL,
-		CreatedAt: doc.CreatedAt.Format(time.RFC3339),
-	}, nil
+	return toDocumentResponse(doc), nil
 }
 
-// ProcessDocument processes a document: OCR -> LLM analysis -> RAG -> recommendations
-func (s *DocumentService) ProcessDocument(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) (*dto.ProcessDocumentResponse, error) {
-	// 1. Get document
+// duplicateDocument is the response to an upload of a file the user has already uploaded
+func (s *DocumentService) duplicateDocument(doc *models.Document) *dto.DocumentResponse {
+	s.logger.Info("Duplicate document upload",
+		zap.String("document_id", doc.ID.String()),
+		zap.String("content_hash", doc.ContentHash),
+	)
+
+	response := toDocumentResponse(doc)
+	response.Duplicate = true
+	return response
+}
+
+// UploadFile is a file of a batch upload
+type UploadFile struct {
+	Name    string
+	Size    int64
+	Content io.ReaderAt
+}
+
+// UploadResult is the outcome of one file of a batch upload
+type UploadResult struct {
+	FileName string // path of the file inside the archive for files from a ZIP archive
+	Archive  string // name of the ZIP archive the file was taken from
+	Document *dto.DocumentResponse
+	Err      error
+}
+
+// UploadDocuments uploads several files at once. ZIP archives are unpacked and every file in them
+// becomes a document of its own. Each file is validated and deduplicated like in UploadDocument;
+// a file that fails does not stop the others, its error is returned in its result.
+// More than MaxBatchFiles files in the request give ErrTooManyFiles.
+func (s *DocumentService) UploadDocuments(ctx context.Context, userID uuid.UUID, files []UploadFile, docType models.DocumentType) ([]UploadResult, error) {
+	if len(files) > s.uploadCfg.MaxBatchFiles {
+		return nil, fmt.Errorf("%w: %d files, the limit is %d", ErrTooManyFiles, len(files), s.uploadCfg.MaxBatchFiles)
+	}
+
+	var results []UploadResult
+	for i, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !isZipArchive(file.Content) {
+			doc, err := s.UploadDocument(ctx, userID, io.NewSectionReader(file.Content, 0, file.Size), file.Name, docType)
+			results = append(results, UploadResult{FileName: file.Name, Document: doc, Err: err})
+			continue
+		}
+
+		// Files of archives count towards the limit of the request, leaving room for the files after the archive
+		remaining := s.uploadCfg.MaxBatchFiles - len(results) - (len(files) - i - 1)
+		results = append(results, s.uploadArchive(ctx, userID, file, docType, remaining)...)
+	}
+
+	return results, nil
+}
+
+// uploadArchive uploads the files of a ZIP archive. Archive bombs are stopped before anything
+// is unpacked: the number of files and their total size are checked from the archive directory,
+// and no file is read past MaxFileSize whatever size the directory claims.
+func (s *DocumentService) uploadArchive(ctx context.Context, userID uuid.UUID, file UploadFile, docType models.DocumentType, maxFiles int) []UploadResult {
+	archiveError := func(err error) []UploadResult {
+		return []UploadResult{{FileName: file.Name, Err: err}}
+	}
+
+	archive, err := zip.NewReader(file.Content, file.Size)
+	if err != nil {
+		return archiveError(fmt.Errorf("%w: %v", ErrInvalidArchive, err))
+	}
+	if isOfficeDocument(archive) {
+		return archiveError(ErrUnsupportedFileFormat)
+	}
+
+	var entries []*zip.File
+	var unpackedSize uint64
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || isArchiveJunk(entry.Name) {
+			continue
+		}
+		entries = append(entries, entry)
+		unpackedSize += entry.UncompressedSize64
+	}
+
+	switch {
+	case len(entries) == 0:
+		return archiveError(fmt.Errorf("%w: the archive has no files", ErrInvalidArchive))
+	case len(entries) > maxFiles:
+		return archiveError(fmt.Errorf("%w: the archive has %d files, the limit is %d", ErrTooManyFiles, len(entries), maxFiles))
+	case unpackedSize > uint64(s.uploadCfg.MaxArchiveSize):
+		return archiveError(fmt.Errorf("%w: the archive unpacks to %d MB, the limit is %d MB", ErrFileTooLarge, unpackedSize>>20, s.uploadCfg.MaxArchiveSize>>20))
+	}
+
+	results := make([]UploadResult, 0, len(entries))
+	for _, entry := range entries {
+		name := archiveEntryName(entry)
+		result := UploadResult{FileName: name, Archive: file.Name}
+
+		switch {
+		case ctx.Err() != nil:
+			result.Err = ctx.Err()
+		case entry.UncompressedSize64 > uint64(s.uploadCfg.MaxFileSize):
+			result.Err = fmt.Errorf("%w: the limit is %d MB", ErrFileTooLarge, s.uploadCfg.MaxFileSize>>20)
+		default:
+			result.Document, result.Err = s.uploadArchiveEntry(ctx, userID, entry, path.Base(name), docType)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (s *DocumentService) uploadArchiveEntry(ctx context.Context, userID uuid.UUID, entry *zip.File, fileName string, docType models.DocumentType) (*dto.DocumentResponse, error) {
+	content, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	defer content.Close()
+
+	doc, err := s.UploadDocument(ctx, userID, content, fileName, docType)
+	if isCorruptEntry(err) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return doc, err
+}
+
+// isCorruptEntry reports whether reading an archive entry failed because the archive is damaged
+func isCorruptEntry(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)
+}
+
+// isZipArchive reports whether the file starts with the signature of a ZIP archive
+func isZipArchive(content io.ReaderAt) bool {
+	signature := make([]byte, 4)
+	if _, err := content.ReadAt(signature, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(signature, []byte("PK\x03\x04")) || bytes.Equal(signature, []byte("PK\x05\x06"))
+}
+
+// isOfficeDocument reports whether a ZIP file is an office document (xlsx, docx, odt):
+// these are ZIP archives too, but not of files of the user
+func isOfficeDocument(archive *zip.Reader) bool {
+	for _, entry := range archive.File {
+		if entry.Name == "[Content_Types].xml" || entry.Name == "mimetype" {
+			return true
+		}
+	}
+	return false
+}
+
+// isArchiveJunk reports whether an archive entry is metadata added by the archiver
+// (macOS resource forks, .DS_Store, Thumbs.db) rather than a file of the user
+func isArchiveJunk(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || strings.EqualFold(base, "Thumbs.db")
+}
+
+// archiveEntryName returns the name of an archive entry. Archives made by Windows Explorer
+// store names in the OEM code page (cp866 for Russian) without the UTF-8 flag.
+func archiveEntryName(entry *zip.File) string {
+	if !entry.NonUTF8 || utf8.ValidString(entry.Name) {
+		return entry.Name
+	}
+	name, err := charmap.CodePage866.NewDecoder().String(entry.Name)
+	if err != nil {
+		return entry.Name
+	}
+	return name
+}
+
+// uploadExtension returns the extension a file is stored with. Images and PDFs get the extension
+// of their detected format, whatever they were named; statement files keep theirs.
+func (s *DocumentService) uploadExtension(fileName string, head []byte) (string, error) {
+	if format := imagefile.Detect(head); format != imagefile.FormatUnknown {
+		if !s.ocrService.SupportsFormat(format) {
+			return "", fmt.Errorf("%w: %s", ErrUnsupportedFileFormat, format)
+		}
+		return format.Extension(), nil
+	}
+
+	if importer.Supports(fileName) && importer.Detect(fileName, head) != "" {
+		return strings.ToLower(filepath.Ext(fileName)), nil
+	}
+
+	return "", ErrUnsupportedFileFormat
+}
+
+// fetchFile streams the stored file of the document into a temporary file with the same extension,
+// as OCR, QR scanning and the statement importer read files; the caller removes it
+func (s *DocumentService) fetchFile(ctx context.Context, doc *models.Document) (string, error) {
+	object, err := s.storage.Get(ctx, doc.StorageKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read document file: %w", err)
+	}
+	defer object.Close()
+
+	tmpFile, err := os.CreateTemp("", "document-*"+path.Ext(doc.StorageKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(tmpFile, object); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to read document file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	return tmpFile.Name(), nil
+}
+
+// GetOwnedDocument returns the document if it exists and belongs to the user
+func (s *DocumentService) GetOwnedDocument(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) (*models.Document, error) {
 	doc, err := s.docRepo.GetByID(ctx, documentID)
 	if err != nil {
-		return nil, fmt.Errorf("document not found: %w", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get document: %w", err)
 	}
 
 	if doc.UserID != userID {
-		return nil, fmt.Errorf("unauthorized")
+		return nil, ErrDocumentAccessDenied
 	}
 
-	// 2. Extract text using OCR
-	filePath := filepath.Join(s.uploadDir, filepath.Base(doc.FileURL))
-	extractedText, err := s.ocrService.ExtractText(ctx, filePath)
+	return doc, nil
+}
+
+// ProcessDocument processes a document: OCR -> LLM analysis -> RAG -> recommendations.
+// Statement files (CSV, OFX, QIF, 1C) skip OCR and extraction: their operations are imported
+// from the file and only categorized by the model.
+// If the document has the QR code of a fiscal receipt, its date and total override the values
+// read by the model. A receipt the user has already uploaded with another document keeps
+// no transactions, so the same purchase is never counted twice.
+// Results of a previous run are replaced. A document already processed by the current
+// ProcessingVersion is not processed again unless force is set; its stored results are returned.
+// If recommendations fail, the transactions are saved without a processing version, so the next
+// run processes the document again even without force.
+// progress may be nil; otherwise it is called when a stage starts and as recommendations are generated.
+func (s *DocumentService) ProcessDocument(ctx context.Context, userID uuid.UUID, documentID uuid.UUID, force bool, progress ProgressFunc) (*dto.ProcessDocumentResponse, error) {
+	if progress == nil {
+		progress = func(models.JobStage, int) {}
+	}
+
+	// 1. Get document
+	doc, err := s.GetOwnedDocument(ctx, userID, documentID)
 	if err != nil {
-		s.logger.Warn("OCR extraction failed", zap.Error(err))
-		// Continue with empty text
-		extractedText = ""
+		return nil, err
 	}
-	
-	// Check if extracted text is an error message from LLM
-	if extractedText != "" {
-		textLower := strings.ToLower(extractedText)
-		errorPhrases := []string{
-			"не могу помочь",
-			"не могу обработать",
-			"предоставьте содержимое",
-			"предоставь содержимое",
-			"не могу извлечь",
-			"cannot help",
-			"cannot process",
-			"please provide",
-		}
-		for _, phrase := range errorPhrases {
-			if strings.Contains(textLower, phrase) {
-				s.logger.Warn("OCR returned error message instead of text, treating as empty",
-					zap.String("text", extractedText),
-				)
-				extractedText = ""
-				break
+
+	if !force && doc.ProcessedAt != nil && doc.ProcessingVersion == ProcessingVersion {
+		s.logger.Info("Document is up to date, returning stored results",
+			zap.String("document_id", documentID.String()),
+			zap.String("processing_version", doc.ProcessingVersion),
+		)
+		return s.storedResults(ctx, doc)
+	}
+
+	// 2-3. Read the transactions: statement files are imported as is,
+	// other documents go through OCR and model extraction
+	filePath, err := s.fetchFile(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(filePath)
+
+	var transactions []*models.Transaction
+	var extractedText string
+	var receipt *fiscal.Receipt
+	if importer.Supports(filePath) {
+		transactions, extractedText, err = s.importStatement(ctx, doc, filePath, progress)
+	} else {
+		transactions, extractedText, receipt, err = s.recognizeDocument(ctx, doc, filePath, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fiscalReceipt *models.FiscalReceipt
+	if receipt != nil {
+		var receiptTx *models.Transaction
+		transactions, receiptTx = applyFiscalReceipt(receipt, transactions, documentID, userID)
+		fiscalReceipt = &models.FiscalReceipt{
+			ID:            uuid.New(),
+			DocumentID:    documentID,
+			UserID:        userID,
+			FN:            receipt.FN,
+			FD:            receipt.FD,
+			FP:            receipt.FP,
+			OperationType: receipt.OperationType,
+			Total:         receipt.Total,
+			IssuedAt:      receipt.Time,
+			Raw:           receipt.Raw,
+			CreatedAt:     time.Now(),
+		}
+		if receiptTx != nil {
+			fiscalReceipt.TransactionID = &receiptTx.ID
+		} else {
+			s.logger.Info("Return receipt is not counted as spending",
+				zap.String("document_id", documentID.String()),
+				zap.Int("operation_type", receipt.OperationType),
+			)
+		}
+	}
+
+	// A receipt the user has already uploaded with another document is counted once:
+	// the duplicate keeps no transactions, so its spending is not added twice
+	duplicateOf, err := s.duplicateReceiptDocument(ctx, fiscalReceipt)
+	if err != nil {
+		return nil, err
+	}
+	if duplicateOf != uuid.Nil {
+		transactions = nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// 4. Generate recommendations for the transactions
+	progress(models.JobStageRecommendations, 50)
+	allRecommendations, failures, err := s.recService.GenerateDocumentRecommendations(ctx, transactions, userID, func(done, total int) {
+		progress(models.JobStageRecommendations, 50+45*done/total)
+	})
+	s.saveValidationFailures(ctx, documentID, models.JobStageRecommendations, failures)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	// The document keeps its transactions; without a processing version the results are stale,
+	// and the next run generates the recommendations again
+	version := ProcessingVersion
+	if err != nil {
+		s.logger.Warn("Failed to generate recommendations", zap.Error(err), zap.String("document_id", documentID.String()))
+		version = ""
+	}
+
+	// 5. Replace previous results of the document in one database transaction,
+	// so a re-run never duplicates transactions and a failed run keeps the old data
+	var receiptResponse *dto.FiscalReceiptResponse
+	err = s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.receiptRepo.DeleteByDocumentID(ctx, documentID); err != nil {
+			return fmt.Errorf("failed to delete previous fiscal receipt: %w", err)
+		}
+		if err := s.txRepo.DeleteByDocumentID(ctx, documentID); err != nil {
+			return fmt.Errorf("failed to delete previous transactions: %w", err)
+		}
+		if err := s.txRepo.CreateBatch(ctx, transactions); err != nil {
+			return fmt.Errorf("failed to save transactions: %w", err)
+		}
+		switch {
+		case duplicateOf != uuid.Nil:
+			receiptResponse = toFiscalReceiptResponse(fiscalReceipt)
+			receiptResponse.DuplicateOfDocumentID = duplicateOf.String()
+		case fiscalReceipt != nil:
+			var err error
+			if receiptResponse, err = s.saveFiscalReceipt(ctx, fiscalReceipt); err != nil {
+				return err
+			}
+			if receiptResponse.DuplicateOfDocumentID != "" {
+				// The same receipt was saved by a concurrent run for another document
+				if err := s.txRepo.DeleteByDocumentID(ctx, documentID); err != nil {
+					return fmt.Errorf("failed to delete duplicate transactions: %w", err)
+				}
+				transactions, allRecommendations = nil, nil
 			}
 		}
+		if err := s.recRepo.CreateBatch(ctx, allRecommendations); err != nil {
+			return fmt.Errorf("failed to save recommendations: %w", err)
+		}
+		return s.docRepo.MarkProcessed(ctx, documentID, extractedText, doc.DetectedType, doc.TypeConfidence, version)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Update document with extracted text
-	if extractedText != "" {
-		if err := s.docRepo.UpdateExtractedText(ctx, documentID, extractedText); err != nil {
-			s.logger.Warn("Failed to update extracted text", zap.Error(err))
+	// 6. Build response
+	processedAt := time.Now()
+	doc.ExtractedText = extractedText
+	doc.ProcessingVersion = version
+	doc.ProcessedAt = &processedAt
+
+	return &dto.ProcessDocumentResponse{
+		Document:        *toDocumentResponse(doc),
+		Transactions:    toTransactionResponses(transactions),
+		Recommendations: toRecommendationResponses(allRecommendations),
+		FiscalReceipt:   receiptResponse,
+	}, nil
+}
+
+// recognizeDocument reads the transactions of a document image or PDF: OCR, type detection, then extraction
+// by the model with the prompt of the type. The detected type is set on doc.
+// It also returns the extracted text and the fiscal receipt QR code of the document, if it has one.
+func (s *DocumentService) recognizeDocument(ctx context.Context, doc *models.Document, filePath string, progress ProgressFunc) ([]*models.Transaction, string, *fiscal.Receipt, error) {
+	// 2. Extract text using OCR
+	progress(models.JobStageOCR, 0)
+	extractedText, err := s.ocrService.ExtractText(ctx, filePath)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// Cancelled or timed out: do not mistake it for an unreadable document
+		return nil, "", nil, ctxErr
+	}
+	if err != nil {
+		// Keep previous results intact rather than replacing them with nothing
+		return nil, "", nil, fmt.Errorf("failed to extract text: %w", err)
+	}
+
+	receipt, err := s.ocrService.ScanFiscalReceipt(ctx, filePath)
+	if err != nil {
+		if !errors.Is(err, fiscal.ErrNotFound) {
+			s.logger.Warn("Failed to scan fiscal receipt QR code", zap.Error(err), zap.String("document_id", doc.ID.String()))
 		}
+		receipt = nil
+	}
+
+	// Check if extracted text is an error message from LLM
+	if isVisionRefusal(extractedText) {
+		s.logger.Warn("OCR returned error message instead of text, treating as empty",
+			zap.String("text", extractedText),
+		)
+		extractedText = ""
+	}
+
+	// 3. Detect the document type and analyze transactions using LLM
+	progress(models.JobStageAnalysis, 30)
+	doc.DetectedType, doc.TypeConfidence = s.detectType(ctx, doc, extractedText, receipt != nil)
+	if doc.DetectedType != "" {
+		s.logger.Info("Document type detected",
+			zap.String("document_id", doc.ID.String()),
+			zap.String("chosen_type", string(doc.Type)),
+			zap.String("detected_type", string(doc.DetectedType)),
+			zap.Float64("confidence", doc.TypeConfidence),
+		)
 	}
 
-	// 3. Analyze transactions using LLM
 	var transactions []*models.Transaction
 	if extractedText != "" {
-		analyses, err := s.llmService.AnalyzeTransaction(ctx, extractedText)
+		analyses, failures, err := s.llmService.AnalyzeTransaction(ctx, extractedText, extractionType(doc))
+		s.saveValidationFailures(ctx, doc.ID, models.JobStageAnalysis, failures)
 		if err != nil {
-			s.logger.Warn("LLM analysis failed", zap.Error(err))
-		} else {
-			// Convert analyses to transactions
-			now := time.Now()
-			for _, analysis := range analyses {
-				tx := &models.Transaction{
-					ID:             uuid.New(),
-					DocumentID:     documentID,
-					UserID:         userID,
-					Amount:         analysis.Amount,
-					Currency:       analysis.Currency,
-					Description:    sanitizeUTF8(analysis.Description),
-					Category:       analysis.Category,
-					LLMDescription: sanitizeUTF8(analysis.LLMDescription),
-					CreatedAt:      now,
-					UpdatedAt:      now,
-				}
+			return nil, "", nil, err
+		}
+
+		// Convert analyses to transactions
+		now := time.Now()
+		for _, analysis := range analyses {
+			tx := &models.Transaction{
+				ID:             uuid.New(),
+				DocumentID:     doc.ID,
+				UserID:         doc.UserID,
+				Amount:         analysis.Amount,
+				Currency:       analysis.Currency,
+				Description:    sanitizeUTF8(analysis.Description),
+				Category:       analysis.Category,
+				LLMDescription: sanitizeUTF8(analysis.LLMDescription),
+				Bank:           NormalizeBank(analysis.Bank),
+				CreatedAt:      now,
+				UpdatedAt:      now,
+			}
 
-				// Parse date if provided
-				if analysis.Date != "" {
-					if date, err := time.Parse("2006-01-02", analysis.Date); err == nil {
-						tx.Date = date
-					} else {
-						tx.Date = now
-					}
+			// Parse date if provided
+			if analysis.Date != "" {
+				if date, err := time.Parse("2006-01-02", analysis.Date); err == nil {
+					tx.Date = date
 				} else {
 					tx.Date = now
 				}
-
-				transactions = append(transactions, tx)
+			} else {
+				tx.Date = now
 			}
 
-			// Save transactions
-			if len(transactions) > 0 {
-				if err := s.txRepo.CreateBatch(ctx, transactions); err != nil {
-					s.logger.Warn("Failed to save transactions", zap.Error(err))
-				}
+			for i, item := range analysis.Items {
+				tx.Items = append(tx.Items, models.TransactionItem{
+					ID:            uuid.New(),
+					TransactionID: tx.ID,
+					Position:      i + 1,
+					Name:          sanitizeUTF8(item.Name),
+					Quantity:      item.Quantity,
+					UnitPrice:     item.UnitPrice,
+					Total:         item.Total,
+					VATRate:       models.VATRate(item.VATRate),
+					Category:      item.Category,
+					CreatedAt:     now,
+				})
 			}
+
+			transactions = append(transactions, tx)
+		}
+	}
+
+	return transactions, extractedText, receipt, nil
+}
+
+// importStatement reads the transactions of a statement file (CSV, OFX, QIF, 1C). Amounts, currencies
+// and dates come from the file; the model only picks the categories. Incoming operations are skipped:
+// the transactions of a document are its expenses. The detected type of doc is set to statement.
+func (s *DocumentService) importStatement(ctx context.Context, doc *models.Document, filePath string, progress ProgressFunc) ([]*models.Transaction, string, error) {
+	progress(models.JobStageAnalysis, 30)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read statement: %w", err)
+	}
+
+	statement, err := s.importer.Parse(filePath, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to import statement: %w", err)
+	}
+	// A file the importer parsed is a statement whatever type was chosen
+	doc.DetectedType, doc.TypeConfidence = models.DocumentTypeStatement, 1
+
+	var debits []importer.Transaction
+	for _, op := range statement.Transactions {
+		if op.Debit() {
+			debits = append(debits, op)
 		}
 	}
 
-	// 4. Generate recommendations for each transaction
-	var allRecommendations []*models.Recommendation
+	s.logger.Info("Statement imported",
+		zap.String("document_id", doc.ID.String()),
+		zap.String("format", string(statement.Format)),
+		zap.String("profile", statement.Profile),
+		zap.Int("operations", len(statement.Transactions)),
+		zap.Int("debits", len(debits)),
+	)
+
+	operations := make([]StatementOperation, len(debits))
+	for i, op := range debits {
+		currency := normalizeCurrency(op.Currency)
+		if !iso4217Codes[currency] {
+			return nil, "", fmt.Errorf("failed to import statement: operation %d has unknown currency %q", i+1, op.Currency)
+		}
+		debits[i].Currency = currency
+		debits[i].Description = sanitizeUTF8(strings.TrimSpace(op.Description))
+		if debits[i].Description == "" {
+			debits[i].Description = "Операция по выписке"
+		}
+
+		operations[i] = StatementOperation{
+			Description:  debits[i].Description,
+			BankCategory: op.BankCategory,
+			MCC:          op.MCC,
+			Amount:       -op.Amount,
+			Currency:     currency,
+		}
+	}
+
+	categories, failures, err := s.llmService.CategorizeOperations(ctx, operations)
+	s.saveValidationFailures(ctx, doc.ID, models.JobStageAnalysis, failures)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	transactions := make([]*models.Transaction, len(debits))
+	for i, op := range debits {
+		transactions[i] = &models.Transaction{
+			ID:          uuid.New(),
+			DocumentID:  doc.ID,
+			UserID:      doc.UserID,
+			Amount:      -op.Amount,
+			Currency:    op.Currency,
+			Description: op.Description,
+			Category:    categories[i],
+			Bank:        NormalizeBank(op.Bank),
+			Date:        op.Date,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+	}
+
+	return transactions, sanitizeUTF8(statement.Text), nil
+}
+
+// applyFiscalReceipt makes the QR code values authoritative: the transaction closest to the receipt total
+// gets its total and time. If the model found no transactions, one is created from the QR code alone.
+// It returns the transactions and the one the receipt belongs to.
+// A return receipt is a refund rather than spending: the transactions read from it are dropped
+// and no transaction is returned, only the receipt itself is kept.
+func applyFiscalReceipt(receipt *fiscal.Receipt, transactions []*models.Transaction, documentID, userID uuid.UUID) ([]*models.Transaction, *models.Transaction) {
+	if receipt.Return() {
+		return nil, nil
+	}
+
+	var target *models.Transaction
 	for _, tx := range transactions {
-		recs, err := s.recService.GenerateRecommendations(ctx, tx, userID)
+		if target == nil || math.Abs(tx.Amount-receipt.Total) < math.Abs(target.Amount-receipt.Total) {
+			target = tx
+		}
+	}
+
+	if target == nil {
+		now := time.Now()
+		target = &models.Transaction{
+			ID:          uuid.New(),
+			DocumentID:  documentID,
+			UserID:      userID,
+			Description: "Кассовый чек",
+			Category:    models.CategoryOther,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		transactions = append(transactions, target)
+	}
+
+	target.Amount = receipt.Total
+	target.Currency = "RUB"
+	target.Date = receipt.Time
+	return transactions, target
+}
+
+// duplicateReceiptDocument returns the document the user has already uploaded the receipt with;
+// uuid.Nil if the receipt is new, belongs to the same document or there is no receipt
+func (s *DocumentService) duplicateReceiptDocument(ctx context.Context, receipt *models.FiscalReceipt) (uuid.UUID, error) {
+	if receipt == nil {
+		return uuid.Nil, nil
+	}
+
+	existing, err := s.receiptRepo.GetByFiscalID(ctx, receipt.UserID, receipt.FN, receipt.FD, receipt.FP)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get fiscal receipt: %w", err)
+	}
+	if existing.DocumentID == receipt.DocumentID {
+		return uuid.Nil, nil
+	}
+
+	s.logger.Warn("Fiscal receipt has already been uploaded, skipping its transactions",
+		zap.String("document_id", receipt.DocumentID.String()),
+		zap.String("first_document_id", existing.DocumentID.String()),
+	)
+	return existing.DocumentID, nil
+}
+
+// saveFiscalReceipt stores the receipt of a document. A receipt the user has already uploaded with another
+// document is kept only once; the response then names the document it was first stored with.
+func (s *DocumentService) saveFiscalReceipt(ctx context.Context, receipt *models.FiscalReceipt) (*dto.FiscalReceiptResponse, error) {
+	inserted, err := s.receiptRepo.Create(ctx, receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save fiscal receipt: %w", err)
+	}
+
+	response := toFiscalReceiptResponse(receipt)
+	if !inserted {
+		existing, err := s.receiptRepo.GetByFiscalID(ctx, receipt.UserID, receipt.FN, receipt.FD, receipt.FP)
 		if err != nil {
-			s.logger.Warn("Failed to generate recommendations", zap.Error(err), zap.String("transaction_id", tx.ID.String()))
-			continue
+			return nil, fmt.Errorf("failed to get fiscal receipt: %w", err)
 		}
-		allRecommendations = append(allRecommendations, recs...)
+		s.logger.Warn("Fiscal receipt has already been uploaded",
+			zap.String("document_id", receipt.DocumentID.String()),
+			zap.String("first_document_id", existing.DocumentID.String()),
+		)
+		response.DuplicateOfDocumentID = existing.DocumentID.String()
 	}
 
-	// Save recommendations
-	if len(allRecommendations) > 0 {
-		if err := s.recRepo.CreateBatch(ctx, allRecommendations); err != nil {
-			s.logger.Warn("Failed to save recommendations", zap.Error(err))
+	return response, nil
+}
+
+// saveValidationFailures records rejected model answers of a document.
+// They are kept even if processing fails, so a failure is only logged.
+func (s *DocumentService) saveValidationFailures(ctx context.Context, documentID uuid.UUID, stage models.JobStage, failures []JSONFailure) {
+	if len(failures) == 0 {
+		return
+	}
+
+	now := time.Now()
+	records := make([]*models.ValidationFailure, len(failures))
+	for i, f := range failures {
+		records[i] = &models.ValidationFailure{
+			ID:         uuid.New(),
+			DocumentID: documentID,
+			Stage:      stage,
+			Attempt:    f.Attempt,
+			Errors:     f.Errors,
+			Response:   sanitizeUTF8(f.Response),
+			CreatedAt:  now,
 		}
 	}
 
-	// 5. Build response
-	docResponse := &dto.DocumentResponse{
-		ID:            doc.ID.String(),
-		Type:          string(doc.Type),
-		FileName:      doc.FileName,
-		FileSize:      doc.FileSize,
-		FileURL:       doc.FileURL,
-		ExtractedText: extractedText,
-		CreatedAt:     doc.CreatedAt.Format(time.RFC3339),
+	if err := s.failRepo.CreateBatch(ctx, records); err != nil {
+		s.logger.Warn("Failed to save validation failures",
+			zap.String("document_id", documentID.String()),
+			zap.Error(err),
+		)
+	}
+}
+
+// GetDocumentValidationFailures returns model answers rejected while processing the document
+func (s *DocumentService) GetDocumentValidationFailures(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) ([]dto.ValidationFailureResponse, error) {
+	if _, err := s.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	failures, err := s.failRepo.GetByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get validation failures: %w", err)
 	}
 
-	txResponses := make([]dto.TransactionResponse, len(transactions))
+	responses := make([]dto.ValidationFailureResponse, len(failures))
+	for i, f := range failures {
+		responses[i] = dto.ValidationFailureResponse{
+			ID:        f.ID.String(),
+			Stage:     string(f.Stage),
+			Attempt:   f.Attempt,
+			Errors:    f.Errors,
+			Response:  f.Response,
+			CreatedAt: f.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return responses, nil
+}
+
+// storedResults loads the saved transactions and recommendations of a document
+func (s *DocumentService) storedResults(ctx context.Context, doc *models.Document) (*dto.ProcessDocumentResponse, error) {
+	transactions, err := s.txRepo.GetByDocumentID(ctx, doc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	recommendations, err := s.recRepo.GetByDocumentID(ctx, doc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendations: %w", err)
+	}
+
+	var receiptResponse *dto.FiscalReceiptResponse
+	receipt, err := s.receiptRepo.GetByDocumentID(ctx, doc.ID)
+	switch {
+	case err == nil:
+		receiptResponse = toFiscalReceiptResponse(receipt)
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("failed to get fiscal receipt: %w", err)
+	}
+
+	return &dto.ProcessDocumentResponse{
+		Document:        *toDocumentResponse(doc),
+		Transactions:    toTransactionResponses(transactions),
+		Recommendations: toRecommendationResponses(recommendations),
+		FiscalReceipt:   receiptResponse,
+	}, nil
+}
+
+// GetDocument returns a document with its extracted text
+func (s *DocumentService) GetDocument(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) (*dto.DocumentResponse, error) {
+	doc, err := s.GetOwnedDocument(ctx, userID, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toDocumentResponse(doc), nil
+}
+
+// DocumentFile is the stored file of a document opened for reading; the caller closes Body
+type DocumentFile struct {
+	Name        string // name of the uploaded file
+	ContentType string
+	Size        int64
+	Body        io.ReadCloser
+}
+
+// fileContentTypes are content types of stored files missing from the mime package tables
+var fileContentTypes = map[string]string{
+	".heic": "image/heic",
+	".tiff": "image/tiff",
+	".bmp":  "image/bmp",
+	".csv":  "text/csv; charset=utf-8",
+	".ofx":  "application/x-ofx",
+	".qfx":  "application/x-ofx",
+	".qif":  "application/qif",
+	".txt":  "text/plain",
+}
+
+// OpenDocumentFile opens the stored file of the user's document
+func (s *DocumentService) OpenDocumentFile(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) (*DocumentFile, error) {
+	doc, err := s.GetOwnedDocument(ctx, userID, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.openFile(ctx, doc)
+}
+
+// OpenSignedDocumentFile opens the stored file of a document without an ownership check.
+// It serves signed URLs, which are only issued to the owner of the document.
+func (s *DocumentService) OpenSignedDocumentFile(ctx context.Context, documentID uuid.UUID) (*DocumentFile, error) {
+	doc, err := s.docRepo.GetByID(ctx, documentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, fmt.Errorf("failed to get document: %w", err)
+	}
+
+	return s.openFile(ctx, doc)
+}
+
+func (s *DocumentService) openFile(ctx context.Context, doc *models.Document) (*DocumentFile, error) {
+	body, err := s.storage.Get(ctx, doc.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document file: %w", err)
+	}
+
+	ext := path.Ext(doc.StorageKey)
+	contentType, ok := fileContentTypes[ext]
+	if !ok {
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &DocumentFile{
+		Name:        doc.FileName,
+		ContentType: contentType,
+		Size:        doc.FileSize,
+		Body:        body,
+	}, nil
+}
+
+// GetDocumentTransactions returns stored transactions of a processed document
+func (s *DocumentService) GetDocumentTransactions(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) ([]dto.TransactionResponse, error) {
+	if _, err := s.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.txRepo.GetByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	return toTransactionResponses(transactions), nil
+}
+
+// GetDocumentItems returns receipt line items of all transactions of a document
+func (s *DocumentService) GetDocumentItems(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) ([]dto.TransactionItemResponse, error) {
+	if _, err := s.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.txRepo.GetByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions: %w", err)
+	}
+
+	responses := []dto.TransactionItemResponse{}
+	for _, tx := range transactions {
+		responses = append(responses, toTransactionItemResponses(tx.Items)...)
+	}
+	return responses, nil
+}
+
+// GetTransactionItems returns receipt line items of one transaction of a document
+func (s *DocumentService) GetTransactionItems(ctx context.Context, userID uuid.UUID, documentID uuid.UUID, transactionID uuid.UUID) ([]dto.TransactionItemResponse, error) {
+	tx, err := s.getDocumentTransaction(ctx, userID, documentID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTransactionItemResponses(tx.Items), nil
+}
+
+// GetDocumentRecommendations returns stored recommendations for all transactions of a document
+func (s *DocumentService) GetDocumentRecommendations(ctx context.Context, userID uuid.UUID, documentID uuid.UUID) ([]dto.RecommendationResponse, error) {
+	if _, err := s.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	recommendations, err := s.recRepo.GetByDocumentID(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendations: %w", err)
+	}
+
+	return toRecommendationResponses(recommendations), nil
+}
+
+// GetTransactionRecommendations returns stored recommendations for one transaction of a document
+func (s *DocumentService) GetTransactionRecommendations(ctx context.Context, userID uuid.UUID, documentID uuid.UUID, transactionID uuid.UUID) ([]dto.RecommendationResponse, error) {
+	if _, err := s.getDocumentTransaction(ctx, userID, documentID, transactionID); err != nil {
+		return nil, err
+	}
+
+	recommendations, err := s.recRepo.GetByTransactionID(ctx, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendations: %w", err)
+	}
+
+	return toRecommendationResponses(recommendations), nil
+}
+
+// getDocumentTransaction returns a transaction if it belongs to the user's document
+func (s *DocumentService) getDocumentTransaction(ctx context.Context, userID uuid.UUID, documentID uuid.UUID, transactionID uuid.UUID) (*models.Transaction, error) {
+	if _, err := s.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.txRepo.GetByID(ctx, transactionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+	if tx.DocumentID != documentID {
+		return nil, ErrTransactionNotFound
+	}
+
+	return tx, nil
+}
+
+// ListDocuments lists user's documents
+func (s *DocumentService) ListDocuments(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*dto.DocumentResponse, error) {
+	docs, err := s.docRepo.ListByUserID(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*dto.DocumentResponse, len(docs))
+	for i, doc := range docs {
+		responses[i] = toDocumentResponse(doc)
+		// The list stays compact; the text is returned by GET /documents/:id
+		responses[i].ExtractedText = ""
+	}
+
+	return responses, nil
+}
+
+func toDocumentResponse(doc *models.Document) *dto.DocumentResponse {
+	resp := &dto.DocumentResponse{
+		ID:                doc.ID.String(),
+		Type:              string(doc.Type),
+		FileName:          doc.FileName,
+		FileSize:          doc.FileSize,
+		FileURL:           doc.FileURL,
+		ContentHash:       doc.ContentHash,
+		DetectedType:      string(doc.DetectedType),
+		TypeConfidence:    doc.TypeConfidence,
+		ExtractedText:     doc.ExtractedText,
+		ProcessingVersion: doc.ProcessingVersion,
+		CreatedAt:         doc.CreatedAt.Format(time.RFC3339),
+	}
+	if doc.ProcessedAt != nil {
+		resp.ProcessedAt = doc.ProcessedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+func toTransactionResponses(transactions []*models.Transaction) []dto.TransactionResponse {
+	responses := make([]dto.TransactionResponse, len(transactions))
 	for i, tx := range transactions {
-		txResponses[i] = dto.TransactionResponse{
+		responses[i] = dto.TransactionResponse{
 			ID:             tx.ID.String(),
 			Amount:         tx.Amount,
 			Currency:       tx.Currency,
 			Description:    tx.Description,
 			Category:       string(tx.Category),
 			LLMDescription: tx.LLMDescription,
+			Bank:           tx.Bank,
 			Date:           tx.Date.Format(time.RFC3339),
 			CreatedAt:      tx.CreatedAt.Format(time.RFC3339),
 		}
+		if len(tx.Items) > 0 {
+			responses[i].Items = toTransactionItemResponses(tx.Items)
+		}
+	}
+	return responses
+}
+
+func toTransactionItemResponses(items []models.TransactionItem) []dto.TransactionItemResponse {
+	responses := make([]dto.TransactionItemResponse, len(items))
+	for i, item := range items {
+		responses[i] = dto.TransactionItemResponse{
+			ID:            item.ID.String(),
+			TransactionID: item.TransactionID.String(),
+			Position:      item.Position,
+			Name:          item.Name,
+			Quantity:      item.Quantity,
+			UnitPrice:     item.UnitPrice,
+			Total:         item.Total,
+			VATRate:       string(item.VATRate),
+			Category:      string(item.Category),
+		}
 	}
+	return responses
+}
 
-	recResponses := make([]dto.RecommendationResponse, len(allRecommendations))
-	for i, rec := range allRecommendations {
-		recResponses[i] = dto.RecommendationResponse{
+func toRecommendationResponses(recommendations []*models.Recommendation) []dto.RecommendationResponse {
+	responses := make([]dto.RecommendationResponse, len(recommendations))
+	for i, rec := range recommendations {
+		responses[i] = dto.RecommendationResponse{
 			ID:               rec.ID.String(),
+			TransactionID:    rec.TransactionID.String(),
+			TransactionIDs:   make([]string, len(rec.TransactionIDs)),
 			Title:            rec.Title,
 			Description:      rec.Description,
 			PotentialSavings: rec.PotentialSavings,
+			SavingsBasis:     rec.SavingsBasis,
+			Confidence:       rec.Confidence,
 			Source:           rec.Source,
 			CreatedAt:        rec.CreatedAt.Format(time.RFC3339),
+			Citations:        toCitationResponses(rec.Citations),
+		}
+		for j, id := range rec.TransactionIDs {
+			responses[i].TransactionIDs[j] = id.String()
 		}
 	}
-
-	return &dto.ProcessDocumentResponse{
-		Document:        *docResponse,
-		Transactions:    txResponses,
-		Recommendations: recResponses,
-	}, nil
+	return responses
 }
 
-// ListDocuments lists user's documents
-func (s *DocumentService) ListDocuments(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*dto.DocumentResponse, error) {
-	docs, err := s.docRepo.ListByUserID(ctx, userID, limit, offset)
-	if err != nil {
-		return nil, err
+func toFiscalReceiptResponse(receipt *models.FiscalReceipt) *dto.FiscalReceiptResponse {
+	resp := &dto.FiscalReceiptResponse{
+		FN:            receipt.FN,
+		FD:            receipt.FD,
+		FP:            receipt.FP,
+		OperationType: receipt.OperationType,
+		Total:         receipt.Total,
+		IssuedAt:      receipt.IssuedAt.Format("2006-01-02T15:04:05"),
+	}
+	if receipt.TransactionID != nil {
+		resp.TransactionID = receipt.TransactionID.String()
 	}
+	return resp
+}
 
-	responses := make([]*dto.DocumentResponse, len(docs))
-	for i, doc := range docs {
-		responses[i] = &dto.DocumentResponse{
-			ID:        doc.ID.String(),
-			Type:      string(doc.Type),
-			FileName:  doc.FileName,
-			FileSize:  doc.FileSize,
-			FileURL:   doc.FileURL,
-			CreatedAt: doc.CreatedAt.Format(time.RFC3339),
+func toCitationResponses(citations []models.RecommendationCitation) []dto.CitationResponse {
+	responses := make([]dto.CitationResponse, len(citations))
+	for i, c := range citations {
+		responses[i] = dto.CitationResponse{
+			Number:     c.Number,
+			Type:       string(c.Type),
+			Title:      c.Title,
+			SourceFile: c.SourceFile,
+			PageStart:  c.PageStart,
+			PageEnd:    c.PageEnd,
+		}
+		if c.KnowledgeID != nil {
+			responses[i].KnowledgeID = c.KnowledgeID.String()
 		}
 	}
-
-	return responses, nil
+	return responses
 }
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"rag-iishka/internal/models"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// heuristicTypeConfidence is the keyword confidence at which the model is not asked for the type
+	heuristicTypeConfidence = 0.8
+
+	// overrideTypeConfidence is the confidence at which the detected type wins over the type
+	// chosen by the user when they differ
+	overrideTypeConfidence = 0.8
+
+	// maxKeywordConfidence caps the keyword confidence: keywords never make the type certain
+	maxKeywordConfidence = 0.95
+
+	// strongKeywordScore is the score of keywords at which the type is considered well supported
+	strongKeywordScore = 4.0
+)
+
+// typeKeywords are words and phrases of OCR text that point to a document type, with their weights.
+// Keywords are matched as whole words in the lowercased text.
+var typeKeywords = map[models.DocumentType]map[string]float64{
+	models.DocumentTypeReceipt: {
+		"кассовый чек":       3,
+		"чек прихода":        2,
+		"фн":                 2,
+		"фд":                 2,
+		"фп":                 2,
+		"ккт":                2,
+		"рн ккт":             1,
+		"зн ккт":             1,
+		"итог":               1,
+		"ндс":                1,
+		"кассир":             1,
+		"смена":              1,
+		"приход":             1,
+		"сайт фнс":           1,
+		"спасибо за покупку": 1,
+		"безналичными":       1,
+		"наличными":          1,
+		"место расчетов":     1,
+		"система налогообложения": 1,
+	},
+	models.DocumentTypeStatement: {
+		"выписка":              3,
+		"выписка по счету":     2,
+		"выписка по карте":     2,
+		"движение средств":     2,
+		"за период":            2,
+		"входящий остаток":     2,
+		"исходящий остаток":    2,
+		"остаток на начало":    2,
+		"остаток на конец":     2,
+		"дата операции":        1,
+		"дата списания":        1,
+		"дата проводки":        1,
+		"номер счета":          1,
+		"итого поступлений":    1,
+		"итого списаний":       1,
+		"сумма в валюте счета": 1,
+	},
+	models.DocumentTypeScreenshot: {
+		"история операций":   2,
+		"сегодня":            1,
+		"вчера":              1,
+		"перевод":            1,
+		"получатель":         1,
+		"по номеру телефона": 2,
+		"сбп":                1,
+		"доступно":           1,
+		"баланс":             1,
+		"кешбэк":             1,
+		"кэшбэк":             1,
+		"платежи":            1,
+		"главная":            1,
+		"успешно":            1,
+		"выполнен":           1,
+		"выполнено":          1,
+		"повторить":          1,
+		"отправить":          1,
+		"квитанция":          1,
+	},
+}
+
+// classifyByKeywords scores the keywords of each type in the text. The confidence is the share of the
+// best type in the total score, reduced when the best type has few keywords.
+func classifyByKeywords(text string) (models.DocumentType, float64) {
+	text = normalizeKeywordText(text)
+
+	var best models.DocumentType
+	var bestScore, total float64
+	for docType, keywords := range typeKeywords {
+		var score float64
+		for keyword, weight := range keywords {
+			if containsWord(text, keyword) {
+				score += weight
+			}
+		}
+		total += score
+		if score > bestScore || (score == bestScore && score > 0 && docType < best) {
+			best, bestScore = docType, score
+		}
+	}
+	if bestScore == 0 {
+		return "", 0
+	}
+
+	confidence := bestScore / total * min(1, bestScore/strongKeywordScore)
+	return best, min(confidence, maxKeywordConfidence)
+}
+
+// normalizeKeywordText lowercases the text, replaces ё with е and collapses whitespace,
+// so keywords match regardless of line breaks and OCR spacing
+func normalizeKeywordText(text string) string {
+	text = strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// containsWord reports whether keyword occurs in text not as a part of a longer word
+func containsWord(text, keyword string) bool {
+	for start := 0; ; {
+		i := strings.Index(text[start:], keyword)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(keyword)
+
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (i == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		start = i + len(keyword)
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// detectType detects the type of a recognized document: a fiscal QR code makes it a receipt,
+// keywords decide when they are conclusive and the model classifies the rest.
+// If the model fails, the keyword result is kept. An empty type is returned when nothing is detected.
+func (s *DocumentService) detectType(ctx context.Context, doc *models.Document, extractedText string, hasFiscalQR bool) (models.DocumentType, float64) {
+	if hasFiscalQR {
+		return models.DocumentTypeReceipt, 1
+	}
+	if extractedText == "" {
+		return "", 0
+	}
+
+	docType, confidence := classifyByKeywords(extractedText)
+	if confidence >= heuristicTypeConfidence {
+		return docType, confidence
+	}
+
+	llmType, llmConfidence, failures, err := s.llmService.ClassifyDocument(ctx, extractedText)
+	s.saveValidationFailures(ctx, doc.ID, models.JobStageAnalysis, failures)
+	if err != nil {
+		s.logger.Warn("Failed to classify document, using keyword detection",
+			zap.String("document_id", doc.ID.String()),
+			zap.String("type", string(docType)),
+			zap.Float64("confidence", confidence),
+			zap.Error(err),
+		)
+		return docType, confidence
+	}
+
+	// Keywords that agree with the model raise the confidence
+	if llmType == docType {
+		llmConfidence = max(llmConfidence, confidence)
+	}
+	return llmType, llmConfidence
+}
+
+// extractionType is the type that selects the extraction prompt: the detected type, unless the user
+// chose another type and the detection is not confident enough to overrule them
+func extractionType(doc *models.Document) models.DocumentType {
+	switch {
+	case doc.DetectedType == "":
+		return doc.Type
+	case doc.Type == "", doc.Type == doc.DetectedType, doc.TypeConfidence >= overrideTypeConfidence:
+		return doc.DetectedType
+	default:
+		return doc.Type
+	}
+}
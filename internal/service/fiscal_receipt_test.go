@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"rag-iishka/internal/models"
+	"rag-iishka/pkg/fiscal"
+
+	"github.com/google/uuid"
+)
+
+func TestApplyFiscalReceipt(t *testing.T) {
+	issued := time.Date(2025, 1, 10, 18, 45, 0, 0, time.UTC)
+	receipt := &fiscal.Receipt{OperationType: fiscal.OperationIncome, Total: 143.90, Time: issued}
+
+	// The model misread the total; the transaction closest to the QR total takes its values
+	near := &models.Transaction{Amount: 143.09, Currency: "RUR", Date: issued.AddDate(0, 0, -1)}
+	far := &models.Transaction{Amount: 15}
+	transactions, target := applyFiscalReceipt(receipt, []*models.Transaction{far, near}, uuid.New(), uuid.New())
+	if len(transactions) != 2 || target != near {
+		t.Fatalf("got %d transactions, want the closest one of 2 as the target", len(transactions))
+	}
+	if near.Amount != 143.90 || near.Currency != "RUB" || !near.Date.Equal(issued) || far.Amount != 15 {
+		t.Errorf("target got %.2f %s %v, want the receipt values", near.Amount, near.Currency, near.Date)
+	}
+
+	// Without transactions the receipt alone creates one
+	transactions, target = applyFiscalReceipt(receipt, nil, uuid.New(), uuid.New())
+	if len(transactions) != 1 || target != transactions[0] || target.Amount != 143.90 {
+		t.Errorf("got %d transactions, want one created from the receipt", len(transactions))
+	}
+}
+
+func TestApplyFiscalReceiptReturn(t *testing.T) {
+	for _, operationType := range []int{fiscal.OperationIncomeReturn, fiscal.OperationExpenseReturn} {
+		receipt := &fiscal.Receipt{OperationType: operationType, Total: 143.90, Time: time.Now()}
+
+		// A refund is not spending: neither the model's transactions nor a new one are kept
+		read := []*models.Transaction{{Description: "Возврат", Amount: 143.90}}
+		for _, given := range [][]*models.Transaction{read, nil} {
+			transactions, target := applyFiscalReceipt(receipt, given, uuid.New(), uuid.New())
+			if len(transactions) != 0 || target != nil {
+				t.Errorf("n=%d: got %d transactions, want no expense", operationType, len(transactions))
+			}
+		}
+	}
+}
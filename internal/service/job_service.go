@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"rag-iishka/internal/dto"
+	"rag-iishka/internal/models"
+	"rag-iishka/internal/repository"
+	"rag-iishka/pkg/config"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// staleJobMargin is added to the job timeout before a silent running job is
+// considered abandoned, so a job is never re-queued while it may still finish.
+// Without a timeout a running job may take any time, so abandoned jobs are not re-queued.
+const staleJobMargin = time.Minute
+
+// JobService runs document processing in a pool of background workers.
+// Jobs are persisted in processing_jobs, so pending jobs survive restarts and
+// may be picked up by any instance sharing the database.
+type JobService struct {
+	jobRepo    *repository.JobRepository
+	docService *DocumentService
+	config     *config.JobsConfig
+	logger     *zap.Logger
+
+	queue  chan uuid.UUID
+	mu     sync.Mutex
+	queued map[uuid.UUID]struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewJobService(jobRepo *repository.JobRepository, docService *DocumentService, cfg *config.JobsConfig, logger *zap.Logger) *JobService {
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+
+	return &JobService{
+		jobRepo:    jobRepo,
+		docService: docService,
+		config:     cfg,
+		logger:     logger,
+		queue:      make(chan uuid.UUID, queueSize),
+		queued:     make(map[uuid.UUID]struct{}),
+	}
+}
+
+// Start launches the workers and the poller that picks up pending jobs from the database
+func (s *JobService) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	workers := s.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	s.logger.Info("Starting processing job workers", zap.Int("workers", workers))
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.worker(ctx)
+	}
+
+	s.wg.Add(1)
+	go s.poll(ctx)
+}
+
+// Stop cancels running jobs and waits for workers to exit. Interrupted jobs are returned to the queue.
+func (s *JobService) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// EnqueueProcessing creates a processing job for the document.
+// If the document already has a pending or running job, that job is returned instead.
+// With force the document is re-processed even if it is up to date.
+func (s *JobService) EnqueueProcessing(ctx context.Context, userID uuid.UUID, documentID uuid.UUID, force bool) (*dto.JobResponse, error) {
+	if _, err := s.docService.GetOwnedDocument(ctx, userID, documentID); err != nil {
+		return nil, err
+	}
+
+	active, err := s.jobRepo.GetActiveByDocumentID(ctx, documentID)
+	if err == nil {
+		return toJobResponse(active), nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check active jobs: %w", err)
+	}
+
+	now := time.Now()
+	job := &models.ProcessingJob{
+		ID:         uuid.New(),
+		DocumentID: documentID,
+		UserID:     userID,
+		Status:     models.JobStatusPending,
+		Force:      force,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	s.enqueue(job.ID)
+
+	return toJobResponse(job), nil
+}
+
+// GetJob returns the job status. Jobs of other users are reported as not found.
+func (s *JobService) GetJob(ctx context.Context, userID uuid.UUID, jobID uuid.UUID) (*dto.JobResponse, error) {
+	job, err := s.jobRepo.GetByID(ctx, jobID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	if job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+
+	return toJobResponse(job), nil
+}
+
+// enqueue hands the job to the workers without blocking.
+// If the queue is full the job stays pending and is picked up by the poller later.
+func (s *JobService) enqueue(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queued[id]; ok {
+		return
+	}
+
+	select {
+	case s.queue <- id:
+		s.queued[id] = struct{}{}
+	default:
+		s.logger.Debug("Job queue is full, job will be picked up by poller", zap.String("job_id", id.String()))
+	}
+}
+
+func (s *JobService) poll(ctx context.Context) {
+	defer s.wg.Done()
+
+	interval := s.config.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.pickUpPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *JobService) pickUpPending(ctx context.Context) {
+	if s.config.Timeout > 0 {
+		requeued, err := s.jobRepo.RequeueStale(ctx, s.config.Timeout+staleJobMargin)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("Failed to requeue stale jobs", zap.Error(err))
+			}
+			return
+		}
+		if requeued > 0 {
+			s.logger.Warn("Requeued abandoned processing jobs", zap.Int64("count", requeued))
+		}
+	}
+
+	ids, err := s.jobRepo.ListPendingIDs(ctx, cap(s.queue))
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Warn("Failed to list pending jobs", zap.Error(err))
+		}
+		return
+	}
+
+	for _, id := range ids {
+		s.enqueue(id)
+	}
+}
+
+func (s *JobService) worker(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			s.mu.Lock()
+			delete(s.queued, id)
+			s.mu.Unlock()
+
+			s.run(ctx, id)
+		}
+	}
+}
+
+func (s *JobService) run(ctx context.Context, id uuid.UUID) {
+	startedAt, claimed, err := s.jobRepo.Claim(ctx, id)
+	if err != nil {
+		s.logger.Warn("Failed to claim job", zap.Error(err), zap.String("job_id", id.String()))
+		return
+	}
+	if !claimed {
+		// Taken by another worker or instance
+		return
+	}
+
+	job, err := s.jobRepo.GetByID(ctx, id)
+	if err != nil {
+		s.logger.Error("Failed to load claimed job", zap.Error(err), zap.String("job_id", id.String()))
+		return
+	}
+
+	logger := s.logger.With(zap.String("job_id", id.String()), zap.String("document_id", job.DocumentID.String()))
+	logger.Info("Processing job started")
+
+	jobCtx := ctx
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+
+	result, err := s.docService.ProcessDocument(jobCtx, job.UserID, job.DocumentID, job.Force, func(stage models.JobStage, progress int) {
+		if err := s.jobRepo.UpdateProgress(ctx, id, startedAt, stage, progress); err != nil {
+			logger.Warn("Failed to update job progress", zap.Error(err))
+		}
+	})
+
+	// Use a fresh context for the final write: ctx may already be cancelled
+	writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			logger.Info("Processing job interrupted by shutdown, returning to queue")
+			if err := s.jobRepo.Release(writeCtx, id, startedAt); err != nil {
+				logger.Error("Failed to release job", zap.Error(err))
+			}
+			return
+		}
+
+		logger.Warn("Processing job failed", zap.Error(err))
+		s.markFailed(writeCtx, logger, id, startedAt, err.Error())
+		return
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		logger.Error("Failed to marshal job result", zap.Error(err))
+		s.markFailed(writeCtx, logger, id, startedAt, "failed to store result")
+		return
+	}
+
+	completed, err := s.jobRepo.MarkCompleted(writeCtx, id, startedAt, resultJSON)
+	if err != nil {
+		logger.Error("Failed to mark job as completed", zap.Error(err))
+		return
+	}
+	if !completed {
+		logger.Warn("Processing job was re-queued while running, result is discarded")
+		return
+	}
+
+	logger.Info("Processing job completed",
+		zap.Int("transactions", len(result.Transactions)),
+		zap.Int("recommendations", len(result.Recommendations)),
+	)
+}
+
+// markFailed stores the job error unless the job was re-queued and belongs to a newer run
+func (s *JobService) markFailed(ctx context.Context, logger *zap.Logger, id uuid.UUID, startedAt time.Time, errMsg string) {
+	failed, err := s.jobRepo.MarkFailed(ctx, id, startedAt, errMsg)
+	if err != nil {
+		logger.Error("Failed to mark job as failed", zap.Error(err))
+		return
+	}
+	if !failed {
+		logger.Warn("Processing job was re-queued while running, error is discarded")
+	}
+}
+
+func toJobResponse(job *models.ProcessingJob) *dto.JobResponse {
+	resp := &dto.JobResponse{
+		ID:         job.ID.String(),
+		DocumentID: job.DocumentID.String(),
+		Status:     string(job.Status),
+		Stage:      string(job.Stage),
+		Progress:   job.Progress,
+		Force:      job.Force,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt.Format(time.RFC3339),
+	}
+
+	if job.StartedAt != nil {
+		resp.StartedAt = job.StartedAt.Format(time.RFC3339)
+	}
+	if job.FinishedAt != nil {
+		resp.FinishedAt = job.FinishedAt.Format(time.RFC3339)
+	}
+
+	if len(job.Result) > 0 {
+		var result dto.ProcessDocumentResponse
+		if err := json.Unmarshal(job.Result, &result); err == nil {
+			resp.Result = &result
+		}
+	}
+
+	return resp
+}
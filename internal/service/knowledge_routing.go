@@ -0,0 +1,163 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+
+	"rag-iishka/internal/models"
+	"rag-iishka/internal/repository"
+	"rag-iishka/pkg/config"
+)
+
+// defaultKnowledgeRoutes sends each transaction category to the parts of the knowledge base
+// that can justify a recommendation for it. Metadata values are the ones written by cmd/seed.
+// Categories without routes (other) search the whole knowledge base.
+var defaultKnowledgeRoutes = map[models.TransactionCategory][]config.KnowledgeRoute{
+	models.CategoryFood: {
+		{Type: string(models.KnowledgeTypeBankTariff), Metadata: map[string]string{"category": "cashback"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeBankTariff), UserBank: true, Weight: 0.7},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.5},
+	},
+	models.CategoryTransport: {
+		{Type: string(models.KnowledgeTypeBankTariff), Metadata: map[string]string{"category": "cashback"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeGovTariff), Metadata: map[string]string{"category": "benefits"}, Weight: 0.7},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.5},
+	},
+	models.CategoryUtilities: {
+		{Type: string(models.KnowledgeTypeGovTariff), Metadata: map[string]string{"category": "benefits"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeGovTariff), Weight: 0.6},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.3},
+	},
+	models.CategoryShopping: {
+		{Type: string(models.KnowledgeTypeBankTariff), Metadata: map[string]string{"category": "cashback"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeBankTariff), UserBank: true, Weight: 0.7},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.5},
+	},
+	models.CategoryEntertainment: {
+		{Type: string(models.KnowledgeTypeBankTariff), Metadata: map[string]string{"category": "cashback"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.7},
+	},
+	models.CategoryHealthcare: {
+		{Type: string(models.KnowledgeTypeGovTariff), Metadata: map[string]string{"category": "benefits"}, Weight: 1},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.6},
+		{Type: string(models.KnowledgeTypeBankTariff), Metadata: map[string]string{"category": "cashback"}, Weight: 0.5},
+	},
+	models.CategoryEducation: {
+		{Type: string(models.KnowledgeTypeEducation), Weight: 1},
+		{Type: string(models.KnowledgeTypeGovTariff), Metadata: map[string]string{"category": "benefits"}, Weight: 0.7},
+	},
+	models.CategoryFees: {
+		{Type: string(models.KnowledgeTypeBankTariff), UserBank: true, Weight: 1},
+		{Type: string(models.KnowledgeTypeBankTariff), Weight: 0.6},
+		{Type: string(models.KnowledgeTypeEducation), Weight: 0.3},
+	},
+}
+
+// knowledgeRoute is a resolved route: a repository filter and its weight in rank fusion
+type knowledgeRoute struct {
+	filter repository.KnowledgeFilter
+	weight float64
+}
+
+// unfilteredRoutes searches the whole knowledge base
+var unfilteredRoutes = []knowledgeRoute{{weight: 1}}
+
+// routesFor resolves the routes of a transaction category; overrides from RAG_ROUTES_FILE win
+// over the built-in table. Routes restricted to the user's bank are skipped when the bank is unknown:
+// without the bank they would repeat the search of a broader route.
+func routesFor(cfg *config.RAGConfig, category *models.TransactionCategory, bank string) []knowledgeRoute {
+	if category == nil {
+		return unfilteredRoutes
+	}
+
+	routes, ok := cfg.Routes[string(*category)]
+	if !ok {
+		routes = defaultKnowledgeRoutes[*category]
+	}
+	if len(routes) == 0 {
+		return unfilteredRoutes
+	}
+
+	resolved := make([]knowledgeRoute, 0, len(routes))
+	for _, route := range routes {
+		if route.UserBank && bank == "" {
+			continue
+		}
+
+		filter := repository.KnowledgeFilter{}
+		if route.Type != "" {
+			knowledgeType := models.KnowledgeType(route.Type)
+			filter.Type = &knowledgeType
+		}
+
+		if len(route.Metadata) > 0 || route.UserBank {
+			filter.Metadata = make(map[string]string, len(route.Metadata)+1)
+			for key, value := range route.Metadata {
+				filter.Metadata[key] = value
+			}
+			if route.UserBank {
+				filter.Metadata["bank"] = bank
+			}
+		}
+
+		resolved = append(resolved, knowledgeRoute{filter: filter, weight: route.Weight})
+	}
+	if len(resolved) == 0 {
+		return unfilteredRoutes
+	}
+
+	return resolved
+}
+
+// isFiltered reports whether any of the routes restricts the search
+func isFiltered(routes []knowledgeRoute) bool {
+	for _, route := range routes {
+		if route.filter.Type != nil || len(route.filter.Metadata) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bankAliases maps spellings found in documents to the bank names used in the knowledge base metadata.
+// An alias matches the start of a word of the bank name, compared in lowercase without punctuation
+// and spaces, so "Т-Банк" matches "тбанк" while "Абсолют Банк" does not.
+var bankAliases = []struct {
+	name    string
+	aliases []string
+}{
+	{"Сбербанк", []string{"сбер", "sber"}},
+	{"Тинькофф", []string{"тинькофф", "тинькоф", "tinkoff", "тбанк", "tbank"}},
+	{"Альфа-Банк", []string{"альфа", "alfa"}},
+	{"ВТБ", []string{"втб", "vtb"}},
+	{"Газпромбанк", []string{"газпромбанк", "gazprombank"}},
+	{"Райффайзенбанк", []string{"райффайзен", "raiffeisen"}},
+}
+
+// NormalizeBank returns the knowledge base name of a bank, e.g. "ПАО Сбербанк" and "SberBank" become "Сбербанк".
+// Unknown banks are returned as is.
+func NormalizeBank(bank string) string {
+	bank = strings.TrimSpace(bank)
+	if bank == "" {
+		return ""
+	}
+
+	// Words are split at anything but letters and digits; every suffix of the joined words
+	// that starts at a word boundary is compared with the aliases
+	words := strings.FieldsFunc(strings.ReplaceAll(strings.ToLower(bank), "ё", "е"), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		key := strings.Join(words[i:], "")
+		for _, b := range bankAliases {
+			for _, alias := range b.aliases {
+				if strings.HasPrefix(key, alias) {
+					return b.name
+				}
+			}
+		}
+	}
+
+	return bank
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"testing"
+
+	"rag-iishka/internal/models"
+	"rag-iishka/pkg/config"
+)
+
+func TestNormalizeBank(t *testing.T) {
+	tests := []struct {
+		bank string
+		want string
+	}{
+		{"ПАО Сбербанк", "Сбербанк"},
+		{"SberBank", "Сбербанк"},
+		{"Сбер", "Сбербанк"},
+		{"АО «Тинькофф Банк»", "Тинькофф"},
+		{"Т-Банк", "Тинькофф"},
+		{"T-Bank", "Тинькофф"},
+		{"АО «ТБанк»", "Тинькофф"},
+		{"АО «Альфа-Банк»", "Альфа-Банк"},
+		{"Alfa-Bank", "Альфа-Банк"},
+		{"Банк ВТБ (ПАО)", "ВТБ"},
+		{"VTB", "ВТБ"},
+		{"Газпромбанк (АО)", "Газпромбанк"},
+		{"АО «Райффайзенбанк»", "Райффайзенбанк"},
+		{"  Raiffeisenbank  ", "Райффайзенбанк"},
+		{"", ""},
+		{"   ", ""},
+
+		// An alias inside a word of another bank's name is not a match
+		{"Абсолют Банк", "Абсолют Банк"},
+		{"Металлинвестбанк", "Металлинвестбанк"},
+		{"Почта Банк", "Почта Банк"},
+		{"МТС Банк", "МТС Банк"},
+		{"Росбанк", "Росбанк"},
+		{"Совкомбанк", "Совкомбанк"},
+		{"Банк Зенит", "Банк Зенит"},
+		{"Кредит Европа Банк", "Кредит Европа Банк"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeBank(tt.bank); got != tt.want {
+			t.Errorf("NormalizeBank(%q) = %q, want %q", tt.bank, got, tt.want)
+		}
+	}
+}
+
+func TestRoutesFor(t *testing.T) {
+	fees := models.CategoryFees
+	other := models.CategoryOther
+	cfg := &config.RAGConfig{}
+
+	// With a known bank the tariffs of that bank are searched first
+	routes := routesFor(cfg, &fees, "Сбербанк")
+	if len(routes) != 3 {
+		t.Fatalf("got %d routes, want 3", len(routes))
+	}
+	if routes[0].filter.Metadata["bank"] != "Сбербанк" || routes[0].weight != 1 {
+		t.Errorf("first route = %+v, want the user's bank tariffs", routes[0])
+	}
+
+	// Without the bank the route would repeat the filter of the general tariff route
+	routes = routesFor(cfg, &fees, "")
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes without a bank, want 2", len(routes))
+	}
+	for _, route := range routes {
+		if _, ok := route.filter.Metadata["bank"]; ok {
+			t.Errorf("route %+v is restricted to a bank", route)
+		}
+	}
+	if *routes[0].filter.Type != models.KnowledgeTypeBankTariff || routes[0].weight != 0.6 {
+		t.Errorf("first route = %+v, want all bank tariffs", routes[0])
+	}
+
+	// A category with only user bank routes searches the whole knowledge base
+	cfg.Routes = map[string][]config.KnowledgeRoute{
+		string(fees): {{Type: string(models.KnowledgeTypeBankTariff), UserBank: true, Weight: 1}},
+	}
+	if routes := routesFor(cfg, &fees, ""); isFiltered(routes) {
+		t.Errorf("got filtered routes %+v, want the whole knowledge base", routes)
+	}
+
+	if routes := routesFor(cfg, &other, "Сбербанк"); isFiltered(routes) {
+		t.Errorf("category without routes got filtered routes %+v", routes)
+	}
+	if routes := routesFor(cfg, nil, "Сбербанк"); isFiltered(routes) {
+		t.Errorf("no category got filtered routes %+v", routes)
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"rag-iishka/pkg/llm"
+
+	"go.uber.org/zap"
+)
+
+// maxJSONAttempts is the number of answers requested from the model before a
+// malformed JSON response is given up: the first answer plus repair requests
+const maxJSONAttempts = 3
+
+// errNoJSON is returned by extractJSON when the answer contains no JSON value
+var errNoJSON = errors.New("ответ не содержит JSON")
+
+// JSONFailure is a model answer rejected by decoding or validation in chatJSON
+type JSONFailure struct {
+	Attempt  int
+	Errors   []string
+	Response string
+}
+
+// validationErrors collects all problems of one answer, so a repair request can list them at once
+type validationErrors []string
+
+func (e validationErrors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// errorList splits an error returned by decode into messages for the model
+func errorList(err error) []string {
+	var verrs validationErrors
+	if errors.As(err, &verrs) {
+		return verrs
+	}
+	return []string{err.Error()}
+}
+
+// chatJSON sends prompt and passes the JSON part of the answer to decode.
+// decode unmarshals and validates the answer; if it fails, the model is shown
+// its previous answer with the errors and asked to return corrected JSON.
+// Rejected answers are returned together with the result, also when all attempts failed.
+// Provider errors are returned immediately without a retry.
+func (s *LLMService) chatJSON(ctx context.Context, prompt string, decode func(raw string) error) ([]JSONFailure, error) {
+	messages := []llm.Message{
+		{Role: llm.RoleSystem, Content: buildSystemInstruction()},
+		{Role: llm.RoleUser, Content: prompt},
+	}
+
+	var failures []JSONFailure
+	var lastErr error
+	for attempt := 1; attempt <= maxJSONAttempts; attempt++ {
+		response, err := s.provider.Chat(ctx, messages)
+		if err != nil {
+			return failures, err
+		}
+
+		raw, err := extractJSON(response)
+		if err == nil {
+			err = decode(raw)
+		}
+		if err == nil {
+			return failures, nil
+		}
+		lastErr = err
+
+		errs := errorList(err)
+		failures = append(failures, JSONFailure{Attempt: attempt, Errors: errs, Response: response})
+
+		s.logger.Warn("LLM returned invalid JSON, asking to repair",
+			zap.Int("attempt", attempt),
+			zap.Strings("errors", errs),
+		)
+
+		messages = append(messages,
+			llm.Message{Role: llm.RoleAssistant, Content: response},
+			llm.Message{Role: llm.RoleUser, Content: fmt.Sprintf(`Ответ не прошёл проверку:
+- %s
+
+Исправь ответ и верни ТОЛЬКО валидный JSON в требуемом формате, без markdown разметки и комментариев.`, strings.Join(errs, "\n- "))},
+		)
+	}
+
+	return failures, fmt.Errorf("invalid JSON response after %d attempts: %w", maxJSONAttempts, lastErr)
+}
+
+// extractJSON returns the outermost JSON object or array of a model answer,
+// dropping markdown code fences and text around it
+func extractJSON(content string) (string, error) {
+	content = strings.TrimSpace(content)
+
+	start := strings.IndexAny(content, "[{")
+	if start == -1 {
+		return "", errNoJSON
+	}
+
+	closing := "}"
+	if content[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(content, closing)
+	if end < start {
+		return "", errNoJSON
+	}
+
+	return content[start : end+1], nil
+}
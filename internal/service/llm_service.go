@@ -1,37 +1,28 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"rag-iishka/internal/models"
-	"rag-iishka/pkg/config"
+	"rag-iishka/pkg/llm"
 
-	"github.com/Role1776/gigago"
-	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// LLMService builds financial prompts and parses model answers.
+// Transport is delegated to an llm.Provider (GigaChat or OpenAI-compatible).
 type LLMService struct {
-	client          *gigago.Client
-	model           *gigago.GenerativeModel
-	config          *config.GigaChatConfig
-	logger          *zap.Logger
-	httpClient      *http.Client
-	baseURL         string
-	accessToken     string   // Cached access token for file uploads
-	availableModels []string // Cached list of available models
+	provider llm.Provider
+	logger   *zap.Logger
 }
 
 // buildSystemInstruction creates a comprehensive system instruction for financial analysis
@@ -67,11 +58,12 @@ func buildSystemInstruction() string {
 - **entertainment** - Развлечения: кино, концерты, игры, подписки
 - **healthcare** - Здравоохранение: лекарства, медицинские услуги, страховка
 - **education** - Образование: курсы, книги, обучение
+- **fees** - Банковские комиссии: плата за обслуживание карты или счёта, SMS-информирование, комиссии за переводы и снятие наличных
 - **other** - Прочее: все остальные расходы
 
 ## Правила классификации:
 - Если транзакция подходит под несколько категорий, выбирай наиболее специфичную
-- Для банковских комиссий и тарифов используй категорию, соответствующую типу операции
+- Банковские комиссии и плату за обслуживание относи к категории fees, даже если комиссия списана вместе с покупкой или переводом
 - Переводы между своими счетами не считаются расходами
 - Пополнения счетов и депозитов не считаются расходами
 
@@ -82,11 +74,12 @@ func buildSystemInstruction() string {
 [
   {
     "description": "краткое описание операции (максимум 100 символов)",
-    "category": "одна из категорий: food|transport|utilities|shopping|entertainment|healthcare|education|other",
+    "category": "одна из категорий: food|transport|utilities|shopping|entertainment|healthcare|education|fees|other",
     "amount": число (положительное, без знака минус),
     "currency": "RUB|USD|EUR|другая валюта в формате ISO 4217",
     "date": "YYYY-MM-DD (дата транзакции, если не указана - используй дату документа)",
-    "llm_description": "подробное описание операции на русском языке (2-3 предложения, объясняющие что это за операция, где произошла, какие детали важны)"
+    "llm_description": "подробное описание операции на русском языке (2-3 предложения, объясняющие что это за операция, где произошла, какие детали важны)",
+    "bank": "банк, выпустивший карту или счёт (например, Сбербанк, Тинькофф, Альфа-Банк); пустая строка, если банк не указан в документе"
   }
 ]
 
@@ -96,6 +89,7 @@ func buildSystemInstruction() string {
 - **Дата**: Извлекай дату в формате YYYY-MM-DD, если дата не указана - используй дату документа
 - **Описание**: Краткое описание должно быть информативным и уникальным для идентификации транзакции
 - **LLM описание**: Должно содержать контекст - где произошла операция, что было куплено, какие особенности
+- **Банк**: Определяй по шапке выписки, логотипу или названию приложения; банк магазина или получателя перевода не указывай
 
 # ГЕНЕРАЦИЯ РЕКОМЕНДАЦИЙ
 
@@ -148,7 +142,7 @@ func buildSystemInstruction() string {
 - **Финансовая грамотность**: Принципы управления личными финансами, инвестирования, сбережения
 
 ## Использование контекста:
-- При генерации рекомендаций всегда ссылайся на конкретную информацию из базы знаний
+- При генерации рекомендаций всегда ссылайся на конкретную информацию из базы знаний: пункты контекста пронумерованы [1], [2], ..., указывай номера использованных пунктов в поле citations
 - Если в базе знаний есть информация о тарифах конкретного банка - используй её
 - Учитывай актуальность информации (если указаны даты)
 - Если информации в базе знаний недостаточно - используй общие принципы финансовой грамотности
@@ -195,268 +189,412 @@ func buildSystemInstruction() string {
 
 Помни: твоя цель - помочь пользователю оптимизировать свои финансы, сэкономить деньги и улучшить финансовое благополучие. Каждая рекомендация должна быть обоснованной, конкретной и выполнимой.`
 }
+func NewLLMService(provider llm.Provider, logger *zap.Logger) *LLMService {
+	logger.Info("LLM service initialized", zap.String("provider", provider.Name()))
 
-func NewLLMService(cfg *config.GigaChatConfig, logger *zap.Logger) (*LLMService, error) {
-	ctx := context.Background()
-
-	// Build client options
-	opts := []gigago.Option{
-		gigago.WithCustomScope(cfg.Scope),
+	return &LLMService{
+		provider: provider,
+		logger:   logger,
 	}
+}
+
+// GetAvailableModels retrieves list of models available to the configured provider
+func (s *LLMService) GetAvailableModels(ctx context.Context) ([]string, error) {
+	return s.provider.ListModels(ctx)
+}
+
+type TransactionAnalysis struct {
+	Description    string                     `json:"description"`
+	Category       models.TransactionCategory `json:"category"`
+	Amount         float64                    `json:"amount"`
+	Currency       string                     `json:"currency"`
+	Date           string                     `json:"date"`
+	LLMDescription string                     `json:"llm_description"`
+	Bank           string                     `json:"bank"`
+	Items          []*ItemAnalysis            `json:"items,omitempty"` // only for receipts
+}
 
-	// Add insecure skip verify option if configured
-	if cfg.InsecureSkipVerify {
-		opts = append(opts, gigago.WithCustomInsecureSkipVerify(true))
-		logger.Warn("GigaChat TLS certificate verification is disabled")
+// ItemAnalysis is a receipt line item of the model's JSON answer
+type ItemAnalysis struct {
+	Name      string                     `json:"name"`
+	Quantity  float64                    `json:"quantity"`
+	UnitPrice float64                    `json:"unit_price"`
+	Total     float64                    `json:"total"`
+	VATRate   string                     `json:"vat_rate"`
+	Category  models.TransactionCategory `json:"category"`
+}
+
+// receiptItemsInstruction extends the transaction format of AnalyzeTransaction for receipts
+const receiptItemsInstruction = `
+
+Это кассовый чек. Обычно чек - это одна покупка: верни одну транзакцию на сумму ИТОГ, дата - дата чека.
+Для каждой транзакции-покупки дополнительно верни поле "items" - позиции чека в том порядке, в каком они напечатаны:
+"items": [
+  {
+    "name": "название товара или услуги как в чеке",
+    "quantity": число - количество или вес,
+    "unit_price": число - цена за единицу,
+    "total": число - стоимость позиции с учётом скидки,
+    "vat_rate": "20%%|18%%|10%%|0%%|none|пустая строка, если ставка НДС не указана",
+    "category": "%s"
+  }
+]
+- Категорию позиции выбирай по самому товару: лекарство в чеке супермаркета - healthcare, бытовая химия - shopping
+- Скидки и бонусы учитывай в total позиции, а не отдельными позициями
+- Сумма total всех позиций должна совпадать с amount транзакции`
+
+// statementInstruction extends the rules of AnalyzeTransaction for bank statements
+const statementInstruction = `
+
+Это банковская выписка:
+- Каждая строка операции - отдельная транзакция, не объединяй операции и не пропускай их
+- Верни только списания: поступления, пополнения, входящие переводы и возвраты пропусти
+- Остатки на начало и конец периода, обороты и строки "Итого" не являются транзакциями
+- Дата транзакции - дата операции, а не дата проводки или формирования выписки
+- В bank укажи банк, выпустивший выписку`
+
+// screenshotInstruction extends the rules of AnalyzeTransaction for screenshots of banking apps;
+// the current date resolves relative dates
+const screenshotInstruction = `
+
+Это скриншот мобильного банка: история операций, экран перевода или платежа, уведомление:
+- Каждая видимая операция списания - отдельная транзакция; поступления и пополнения пропусти
+- Баланс, доступный остаток, кешбэк и бонусы не являются транзакциями
+- Сегодня %s: относительные даты ("сегодня", "вчера", "12 марта" без года) переводи в YYYY-MM-DD
+- В description укажи получателя или магазин, как он показан в приложении
+- В bank укажи банк приложения, если его можно определить по тексту`
+
+func (s *LLMService) AnalyzeTransaction(ctx context.Context, extractedText string, docType models.DocumentType) ([]*TransactionAnalysis, []JSONFailure, error) {
+	// If extracted text is too short or empty, return empty array
+	extractedText = strings.TrimSpace(extractedText)
+	if len(extractedText) < 10 {
+		s.logger.Warn("Extracted text is too short, skipping analysis", zap.Int("length", len(extractedText)))
+		return []*TransactionAnalysis{}, nil, nil
 	}
 
-	client, err := gigago.NewClient(ctx, cfg.APIKey, opts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GigaChat client: %w", err)
+	prompt := fmt.Sprintf(`Ты финансовый аналитик. Проанализируй текст из финансового документа и извлеки информацию о транзакциях.
+
+ВАЖНО: Верни ТОЛЬКО валидный JSON массив, без дополнительных комментариев или объяснений.
+
+Текст документа:
+%s
+
+Верни JSON массив транзакций в следующем формате:
+[
+  {
+    "description": "краткое описание операции",
+    "category": "%s",
+    "amount": число,
+    "currency": "код валюты ISO 4217, например RUB, USD, EUR",
+    "date": "YYYY-MM-DD",
+    "llm_description": "подробное описание операции на русском языке",
+    "bank": "банк карты или счёта, если он указан в документе, иначе пустая строка"
+  }
+]
+
+ПРАВИЛА:
+- Если транзакций нет или текст не содержит финансовой информации, верни пустой массив: []
+- Комиссии банка (обслуживание, SMS-информирование, комиссия за перевод) относи к категории fees
+- amount - положительное число без знака минус
+- Если дата операции не указана, используй дату документа; если нет и её, верни пустую строку
+- Используй только поля из формата выше
+- Верни ТОЛЬКО JSON, без markdown разметки, без комментариев до или после JSON
+- Если текст слишком короткий или неполный, верни пустой массив: []`, extractedText, categoryList())
+	switch docType {
+	case models.DocumentTypeReceipt:
+		prompt += fmt.Sprintf(receiptItemsInstruction, categoryList())
+	case models.DocumentTypeStatement:
+		prompt += statementInstruction
+	case models.DocumentTypeScreenshot:
+		prompt += fmt.Sprintf(screenshotInstruction, time.Now().Format("2006-01-02"))
 	}
 
-	model := client.GenerativeModel("GigaChat")
-	model.SystemInstruction = buildSystemInstruction()
-	model.Temperature = 0.3
+	var transactions []*TransactionAnalysis
+	failures, err := s.chatJSON(ctx, prompt, func(raw string) error {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
 
-	// Create HTTP client for file uploads
-	httpClient := &http.Client{}
-	if cfg.InsecureSkipVerify {
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		var decoded []*TransactionAnalysis
+		if err := decoder.Decode(&decoded); err != nil {
+			return fmt.Errorf("ответ должен быть JSON массивом транзакций в заданном формате: %w", err)
+		}
+		if decoder.More() {
+			return fmt.Errorf("после JSON массива есть лишние данные")
 		}
-		logger.Warn("HTTP client TLS certificate verification is disabled")
-	}
 
-	// Get access token for file uploads
-	accessToken, err := getAccessToken(ctx, cfg, httpClient, logger)
+		if err := validateTransactions(decoded, time.Now()); err != nil {
+			return err
+		}
+		transactions = decoded
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get access token: %w", err)
-	}
-
-	service := &LLMService{
-		client:      client,
-		model:       model,
-		config:      cfg,
-		logger:      logger,
-		httpClient:  httpClient,
-		accessToken: accessToken,
-		// Base URL for GigaChat REST API
-		// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
-		baseURL: "https://gigachat.devices.sberbank.ru/api/v1",
+		return nil, failures, fmt.Errorf("failed to analyze transactions: %w", err)
 	}
 
-	// Use only GigaChat model
-	service.availableModels = []string{"GigaChat"}
-	logger.Info("Using GigaChat model")
+	s.logger.Info("Transaction analysis completed",
+		zap.String("document_type", string(docType)),
+		zap.Int("count", len(transactions)),
+		zap.Int("rejected_answers", len(failures)),
+	)
 
-	return service, nil
+	return transactions, failures, nil
 }
 
-// getAccessToken obtains an access token from GigaChat OAuth endpoint
-// This is needed for file uploads and other direct API calls
-// According to GigaChat API docs, API key should already be Base64-encoded
-func getAccessToken(ctx context.Context, cfg *config.GigaChatConfig, httpClient *http.Client, logger *zap.Logger) (string, error) {
-	// OAuth endpoint for GigaChat
-	oauthURL := "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"
+// classifyTextLimit is the number of characters of the document text shown to the classifier:
+// the header of a document tells its type
+const classifyTextLimit = 2000
 
-	// Generate RqUID as required by GigaChat API
-	rqUID := uuid.New().String()
-
-	// Prepare form data
-	formData := url.Values{}
-	formData.Set("scope", cfg.Scope)
+// documentClassification is the JSON answer of ClassifyDocument
+type documentClassification struct {
+	Type       models.DocumentType `json:"type"`
+	Confidence float64             `json:"confidence"`
+}
 
-	// Create request with form data
-	req, err := http.NewRequestWithContext(ctx, "POST", oauthURL, strings.NewReader(formData.Encode()))
-	if err != nil {
-		return "", fmt.Errorf("failed to create OAuth request: %w", err)
+// ClassifyDocument asks the model whether the text of a document is a receipt, a bank statement
+// or a screenshot of a banking app. It returns the type and the model's confidence from 0 to 1.
+func (s *LLMService) ClassifyDocument(ctx context.Context, extractedText string) (models.DocumentType, float64, []JSONFailure, error) {
+	text := []rune(strings.TrimSpace(extractedText))
+	if len(text) > classifyTextLimit {
+		text = text[:classifyTextLimit]
 	}
 
-	// Set headers according to GigaChat API documentation
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("RqUID", rqUID)
-	// API key should already be Base64-encoded (as per GigaChat API docs)
-	req.Header.Set("Authorization", "Basic "+cfg.APIKey)
+	prompt := fmt.Sprintf(`Определи тип финансового документа по тексту, распознанному с изображения или PDF.
 
-	// Make request
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to get access token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		logger.Error("OAuth request failed",
-			zap.Int("status", resp.StatusCode),
-			zap.String("response", string(bodyBytes)),
-			zap.String("rq_uid", rqUID),
-		)
-		return "", fmt.Errorf("OAuth failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
+Текст документа:
+%s
 
-	var oauthResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-	}
+Типы:
+- receipt - кассовый чек магазина или кафе: позиции с ценами, ИТОГ, НДС, ФН, ФД, ФП, кассир, смена
+- statement - банковская выписка по счёту или карте за период: таблица операций, остатки на начало и конец периода
+- screenshot - скриншот мобильного банка: история операций, экран перевода или платежа, уведомление, баланс
 
-	if err := json.NewDecoder(resp.Body).Decode(&oauthResp); err != nil {
-		return "", fmt.Errorf("failed to decode OAuth response: %w", err)
-	}
+Верни JSON объект:
+{"type": "receipt|statement|screenshot", "confidence": число от 0 до 1 - уверенность в типе}
 
-	if oauthResp.AccessToken == "" {
-		return "", fmt.Errorf("empty access token in OAuth response")
-	}
+ПРАВИЛА:
+- Текст распознан OCR и может содержать ошибки
+- Если признаков мало, выбери наиболее вероятный тип и укажи низкую уверенность
+- Верни ТОЛЬКО JSON, без markdown разметки, без комментариев до или после JSON`, string(text))
+
+	var result documentClassification
+	failures, err := s.chatJSON(ctx, prompt, func(raw string) error {
+		var answer documentClassification
+		if err := json.Unmarshal([]byte(raw), &answer); err != nil {
+			return fmt.Errorf("ответ должен быть JSON объектом с полями type и confidence: %w", err)
+		}
 
-	logger.Info("Access token obtained", zap.Int("expires_in", oauthResp.ExpiresIn))
-	return oauthResp.AccessToken, nil
-}
+		var errs validationErrors
+		answer.Type = models.DocumentType(strings.ToLower(strings.TrimSpace(string(answer.Type))))
+		if !answer.Type.Valid() {
+			errs = append(errs, fmt.Sprintf("тип %q не из списка receipt, statement, screenshot", answer.Type))
+		}
+		if answer.Confidence < 0 || answer.Confidence > 1 {
+			errs = append(errs, fmt.Sprintf("уверенность %v вне диапазона от 0 до 1", answer.Confidence))
+		}
+		if len(errs) > 0 {
+			return errs
+		}
 
-// GetAvailableModels retrieves list of available models from GigaChat API
-// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
-// Endpoint: GET /models
-func (s *LLMService) GetAvailableModels(ctx context.Context) ([]string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"/models", nil)
+		result = answer
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return "", 0, failures, fmt.Errorf("failed to classify document: %w", err)
 	}
 
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+	return result.Type, result.Confidence, failures, nil
+}
 
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get models: %w", err)
-	}
-	defer resp.Body.Close()
+// StatementOperation is an operation of an imported statement to be categorized
+type StatementOperation struct {
+	Description  string
+	BankCategory string // category assigned by the bank, if any
+	MCC          string
+	Amount       float64
+	Currency     string
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get models with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
+// categorizeBatchSize is the number of distinct operations sent in one categorization request
+const categorizeBatchSize = 50
+
+// operationCategory is one answer of CategorizeOperations
+type operationCategory struct {
+	N        int                        `json:"n"`
+	Category models.TransactionCategory `json:"category"`
+}
 
-	var modelsResp struct {
-		Data []struct {
-			ID      string `json:"id"`
-			Object  string `json:"object"`
-			Created int64  `json:"created"`
-			OwnedBy string `json:"owned_by"`
-		} `json:"data"`
+// CategorizeOperations picks a category for every operation of an imported statement.
+// Unlike AnalyzeTransaction the model sees only descriptions and returns nothing but categories,
+// so amounts and dates of the statement cannot be altered. Operations with the same description,
+// bank category and MCC are sent once. Categories are returned in the order of operations.
+func (s *LLMService) CategorizeOperations(ctx context.Context, operations []StatementOperation) ([]models.TransactionCategory, []JSONFailure, error) {
+	type operationKey struct{ description, bankCategory, mcc string }
+
+	var distinct []StatementOperation
+	index := make(map[operationKey]int)
+	positions := make([]int, len(operations))
+	for i, op := range operations {
+		key := operationKey{strings.ToLower(op.Description), op.BankCategory, op.MCC}
+		n, ok := index[key]
+		if !ok {
+			n = len(distinct)
+			index[key] = n
+			distinct = append(distinct, op)
+		}
+		positions[i] = n
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
-		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	distinctCategories := make([]models.TransactionCategory, 0, len(distinct))
+	var failures []JSONFailure
+	for start := 0; start < len(distinct); start += categorizeBatchSize {
+		batch := distinct[start:min(start+categorizeBatchSize, len(distinct))]
+		categories, batchFailures, err := s.categorizeBatch(ctx, batch)
+		failures = append(failures, batchFailures...)
+		if err != nil {
+			return nil, failures, fmt.Errorf("failed to categorize operations: %w", err)
+		}
+		distinctCategories = append(distinctCategories, categories...)
 	}
 
-	var modelIDs []string
-	for _, model := range modelsResp.Data {
-		modelIDs = append(modelIDs, model.ID)
+	categories := make([]models.TransactionCategory, len(operations))
+	for i, n := range positions {
+		categories[i] = distinctCategories[n]
 	}
 
-	return modelIDs, nil
-}
+	s.logger.Info("Statement operations categorized",
+		zap.Int("operations", len(operations)),
+		zap.Int("distinct", len(distinct)),
+		zap.Int("rejected_answers", len(failures)),
+	)
 
-type TransactionAnalysis struct {
-	Description    string                     `json:"description"`
-	Category       models.TransactionCategory `json:"category"`
-	Amount         float64                    `json:"amount"`
-	Currency       string                     `json:"currency"`
-	Date           string                     `json:"date"`
-	LLMDescription string                     `json:"llm_description"`
+	return categories, failures, nil
 }
 
-// AnalyzeTransaction analyzes extracted text and returns structured transaction data
-func (s *LLMService) AnalyzeTransaction(ctx context.Context, extractedText string) ([]*TransactionAnalysis, error) {
-	// If extracted text is too short or empty, return empty array
-	extractedText = strings.TrimSpace(extractedText)
-	if len(extractedText) < 10 {
-		s.logger.Warn("Extracted text is too short, skipping analysis", zap.Int("length", len(extractedText)))
-		return []*TransactionAnalysis{}, nil
+func (s *LLMService) categorizeBatch(ctx context.Context, operations []StatementOperation) ([]models.TransactionCategory, []JSONFailure, error) {
+	var list strings.Builder
+	for i, op := range operations {
+		fmt.Fprintf(&list, "%d. %s: %.2f %s", i+1, op.Description, op.Amount, op.Currency)
+		if op.BankCategory != "" {
+			fmt.Fprintf(&list, ", категория банка: %s", op.BankCategory)
+		}
+		if op.MCC != "" {
+			fmt.Fprintf(&list, ", MCC %s", op.MCC)
+		}
+		list.WriteString("\n")
 	}
 
-	prompt := fmt.Sprintf(`Ты финансовый аналитик. Проанализируй текст из финансового документа и извлеки информацию о транзакциях.
-
-ВАЖНО: Верни ТОЛЬКО валидный JSON массив, без дополнительных комментариев или объяснений.
+	prompt := fmt.Sprintf(`Ты финансовый аналитик. Определи категорию каждой операции из банковской выписки.
 
-Текст документа:
+Операции:
 %s
-
-Верни JSON массив транзакций в следующем формате:
+Верни JSON массив, по одному элементу на каждую операцию:
 [
-  {
-    "description": "краткое описание операции",
-    "category": "food|transport|utilities|shopping|entertainment|healthcare|education|other",
-    "amount": число,
-    "currency": "RUB|USD|EUR",
-    "date": "YYYY-MM-DD",
-    "llm_description": "подробное описание операции на русском языке"
-  }
+  {"n": номер операции, "category": "%s"}
 ]
 
 ПРАВИЛА:
-- Если транзакций нет или текст не содержит финансовой информации, верни пустой массив: []
-- Верни ТОЛЬКО JSON, без markdown разметки, без комментариев до или после JSON
-- Если текст слишком короткий или неполный, верни пустой массив: []`, extractedText)
+- Номера операций от 1 до %d, каждый номер ровно один раз
+- Категорию банка и MCC используй как подсказку, но выбирай только из списка выше
+- Комиссии банка (обслуживание, SMS-информирование, комиссия за перевод) относи к категории fees
+- Если категорию определить нельзя, используй other
+- Верни ТОЛЬКО JSON, без markdown разметки, без комментариев до или после JSON`,
+		list.String(), categoryList(), len(operations))
+
+	var categories []models.TransactionCategory
+	failures, err := s.chatJSON(ctx, prompt, func(raw string) error {
+		var answer []operationCategory
+		if err := json.Unmarshal([]byte(raw), &answer); err != nil {
+			return fmt.Errorf("ответ должен быть JSON массивом категорий в заданном формате: %w", err)
+		}
+		decoded, err := validateOperationCategories(answer, len(operations))
+		if err != nil {
+			return err
+		}
+		categories = decoded
+		return nil
+	})
+	return categories, failures, err
+}
 
-	messages := []gigago.Message{
-		{Role: gigago.RoleUser, Content: prompt},
+// validateOperationCategories checks that every operation got exactly one valid category
+// and returns the categories in the order of operations
+func validateOperationCategories(answer []operationCategory, count int) ([]models.TransactionCategory, error) {
+	var errs validationErrors
+	categories := make([]models.TransactionCategory, count)
+
+	for _, a := range answer {
+		category := models.TransactionCategory(strings.ToLower(strings.TrimSpace(string(a.Category))))
+		switch {
+		case a.N < 1 || a.N > count:
+			errs = append(errs, fmt.Sprintf("номер операции %d вне диапазона от 1 до %d", a.N, count))
+		case categories[a.N-1] != "":
+			errs = append(errs, fmt.Sprintf("операция %d указана несколько раз", a.N))
+		case !category.Valid():
+			errs = append(errs, fmt.Sprintf("операция %d: категория %q не из списка %s", a.N, a.Category, categoryList()))
+		default:
+			categories[a.N-1] = category
+		}
 	}
 
-	resp, err := s.model.Generate(ctx, messages)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate response: %w", err)
+	var missing []string
+	for i, category := range categories {
+		if category == "" && !containsOperation(answer, i+1) {
+			missing = append(missing, fmt.Sprint(i+1))
+		}
 	}
-
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from LLM")
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Sprintf("нет категорий для операций: %s", strings.Join(missing, ", ")))
 	}
 
-	content := strings.TrimSpace(resp.Choices[0].Message.Content)
-
-	// Try to extract JSON from response (might be wrapped in markdown or have comments)
-	jsonStart := strings.Index(content, "[")
-	jsonEnd := strings.LastIndex(content, "]")
-
-	// If no JSON array found, check if it's a message about missing data
-	if jsonStart == -1 || jsonEnd == -1 {
-		// Check if LLM says there's no data - return empty array
-		contentLower := strings.ToLower(content)
-		if strings.Contains(contentLower, "нет данных") ||
-			strings.Contains(contentLower, "нет транзакций") ||
-			strings.Contains(contentLower, "не содержит") ||
-			strings.Contains(contentLower, "пустой") ||
-			strings.Contains(contentLower, "предоставьте") {
-			s.logger.Info("LLM indicated no transactions found, returning empty array")
-			return []*TransactionAnalysis{}, nil
-		}
-		return nil, fmt.Errorf("invalid response format: %s", content)
+	if len(errs) > 0 {
+		return nil, errs
 	}
+	return categories, nil
+}
 
-	jsonStr := content[jsonStart : jsonEnd+1]
-
-	var transactions []*TransactionAnalysis
-	if err := json.Unmarshal([]byte(jsonStr), &transactions); err != nil {
-		// Try to clean up JSON string (remove markdown code blocks if present)
-		jsonStr = strings.TrimSpace(jsonStr)
-		jsonStr = strings.TrimPrefix(jsonStr, "```json")
-		jsonStr = strings.TrimPrefix(jsonStr, "```")
-		jsonStr = strings.TrimSuffix(jsonStr, "```")
-		jsonStr = strings.TrimSpace(jsonStr)
-
-		if err := json.Unmarshal([]byte(jsonStr), &transactions); err != nil {
-			return nil, fmt.Errorf("failed to parse JSON response: %w, content: %s", err, content)
+func containsOperation(answer []operationCategory, n int) bool {
+	for _, a := range answer {
+		if a.N == n {
+			return true
 		}
 	}
+	return false
+}
 
-	s.logger.Info("Transaction analysis completed", zap.Int("count", len(transactions)))
+// RecommendationAnalysis is one recommendation of the model's JSON answer
+type RecommendationAnalysis struct {
+	Title            string  `json:"title"`
+	Description      string  `json:"description"`
+	PotentialSavings float64 `json:"potential_savings"`
+	SavingsBasis     string  `json:"savings_basis"`
+	Confidence       float64 `json:"confidence"`
+	Citations        []int   `json:"citations"`
+	Groups           []int   `json:"groups,omitempty"` // groups of GenerateDocumentRecommendations the recommendation covers
+}
 
-	return transactions, nil
+const (
+	maxRecommendations          = 3
+	maxDocumentRecommendations  = 7
+	maxRecommendationTitleRunes = 100
+)
+
+// RecommendationGroup is a group of similar transactions of a document for GenerateDocumentRecommendations
+type RecommendationGroup struct {
+	Category     models.TransactionCategory
+	Merchant     string // normalized merchant name; empty for a group of various merchants
+	Bank         string
+	Currency     string
+	Count        int
+	Total        float64
+	Transactions []*TransactionAnalysis // the largest transactions of the group, at most Count
 }
 
-// GenerateRecommendationPrompt generates a prompt for RAG-based recommendations
-func (s *LLMService) GenerateRecommendationPrompt(ctx context.Context, transaction *TransactionAnalysis, knowledgeContext string) (string, error) {
+// GenerateRecommendationPrompt asks for 1-3 recommendations for a transaction as JSON.
+// contextSize is the number of numbered entries in knowledgeContext; citations must refer to them.
+// A malformed or invalid answer is sent back to the model for repair (see chatJSON).
+// Rejected answers are returned even when the generation fails.
+func (s *LLMService) GenerateRecommendationPrompt(ctx context.Context, transaction *TransactionAnalysis, knowledgeContext string, contextSize int) ([]*RecommendationAnalysis, []JSONFailure, error) {
 	prompt := fmt.Sprintf(`На основе следующей транзакции и контекста из базы знаний, предложи рекомендации по сокращению расходов.
 
 Транзакция:
@@ -464,158 +602,284 @@ func (s *LLMService) GenerateRecommendationPrompt(ctx context.Context, transacti
 - Категория: %s
 - Сумма: %.2f %s
 - Дата: %s
-
+%s
 Контекст из базы знаний:
 %s
 
-Предложи 1-3 конкретные рекомендации по сокращению расходов для этой транзакции. Будь конкретным и практичным.`,
+Предложи 1-3 конкретные рекомендации по сокращению расходов для этой транзакции. Будь конкретным и практичным.
+Если указаны позиции чека, рекомендации могут касаться конкретных товаров, например замены дорогого товара аналогом.
+
+ВАЖНО: Верни ТОЛЬКО валидный JSON объект, без markdown разметки и комментариев, в следующем формате:
+{
+  "recommendations": [
+    {
+      "title": "короткий заголовок-действие (до 100 символов)",
+      "description": "что сделать и почему это поможет сэкономить (2-4 предложения)",
+      "potential_savings": число - оценка экономии в рублях, 0 если оценить нельзя,
+      "savings_basis": "как получена оценка, например «кэшбэк 5%% × 3200 руб = 160 руб»; пустая строка, если potential_savings = 0",
+      "confidence": число от 0 до 1 - насколько рекомендация обоснована контекстом,
+      "citations": [номера пунктов контекста, на которых основана рекомендация, например 1, 3]
+    }
+  ]
+}
+
+ПРАВИЛА:
+- В citations указывай только номера пунктов контекста от 1 до %d; если рекомендация не опирается на контекст, верни пустой массив
+- Не придумывай тарифы и цифры, которых нет в контексте или в транзакции; если экономию нельзя посчитать, укажи 0
+- Экономия не может превышать сумму транзакции, если рекомендация касается только этой транзакции`,
 		transaction.Description,
 		transaction.Category,
 		transaction.Amount,
 		transaction.Currency,
 		transaction.Date,
+		formatItems(transaction.Items, ""),
 		knowledgeContext,
+		contextSize,
 	)
 
-	messages := []gigago.Message{
-		{Role: gigago.RoleUser, Content: prompt},
-	}
-
-	resp, err := s.model.Generate(ctx, messages)
+	var recommendations []*RecommendationAnalysis
+	failures, err := s.chatJSON(ctx, prompt, func(raw string) error {
+		var answer struct {
+			Recommendations []*RecommendationAnalysis `json:"recommendations"`
+		}
+		if err := json.Unmarshal([]byte(raw), &answer); err != nil {
+			return fmt.Errorf("невалидный JSON: %w", err)
+		}
+		if err := validateRecommendations(answer.Recommendations, maxRecommendations, contextSize); err != nil {
+			return err
+		}
+		recommendations = answer.Recommendations
+		return nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to generate recommendation: %w", err)
+		return nil, failures, fmt.Errorf("failed to generate recommendation: %w", err)
 	}
 
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from LLM")
-	}
+	return recommendations, failures, nil
+}
 
-	return resp.Choices[0].Message.Content, nil
+// GenerateDocumentRecommendations asks for one consolidated set of recommendations for all groups
+// of a document. Every recommendation names the groups it covers, so advice that applies to several
+// groups is given once. contextSize is the number of numbered entries in knowledgeContext.
+// Rejected answers are returned even when the generation fails.
+func (s *LLMService) GenerateDocumentRecommendations(ctx context.Context, groups []*RecommendationGroup, knowledgeContext string, contextSize int) ([]*RecommendationAnalysis, []JSONFailure, error) {
+	prompt := fmt.Sprintf(`Проанализируй расходы из финансового документа и предложи рекомендации по их сокращению.
+
+Расходы сгруппированы по категории и продавцу:
+%s
+Контекст из базы знаний:
+%s
+
+Предложи от 1 до %d рекомендаций для документа в целом, начиная с самых выгодных. Будь конкретным и практичным.
+Одна рекомендация может относиться к нескольким группам: не повторяй один и тот же совет для разных групп, а объединяй их.
+Если указаны позиции чеков, рекомендации могут касаться конкретных товаров, например замены дорогого товара аналогом.
+
+ВАЖНО: Верни ТОЛЬКО валидный JSON объект, без markdown разметки и комментариев, в следующем формате:
+{
+  "recommendations": [
+    {
+      "title": "короткий заголовок-действие (до 100 символов)",
+      "description": "что сделать и почему это поможет сэкономить (2-4 предложения)",
+      "potential_savings": число - оценка экономии в рублях, 0 если оценить нельзя,
+      "savings_basis": "как получена оценка, например «кэшбэк 5%% × 3200 руб = 160 руб»; пустая строка, если potential_savings = 0",
+      "confidence": число от 0 до 1 - насколько рекомендация обоснована контекстом,
+      "groups": [номера групп, к которым относится рекомендация, например 1, 4],
+      "citations": [номера пунктов контекста, на которых основана рекомендация, например 1, 3]
+    }
+  ]
 }
 
-// UploadFile uploads a file to GigaChat and returns the file ID
-// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
-// Endpoint: POST /files
-// Returns error with 413 status if file is too large
-func (s *LLMService) UploadFile(ctx context.Context, fileReader io.Reader, fileName string) (string, error) {
-	// Helper function to create multipart request body
-	createBody := func(reader io.Reader) (*bytes.Buffer, string, error) {
-		var body bytes.Buffer
-		writer := multipart.NewWriter(&body)
-
-		// Add purpose field (required by GigaChat API)
-		// "general" allows using uploaded files in generation requests (Vision API)
-		// Documentation: https://developers.sber.ru/docs/ru/gigachat/guides/working-with-files
-		if err := writer.WriteField("purpose", "general"); err != nil {
-			return nil, "", fmt.Errorf("failed to write purpose field: %w", err)
-		}
-
-		// Determine MIME type from file extension
-		ext := strings.ToLower(filepath.Ext(fileName))
-		mimeType := mime.TypeByExtension(ext)
-		if mimeType == "" {
-			// Fallback to common types
-			switch ext {
-			case ".pdf":
-				mimeType = "application/pdf"
-			case ".jpg", ".jpeg":
-				mimeType = "image/jpeg"
-			case ".png":
-				mimeType = "image/png"
-			default:
-				mimeType = "application/octet-stream"
-			}
-		}
+ПРАВИЛА:
+- В groups указывай номера групп от 1 до %d, хотя бы одну группу
+- В citations указывай только номера пунктов контекста от 1 до %d; если рекомендация не опирается на контекст, верни пустой массив
+- Не придумывай тарифы и цифры, которых нет в контексте или в расходах; если экономию нельзя посчитать, укажи 0
+- Экономия не может превышать сумму расходов в группах рекомендации`,
+		formatRecommendationGroups(groups),
+		knowledgeContext,
+		maxDocumentRecommendations,
+		len(groups),
+		contextSize,
+	)
 
-		// Create form file with proper MIME type
-		part, err := writer.CreatePart(map[string][]string{
-			"Content-Type":        {mimeType},
-			"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName)},
-		})
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to create form file: %w", err)
+	var recommendations []*RecommendationAnalysis
+	failures, err := s.chatJSON(ctx, prompt, func(raw string) error {
+		var answer struct {
+			Recommendations []*RecommendationAnalysis `json:"recommendations"`
 		}
-
-		if _, err := io.Copy(part, reader); err != nil {
-			return nil, "", fmt.Errorf("failed to copy file: %w", err)
+		if err := json.Unmarshal([]byte(raw), &answer); err != nil {
+			return fmt.Errorf("невалидный JSON: %w", err)
 		}
-
-		if err := writer.Close(); err != nil {
-			return nil, "", fmt.Errorf("failed to close writer: %w", err)
+		if err := validateRecommendations(answer.Recommendations, maxDocumentRecommendations, contextSize); err != nil {
+			return err
 		}
-
-		return &body, writer.FormDataContentType(), nil
-	}
-
-	// Create request body
-	body, contentType, err := createBody(fileReader)
+		if err := validateRecommendationGroups(answer.Recommendations, groups); err != nil {
+			return err
+		}
+		recommendations = answer.Recommendations
+		return nil
+	})
 	if err != nil {
-		return "", err
+		return nil, failures, fmt.Errorf("failed to generate document recommendations: %w", err)
 	}
 
-	// Create request to GigaChat Files API
-	// Endpoint: POST https://gigachat.devices.sberbank.ru/api/v1/files
-	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/files", body)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
+	return recommendations, failures, nil
+}
 
-	// Set headers according to GigaChat API documentation
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+// formatRecommendationGroups lists the groups for the prompt, e.g.
+//
+//	Группа 1: food, «пятерочка» - операций: 12, сумма 8450.00 RUB, банк: Сбербанк
+//	  - 2024-03-01 Пятёрочка 1234: 1200.00 RUB
+//	  - другие операции группы: 11, сумма 7250.00 RUB
+func formatRecommendationGroups(groups []*RecommendationGroup) string {
+	var builder strings.Builder
+	for i, g := range groups {
+		merchant := "разные продавцы"
+		if g.Merchant != "" {
+			merchant = "«" + g.Merchant + "»"
+		}
+		builder.WriteString(fmt.Sprintf("Группа %d: %s, %s - операций: %d, сумма %.2f %s", i+1, g.Category, merchant, g.Count, g.Total, g.Currency))
+		if g.Bank != "" {
+			builder.WriteString(", банк: " + g.Bank)
+		}
+		builder.WriteString("\n")
 
-	// Make request
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %w", err)
+		listed := 0.0
+		for _, tx := range g.Transactions {
+			builder.WriteString(fmt.Sprintf("  - %s %s: %.2f %s\n", tx.Date, tx.Description, tx.Amount, tx.Currency))
+			builder.WriteString(formatItems(tx.Items, "    "))
+			listed += tx.Amount
+		}
+		if rest := g.Count - len(g.Transactions); rest > 0 {
+			builder.WriteString(fmt.Sprintf("  - другие операции группы: %d, сумма %.2f %s\n", rest, g.Total-listed, g.Currency))
+		}
 	}
-	defer resp.Body.Close()
+	return builder.String()
+}
 
-	if resp.StatusCode == http.StatusRequestEntityTooLarge {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("file too large (413): file exceeds maximum size limit: %s", string(bodyBytes))
+// maxPromptItems is the number of receipt items of a transaction listed in recommendation prompts
+const maxPromptItems = 20
+
+// formatItems lists receipt items for a recommendation prompt, largest first, e.g.
+//
+//	Позиции чека:
+//	- Сыр Российский 0.35 × 890.00 = 311.50 (food)
+func formatItems(items []*ItemAnalysis, indent string) string {
+	if len(items) == 0 {
+		return ""
+	}
+
+	sorted := append([]*ItemAnalysis(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Total > sorted[j].Total
+	})
+
+	var builder strings.Builder
+	builder.WriteString(indent + "Позиции чека:\n")
+	for i, item := range sorted {
+		if i == maxPromptItems {
+			builder.WriteString(fmt.Sprintf("%s- другие позиции: %d, сумма %.2f\n", indent, len(sorted)-i, itemsTotal(sorted[i:])))
+			break
+		}
+		builder.WriteString(fmt.Sprintf("%s- %s %g × %.2f = %.2f (%s)\n", indent, item.Name, item.Quantity, item.UnitPrice, item.Total, item.Category))
 	}
+	return builder.String()
+}
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		// Read response body before closing
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+// validateRecommendationGroups checks the groups of document recommendations and rejects
+// repeated advice; the error text is shown to the model
+func validateRecommendationGroups(recommendations []*RecommendationAnalysis, groups []*RecommendationGroup) error {
+	titles := make(map[string]int, len(recommendations))
 
-		// Token might have expired, try to refresh it
-		accessToken, err := getAccessToken(ctx, s.config, s.httpClient, s.logger)
-		if err != nil {
-			return "", fmt.Errorf("upload failed with 401, token refresh also failed: %w (original error: %s)", err, string(bodyBytes))
+	for i, rec := range recommendations {
+		n := i + 1
+		if len(rec.Groups) == 0 {
+			return fmt.Errorf("у рекомендации %d пустой groups: укажи номера групп, к которым она относится", n)
 		}
-		s.accessToken = accessToken
 
-		// Retry the request with new token - need to recreate request body
-		// Note: fileReader might be consumed, so we need to handle this differently
-		// For now, we'll return an error suggesting to retry the whole operation
-		return "", fmt.Errorf("token expired, please retry the operation (original error: %s)", string(bodyBytes))
-	}
+		total, rubles := 0.0, true
+		seen := make(map[int]bool, len(rec.Groups))
+		for _, g := range rec.Groups {
+			if g < 1 || g > len(groups) {
+				return fmt.Errorf("рекомендация %d ссылается на группу %d, а группы пронумерованы от 1 до %d", n, g, len(groups))
+			}
+			if !seen[g] {
+				seen[g] = true
+				total += groups[g-1].Total
+				rubles = rubles && groups[g-1].Currency == "RUB"
+			}
+		}
+		if rubles && rec.PotentialSavings > total {
+			return fmt.Errorf("экономия рекомендации %d (%.2f) больше суммы расходов её групп (%.2f)", n, rec.PotentialSavings, total)
+		}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		key := strings.ToLower(rec.Title)
+		if first, ok := titles[key]; ok {
+			return fmt.Errorf("рекомендации %d и %d повторяют друг друга: объедини их в одну и укажи все группы в groups", first, n)
+		}
+		titles[key] = n
 	}
 
-	// Parse upload response according to GigaChat API documentation
-	// Response format: {"id": "file_id", ...}
-	var uploadResp struct {
-		ID string `json:"id"`
-		// Other fields may include: name, size, created_at, etc.
+	return nil
+}
+
+// validateRecommendations checks the model's recommendations; the error text is shown to the model
+func validateRecommendations(recommendations []*RecommendationAnalysis, maxCount, contextSize int) error {
+	if len(recommendations) == 0 {
+		return fmt.Errorf("массив recommendations пустой, нужна хотя бы одна рекомендация")
+	}
+	if len(recommendations) > maxCount {
+		return fmt.Errorf("рекомендаций %d, допускается не больше %d", len(recommendations), maxCount)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	for i, rec := range recommendations {
+		n := i + 1
+		if rec == nil {
+			return fmt.Errorf("рекомендация %d пустая", n)
+		}
+		rec.Title = strings.TrimSpace(rec.Title)
+		rec.Description = strings.TrimSpace(rec.Description)
+		rec.SavingsBasis = strings.TrimSpace(rec.SavingsBasis)
+
+		switch {
+		case rec.Title == "":
+			return fmt.Errorf("у рекомендации %d нет title", n)
+		case utf8.RuneCountInString(rec.Title) > maxRecommendationTitleRunes:
+			return fmt.Errorf("title рекомендации %d длиннее %d символов", n, maxRecommendationTitleRunes)
+		case rec.Description == "":
+			return fmt.Errorf("у рекомендации %d нет description", n)
+		case rec.PotentialSavings < 0:
+			return fmt.Errorf("potential_savings рекомендации %d отрицательная", n)
+		case rec.PotentialSavings > 0 && rec.SavingsBasis == "":
+			return fmt.Errorf("у рекомендации %d указана экономия, но нет savings_basis с расчётом", n)
+		case rec.Confidence < 0 || rec.Confidence > 1:
+			return fmt.Errorf("confidence рекомендации %d должна быть от 0 до 1", n)
+		}
+
+		for _, c := range rec.Citations {
+			if c < 1 || c > contextSize {
+				if contextSize == 0 {
+					return fmt.Errorf("рекомендация %d ссылается на пункт %d, но контекст пустой: citations должен быть пустым массивом", n, c)
+				}
+				return fmt.Errorf("рекомендация %d ссылается на пункт %d, а в контексте пункты от 1 до %d", n, c, contextSize)
+			}
+		}
 	}
 
-	s.logger.Info("File uploaded to GigaChat", zap.String("file_id", uploadResp.ID))
+	return nil
+}
+
+// Embed generates embeddings for the input texts with the given model
+func (s *LLMService) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	return s.provider.Embed(ctx, model, input)
+}
 
-	return uploadResp.ID, nil
+// UploadFile uploads a file to the LLM provider and returns the file ID
+// Returns error with 413 status if file is too large
+func (s *LLMService) UploadFile(ctx context.Context, fileReader io.Reader, fileName string) (string, error) {
+	return s.provider.UploadFile(ctx, fileReader, fileName)
 }
 
-// ExtractTextFromImage uses GigaChat Vision API to extract text from an image or PDF
+// ExtractTextFromImage uses the provider's vision API to extract text from an image or PDF
 func (s *LLMService) ExtractTextFromImage(ctx context.Context, imagePath string) (string, error) {
 	// Open file (image or PDF)
 	file, err := os.Open(imagePath)
@@ -624,7 +888,7 @@ func (s *LLMService) ExtractTextFromImage(ctx context.Context, imagePath string)
 	}
 	defer file.Close()
 
-	// Upload file to GigaChat
+	// Upload file to the provider
 	fileID, err := s.UploadFile(ctx, file, filepath.Base(imagePath))
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file: %w", err)
@@ -651,126 +915,56 @@ func (s *LLMService) ExtractTextFromImage(ctx context.Context, imagePath string)
 Если текст не читается, верни пустую строку.`
 	}
 
-	// Use direct HTTP API call for Vision
 	return s.ExtractTextViaVisionAPI(ctx, fileID, prompt)
 }
 
-// ExtractTextViaVisionAPI uses GigaChat Vision API via HTTP
-// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
-// Endpoint: POST /chat/completions
-// Uses file attachments for vision processing
-// Uses GigaChat model
+// ExtractTextViaVisionAPI runs a vision request for an uploaded file
+// and rejects answers where the model refused instead of returning text
 func (s *LLMService) ExtractTextViaVisionAPI(ctx context.Context, fileID, prompt string) (string, error) {
-	// Use GigaChat model
-	modelName := "GigaChat"
-
-	s.logger.Info("Using GigaChat for Vision API", zap.String("file_id", fileID))
-
-	// Create chat completion request with vision
-	// According to GigaChat API docs, attachments format: [["file_id"]]
-	// Build request body with proper structure
-	requestBody := map[string]interface{}{
-		"model": modelName,
-		"messages": []map[string]interface{}{
-			{
-				"role":        "user",
-				"content":     prompt,
-				"attachments": [][]string{{fileID}}, // Array of arrays: [["file_id"]]
-			},
-		},
-		"temperature":        0.3,
-		"top_p":              0.0,
-		"stream":             false,
-		"max_tokens":         0,
-		"repetition_penalty": 1.0,
-	}
-
-	jsonData, err := json.Marshal(requestBody)
+	text, err := s.provider.ExtractText(ctx, fileID, prompt)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Log request for debugging
-	s.logger.Debug("Vision API request",
-		zap.String("model", modelName),
-		zap.String("file_id", fileID),
-		zap.String("request_body", string(jsonData)),
-	)
-
-	// Create request to GigaChat Chat Completions API
-	// Endpoint: POST https://gigachat.devices.sberbank.ru/api/v1/chat/completions
-	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set headers according to GigaChat API documentation
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.accessToken)
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("vision API failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	var visionResp struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+		return "", err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&visionResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	if isVisionRefusal(text) {
+		s.logger.Warn("LLM returned error message instead of extracted text",
+			zap.String("provider", s.provider.Name()),
+			zap.String("message", text),
+		)
+		return "", fmt.Errorf("model returned error message: %s", text)
 	}
 
-	if len(visionResp.Choices) == 0 {
-		return "", fmt.Errorf("no response from Vision API")
-	}
+	s.logger.Info("Text extracted via vision API",
+		zap.String("provider", s.provider.Name()),
+		zap.Int("text_length", len(text)),
+	)
+	return text, nil
+}
 
-	text := strings.TrimSpace(visionResp.Choices[0].Message.Content)
+// visionRefusalPhrases are phrases of a model answer that refuses to read a document
+// or asks for it again instead of returning its text
+var visionRefusalPhrases = []string{
+	"не могу помочь",
+	"не могу обработать",
+	"не могу извлечь",
+	"предоставьте содержимое",
+	"предоставь содержимое",
+	"cannot help",
+	"cannot process",
+	"please provide",
+}
 
-	// Check if LLM returned an error message instead of extracted text
+// isVisionRefusal reports whether recognized text is a refusal of the model rather than document text
+func isVisionRefusal(text string) bool {
 	textLower := strings.ToLower(text)
-	errorPhrases := []string{
-		"не могу помочь",
-		"не могу обработать",
-		"предоставьте содержимое",
-		"предоставь содержимое",
-		"не могу извлечь",
-		"cannot help",
-		"cannot process",
-		"please provide",
-		"не могу помочь с данным запросом",
-	}
-
-	for _, phrase := range errorPhrases {
+	for _, phrase := range visionRefusalPhrases {
 		if strings.Contains(textLower, phrase) {
-			s.logger.Warn("LLM returned error message instead of extracted text",
-				zap.String("model", modelName),
-				zap.String("message", text),
-			)
-			return "", fmt.Errorf("model returned error message: %s", text)
+			return true
 		}
 	}
-
-	s.logger.Info("Text extracted via GigaChat Vision",
-		zap.String("model", modelName),
-		zap.Int("text_length", len(text)),
-	)
-	return text, nil
+	return false
 }
 
 func (s *LLMService) Close() error {
-	if s.client != nil {
-		s.client.Close()
-	}
-	return nil
+	return s.provider.Close()
 }
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rag-iishka/internal/models"
+	"rag-iishka/pkg/imagefile"
+	"rag-iishka/pkg/llm"
+	"rag-iishka/pkg/llm/fakegigachat"
+	"rag-iishka/pkg/ocr"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const receiptText = `ООО "Ромашка"
+КАССОВЫЙ ЧЕК
+ПРИХОД
+Молоко 3.2% 1 л          89.90
+Хлеб бородинский         54.00
+ИТОГ                    143.90
+НДС 10%                  13.08
+ФН 9999078900012345 ФД 12345 ФП 1234567890`
+
+const receiptAnswer = `[{
+	"description": "Продукты в Ромашке",
+	"category": "food",
+	"amount": 143.90,
+	"currency": "RUB",
+	"date": "2024-12-20",
+	"llm_description": "Покупка продуктов",
+	"bank": "",
+	"items": [
+		{"name": "Молоко 3.2% 1 л", "quantity": 1, "unit_price": 89.90, "total": 89.90, "vat_rate": "10%", "category": "food"},
+		{"name": "Хлеб бородинский", "quantity": 1, "unit_price": 54.00, "total": 54.00, "vat_rate": "10%", "category": "food"}
+	]
+}]`
+
+// newFakeLLM starts a fake GigaChat server and an LLMService connected to it
+func newFakeLLM(t *testing.T) (*fakegigachat.Server, *LLMService) {
+	t.Helper()
+
+	fake := fakegigachat.New()
+	t.Cleanup(fake.Close)
+
+	cfg := fake.Config()
+	provider, err := llm.NewGigaChatProvider(&cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewGigaChatProvider: %v", err)
+	}
+	llmService := NewLLMService(provider, zap.NewNop())
+	t.Cleanup(func() { llmService.Close() })
+
+	return fake, llmService
+}
+
+func TestAnalyzeTransactionWithFakeGigaChat(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+	fake.OnChat("Проанализируй текст", receiptAnswer)
+
+	analyses, failures, err := llmService.AnalyzeTransaction(context.Background(), receiptText, models.DocumentTypeReceipt)
+	if err != nil {
+		t.Fatalf("AnalyzeTransaction: %v", err)
+	}
+	if len(failures) != 0 {
+		t.Errorf("unexpected rejected answers: %+v", failures)
+	}
+	if len(analyses) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(analyses))
+	}
+	if tx := analyses[0]; tx.Amount != 143.90 || tx.Category != models.CategoryFood || tx.Currency != "RUB" || len(tx.Items) != 2 {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	prompt := fake.ChatRequests()[0].LastUserMessage()
+	if !strings.Contains(prompt, "Это кассовый чек") || !strings.Contains(prompt, "ИТОГ                    143.90") {
+		t.Errorf("prompt has no receipt rules or document text:\n%s", prompt)
+	}
+}
+
+func TestAnalyzeTransactionRepairsInvalidJSON(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+
+	answers := []string{
+		`[{"description": "Продукты", "category": "groceries", "amount": 143.90, "currency": "RUB", "date": "2024-12-20", "llm_description": "", "bank": ""}]`,
+		receiptAnswer,
+	}
+	fake.OnChatFunc(
+		func(req fakegigachat.ChatRequest) bool {
+			return strings.Contains(req.Messages[1].Content, "Проанализируй текст")
+		},
+		func(fakegigachat.ChatRequest) string {
+			answer := answers[0]
+			answers = answers[1:]
+			return answer
+		},
+	)
+
+	analyses, failures, err := llmService.AnalyzeTransaction(context.Background(), receiptText, models.DocumentTypeReceipt)
+	if err != nil {
+		t.Fatalf("AnalyzeTransaction: %v", err)
+	}
+	if len(analyses) != 1 {
+		t.Errorf("got %d transactions, want 1", len(analyses))
+	}
+	if len(failures) != 1 || failures[0].Attempt != 1 {
+		t.Errorf("want the first answer rejected, got %+v", failures)
+	}
+
+	requests := fake.ChatRequests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d chat requests, want 2", len(requests))
+	}
+	if repair := requests[1].LastUserMessage(); !strings.Contains(repair, "не прошёл проверку") || !strings.Contains(repair, "groceries") {
+		t.Errorf("repair request does not list the errors:\n%s", repair)
+	}
+}
+
+func TestAnalyzeTransactionRepairsItemsTotal(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+
+	// The first answer lost the bread: the items do not add up to ИТОГ
+	answers := []string{strings.Replace(receiptAnswer, `"total": 54.00`, `"total": 0.54`, 1), receiptAnswer}
+	fake.OnChatFunc(
+		func(req fakegigachat.ChatRequest) bool {
+			return strings.Contains(req.Messages[1].Content, "Проанализируй текст")
+		},
+		func(fakegigachat.ChatRequest) string {
+			answer := answers[0]
+			answers = answers[1:]
+			return answer
+		},
+	)
+
+	analyses, failures, err := llmService.AnalyzeTransaction(context.Background(), receiptText, models.DocumentTypeReceipt)
+	if err != nil {
+		t.Fatalf("AnalyzeTransaction: %v", err)
+	}
+	if len(analyses) != 1 || itemsTotal(analyses[0].Items) != 143.90 {
+		t.Errorf("unexpected transactions: %+v", analyses)
+	}
+	if len(failures) != 1 {
+		t.Fatalf("want the first answer rejected, got %+v", failures)
+	}
+	if repair := fake.ChatRequests()[1].LastUserMessage(); !strings.Contains(repair, "сумма total позиций 90.44 не совпадает с amount 143.90") {
+		t.Errorf("repair request does not report the items total:\n%s", repair)
+	}
+}
+
+func TestGenerateRecommendationsWithFakeGigaChat(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+	fake.OnChat("предложи рекомендации по сокращению расходов", `{"recommendations": [{
+		"title": "Оплачивайте продукты картой с кэшбэком",
+		"description": "Карта с кэшбэком 5% на супермаркеты вернёт часть расходов.",
+		"potential_savings": 7.2,
+		"savings_basis": "5% × 143.90 руб",
+		"confidence": 0.8,
+		"citations": [1]
+	}]}`)
+	fake.OnChat("Проанализируй расходы из финансового документа", `{"recommendations": [{
+		"title": "Покупайте продукты по акциям",
+		"description": "Большая часть расходов - продукты, акции сетей снижают чек.",
+		"potential_savings": 0,
+		"savings_basis": "",
+		"confidence": 0.6,
+		"groups": [1],
+		"citations": []
+	}]}`)
+
+	transaction := &TransactionAnalysis{Description: "Продукты", Category: models.CategoryFood, Amount: 143.90, Currency: "RUB", Date: "2024-12-20"}
+
+	recommendations, failures, err := llmService.GenerateRecommendationPrompt(context.Background(), transaction, "1. Кэшбэк 5% в супермаркетах", 1)
+	if err != nil {
+		t.Fatalf("GenerateRecommendationPrompt: %v", err)
+	}
+	if len(failures) != 0 {
+		t.Errorf("unexpected rejected answers: %+v", failures)
+	}
+	if len(recommendations) != 1 || recommendations[0].PotentialSavings != 7.2 || recommendations[0].Citations[0] != 1 {
+		t.Errorf("unexpected recommendations: %+v", recommendations)
+	}
+
+	groups := []*RecommendationGroup{{
+		Category:     models.CategoryFood,
+		Merchant:     "ромашка",
+		Currency:     "RUB",
+		Count:        1,
+		Total:        143.90,
+		Transactions: []*TransactionAnalysis{transaction},
+	}}
+	recommendations, _, err = llmService.GenerateDocumentRecommendations(context.Background(), groups, "", 0)
+	if err != nil {
+		t.Fatalf("GenerateDocumentRecommendations: %v", err)
+	}
+	if len(recommendations) != 1 || recommendations[0].Groups[0] != 1 {
+		t.Errorf("unexpected document recommendations: %+v", recommendations)
+	}
+}
+
+func TestGenerateRecommendationsReturnsRejectedAnswers(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+	transaction := &TransactionAnalysis{Description: "Продукты", Category: models.CategoryFood, Amount: 143.90, Currency: "RUB", Date: "2024-12-20"}
+	groups := []*RecommendationGroup{{Category: models.CategoryFood, Currency: "RUB", Count: 1, Total: 143.90, Transactions: []*TransactionAnalysis{transaction}}}
+
+	// The first answer cites a context entry that does not exist, the second one is valid
+	answers := []string{
+		`{"recommendations": [{"title": "Кэшбэк", "description": "Оплачивайте картой с кэшбэком.", "potential_savings": 0, "savings_basis": "", "confidence": 0.5, "citations": [7]}]}`,
+		`{"recommendations": [{"title": "Кэшбэк", "description": "Оплачивайте картой с кэшбэком.", "potential_savings": 0, "savings_basis": "", "confidence": 0.5, "citations": [1]}]}`,
+	}
+	fake.OnChatFunc(
+		func(req fakegigachat.ChatRequest) bool {
+			return strings.Contains(req.Messages[1].Content, "предложи рекомендации по сокращению расходов")
+		},
+		func(fakegigachat.ChatRequest) string {
+			answer := answers[0]
+			answers = answers[1:]
+			return answer
+		},
+	)
+
+	recommendations, failures, err := llmService.GenerateRecommendationPrompt(context.Background(), transaction, "1. Кэшбэк 5% в супермаркетах", 1)
+	if err != nil {
+		t.Fatalf("GenerateRecommendationPrompt: %v", err)
+	}
+	if len(recommendations) != 1 || len(failures) != 1 || failures[0].Attempt != 1 {
+		t.Errorf("got %d recommendations and rejected answers %+v, want 1 and the first answer", len(recommendations), failures)
+	}
+
+	// Answers that never pass validation are all returned together with the error
+	fake.SetDefaultReply(`{"recommendations": []}`)
+	recommendations, failures, err = llmService.GenerateDocumentRecommendations(context.Background(), groups, "", 0)
+	if err == nil {
+		t.Fatalf("GenerateDocumentRecommendations succeeded with %+v", recommendations)
+	}
+	if len(failures) != maxJSONAttempts {
+		t.Errorf("got %d rejected answers, want %d", len(failures), maxJSONAttempts)
+	}
+}
+
+func TestFakeGigaChatFailures(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+	fake.OnChat("Извлеки весь текст", receiptText)
+	imagePath := writeTestImage(t)
+
+	// A provider error is returned at once, without repair requests
+	fake.FailNext("/chat/completions", http.StatusInternalServerError, "internal error")
+	if _, _, err := llmService.AnalyzeTransaction(context.Background(), receiptText, ""); err == nil {
+		t.Error("AnalyzeTransaction succeeded although the chat request failed")
+	}
+	if n := len(fake.ChatRequests()); n != 0 {
+		t.Errorf("got %d chat requests after a provider error, want 0", n)
+	}
+
+	fake.FailNext("/files", http.StatusRequestEntityTooLarge, "file too large")
+	if _, err := llmService.ExtractTextFromImage(context.Background(), imagePath); err == nil || !strings.Contains(err.Error(), "413") {
+		t.Errorf("want a 413 upload error, got %v", err)
+	}
+
+	// An expired token is refreshed and the request is repeated
+	fake.ExpireTokens()
+	issued := fake.TokensIssued()
+	text, err := llmService.ExtractTextFromImage(context.Background(), imagePath)
+	if err != nil {
+		t.Fatalf("ExtractTextFromImage after token expiry: %v", err)
+	}
+	if text != receiptText {
+		t.Errorf("got text %q", text)
+	}
+	if fake.TokensIssued() != issued+1 {
+		t.Errorf("got %d new tokens, want 1", fake.TokensIssued()-issued)
+	}
+	if len(fake.Files()) != 1 {
+		t.Errorf("got %d uploaded files, want 1", len(fake.Files()))
+	}
+}
+
+// TestRecognizeDocumentWithFakeGigaChat runs the recognition stage of ProcessDocument:
+// vision OCR, type detection and extraction, everything before the results are saved
+func TestRecognizeDocumentWithFakeGigaChat(t *testing.T) {
+	fake, llmService := newFakeLLM(t)
+	fake.OnChat("Извлеки весь текст", receiptText)
+	fake.OnChat("Проанализируй текст", receiptAnswer)
+
+	engine := ocr.NewVisionEngine(llmService, 0.9)
+	docService := &DocumentService{
+		ocrService: NewOCRService(engine, imagefile.NewDecoder("", zap.NewNop()), zap.NewNop()),
+		llmService: llmService,
+		logger:     zap.NewNop(),
+	}
+
+	doc := &models.Document{ID: uuid.New(), UserID: uuid.New()}
+	var stages []models.JobStage
+	transactions, text, receipt, err := docService.recognizeDocument(context.Background(), doc, writeTestImage(t), func(stage models.JobStage, _ int) {
+		stages = append(stages, stage)
+	})
+	if err != nil {
+		t.Fatalf("recognizeDocument: %v", err)
+	}
+
+	if text != receiptText {
+		t.Errorf("got text %q", text)
+	}
+	if receipt != nil {
+		t.Errorf("unexpected fiscal receipt %+v", receipt)
+	}
+	if doc.DetectedType != models.DocumentTypeReceipt || doc.TypeConfidence < heuristicTypeConfidence {
+		t.Errorf("detected type %q with confidence %.2f, want a confident receipt", doc.DetectedType, doc.TypeConfidence)
+	}
+	if len(stages) != 2 || stages[0] != models.JobStageOCR || stages[1] != models.JobStageAnalysis {
+		t.Errorf("got stages %v", stages)
+	}
+
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions, want 1", len(transactions))
+	}
+	tx := transactions[0]
+	if tx.DocumentID != doc.ID || tx.UserID != doc.UserID || tx.Amount != 143.90 || tx.Date.Format("2006-01-02") != "2024-12-20" {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+	if len(tx.Items) != 2 || tx.Items[0].Position != 1 || tx.Items[1].TransactionID != tx.ID {
+		t.Errorf("unexpected items: %+v", tx.Items)
+	}
+
+	// The keywords are conclusive, so the model was asked only for the text and the transactions
+	if n := len(fake.ChatRequests()); n != 2 {
+		t.Errorf("got %d chat requests, want 2", n)
+	}
+}
+
+func TestIsVisionRefusal(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"К сожалению, я не могу помочь с данным запросом.", true},
+		{"Не могу извлечь текст из изображения", true},
+		{"Пожалуйста, предоставьте содержимое документа", true},
+		{"I cannot process this image", true},
+		{"Please provide a clearer photo", true},
+		{receiptText, false},
+		{"Помогу сэкономить: кэшбэк 5%", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isVisionRefusal(tt.text); got != tt.want {
+			t.Errorf("isVisionRefusal(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// writeTestImage writes a blank PNG; the fake reads its "text" from the chat rules
+func writeTestImage(t *testing.T) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "receipt.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -1,93 +1,174 @@
 package service
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"image"
+	"image/jpeg"
 	"io"
 	"os"
-	"path/filepath"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"rag-iishka/pkg/fiscal"
+	"rag-iishka/pkg/imagefile"
+	"rag-iishka/pkg/ocr"
 
 	"github.com/gen2brain/go-fitz"
 	"go.uber.org/zap"
 )
 
+// fiscalScanPages is the number of leading PDF pages searched for a receipt QR code
+const fiscalScanPages = 2
+
+const (
+	// scanRenderDPI is the resolution of scanned PDF pages sent to the OCR engine:
+	// enough for the small print of statements while a page stays under a few megabytes
+	scanRenderDPI = 200
+
+	// A PDF text layer with fewer letters and digits than minPageTextRunes, or with less than
+	// minReadableShare readable characters, is replaced with vision OCR of the rendered page
+	minPageTextRunes = 20
+	minReadableShare = 0.8
+)
+
 type OCRService struct {
-	llmService *LLMService
-	logger     *zap.Logger
+	engine  ocr.Engine
+	decoder *imagefile.Decoder
+	logger  *zap.Logger
 }
 
-// NewOCRService creates a new OCR service instance using GigaChat Vision API
-func NewOCRService(llmService *LLMService, logger *zap.Logger) *OCRService {
+// NewOCRService creates a new OCR service instance recognizing images with the engine selected by OCR_PROVIDER
+func NewOCRService(engine ocr.Engine, decoder *imagefile.Decoder, logger *zap.Logger) *OCRService {
 	return &OCRService{
-		llmService: llmService,
-		logger:     logger,
+		engine:  engine,
+		decoder: decoder,
+		logger:  logger,
+	}
+}
+
+// SupportsFormat reports whether ExtractText can read files of the format
+func (s *OCRService) SupportsFormat(format imagefile.Format) bool {
+	return format == imagefile.FormatPDF || s.decoder.Supports(format)
+}
+
+// CountPages returns the number of pages of a PDF or multi-page TIFF file; other images have one page
+func (s *OCRService) CountPages(filePath string, format imagefile.Format) (int, error) {
+	switch format {
+	case imagefile.FormatPDF:
+		doc, err := fitz.New(filePath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open PDF: %w", err)
+		}
+		defer doc.Close()
+		return doc.NumPage(), nil
+	case imagefile.FormatTIFF:
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read image: %w", err)
+		}
+		return imagefile.TIFFPageCount(data)
+	default:
+		return 1, nil
 	}
 }
 
 // ExtractText extracts text from an image or PDF file
-// For PDF: uses go-fitz library for direct text extraction
-// For images: uses GigaChat Vision API
+// The format is detected from the content, not the file extension
+// For PDF: uses go-fitz library for direct text extraction; scanned pages go through the OCR engine
+// For images: uses the OCR engine (GigaChat Vision, Tesseract or the more confident of both)
 // Supports Russian and English languages for financial documents
-// Supported formats: .jpg, .jpeg, .png, .pdf
+// Supported formats: JPEG, PNG, PDF, WebP, BMP, multi-page TIFF and HEIC (with a HEIC converter installed)
 func (s *OCRService) ExtractText(ctx context.Context, filePath string) (string, error) {
 	// Validate file format
-	ext := strings.ToLower(filepath.Ext(filePath))
-	supportedFormats := []string{".jpg", ".jpeg", ".png", ".pdf"}
-	isSupported := false
-	for _, format := range supportedFormats {
-		if ext == format {
-			isSupported = true
-			break
-		}
+	format, err := imagefile.DetectFile(filePath)
+	if err != nil {
+		return "", err
 	}
-	if !isSupported {
-		return "", fmt.Errorf("unsupported file format: %s (supported: jpg, jpeg, png, pdf)", ext)
+	if !s.SupportsFormat(format) {
+		return "", fmt.Errorf("unsupported file format (supported: jpeg, png, pdf, webp, bmp, tiff, heic)")
 	}
 
 	var text string
-	var err error
 
 	// Use different methods for PDF and images
-	if ext == ".pdf" {
+	switch format {
+	case imagefile.FormatPDF:
 		// Extract text from PDF using go-fitz library
-		text, err = s.extractTextFromPDF(filePath)
+		text, err = s.extractTextFromPDF(ctx, filePath)
 		if err != nil {
 			return "", fmt.Errorf("failed to extract text from PDF: %w", err)
 		}
-	} else {
-		// Use GigaChat Vision API for images
-		text, err = s.llmService.ExtractTextFromImage(ctx, filePath)
+	case imagefile.FormatJPEG, imagefile.FormatPNG:
+		// Use the OCR engine for images
+		text, err = s.recognizeImage(ctx, filePath)
 		if err != nil {
-			return "", fmt.Errorf("failed to extract text with GigaChat Vision: %w", err)
+			return "", fmt.Errorf("failed to extract text with %s OCR: %w", s.engine.Name(), err)
+		}
+	default:
+		// Other formats are decoded and sent to the OCR engine page by page
+		text, err = s.extractTextFromImagePages(ctx, filePath, format)
+		if err != nil {
+			return "", fmt.Errorf("failed to extract text with %s OCR: %w", s.engine.Name(), err)
 		}
 	}
 
 	// Clean up extracted text
 	text = strings.TrimSpace(text)
 
-	// Determine file type for logging
-	fileType := "image"
-	if ext == ".pdf" {
-		fileType = "PDF"
-	}
-
 	s.logger.Info("OCR extraction completed",
 		zap.String("file", filePath),
-		zap.String("type", fileType),
-		zap.String("method", s.getExtractionMethod(ext)),
+		zap.String("type", string(format)),
+		zap.String("method", s.getExtractionMethod(format)),
 		zap.Int("text_length", len(text)),
 	)
 
 	if text == "" {
-		return "", fmt.Errorf("no text extracted from %s", fileType)
+		return "", fmt.Errorf("no text extracted from %s", format)
 	}
 
 	return text, nil
 }
 
-// extractTextFromPDF extracts text from PDF using go-fitz library
-func (s *OCRService) extractTextFromPDF(pdfPath string) (string, error) {
+// extractTextFromImagePages reads an image in a format the OCR engines do not accept (HEIC, WebP, TIFF, BMP):
+// every page is converted to JPEG and recognized, page texts are merged in page order
+func (s *OCRService) extractTextFromImagePages(ctx context.Context, filePath string, format imagefile.Format) (string, error) {
+	pages, err := s.decoder.Pages(ctx, filePath, format)
+	if err != nil {
+		return "", err
+	}
+
+	var textBuilder strings.Builder
+	for i, page := range pages {
+		pageText, err := s.recognizeRendered(ctx, page)
+		if err != nil {
+			if len(pages) == 1 || ctx.Err() != nil {
+				return "", err
+			}
+			s.logger.Warn("Failed to recognize image page",
+				zap.Int("page", i+1),
+				zap.String("file", filePath),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if pageText = strings.TrimSpace(pageText); pageText != "" {
+			textBuilder.WriteString(pageText)
+			textBuilder.WriteString("\n")
+		}
+	}
+
+	return textBuilder.String(), nil
+}
+
+// extractTextFromPDF extracts text from PDF using go-fitz library.
+// Pages without a usable text layer (scans, or text in fonts without a Unicode mapping)
+// are rendered and read with the OCR engine; page texts are merged in page order.
+func (s *OCRService) extractTextFromPDF(ctx context.Context, pdfPath string) (string, error) {
 	// Open PDF document
 	doc, err := fitz.New(pdfPath)
 	if err != nil {
@@ -96,9 +177,14 @@ func (s *OCRService) extractTextFromPDF(pdfPath string) (string, error) {
 	defer doc.Close()
 
 	var textBuilder strings.Builder
+	scannedPages := 0
 
 	// Extract text from all pages
 	for i := 0; i < doc.NumPage(); i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
 		pageText, err := doc.Text(i)
 		if err != nil {
 			s.logger.Warn("Failed to extract text from page",
@@ -106,10 +192,25 @@ func (s *OCRService) extractTextFromPDF(pdfPath string) (string, error) {
 				zap.String("file", pdfPath),
 				zap.Error(err),
 			)
-			continue
 		}
 
-		if pageText != "" {
+		if err != nil || isGarbageText(pageText) {
+			scannedPages++
+			pageText, err = s.recognizePage(ctx, doc, i)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return "", ctxErr
+				}
+				s.logger.Warn("Failed to recognize scanned page",
+					zap.Int("page", i+1),
+					zap.String("file", pdfPath),
+					zap.Error(err),
+				)
+				continue
+			}
+		}
+
+		if pageText = strings.TrimSpace(pageText); pageText != "" {
 			textBuilder.WriteString(pageText)
 			textBuilder.WriteString("\n") // Add newline between pages
 		}
@@ -125,51 +226,165 @@ func (s *OCRService) extractTextFromPDF(pdfPath string) (string, error) {
 	s.logger.Info("PDF text extracted using go-fitz",
 		zap.String("file", pdfPath),
 		zap.Int("pages", doc.NumPage()),
+		zap.Int("scanned_pages", scannedPages),
 		zap.Int("text_length", len(text)),
 	)
 
 	return text, nil
 }
 
+// recognizePage renders a PDF page and reads it with the OCR engine
+func (s *OCRService) recognizePage(ctx context.Context, doc *fitz.Document, page int) (string, error) {
+	img, err := doc.ImageDPI(page, scanRenderDPI)
+	if err != nil {
+		return "", fmt.Errorf("failed to render page: %w", err)
+	}
+
+	return s.recognizeRendered(ctx, img)
+}
+
+// recognizeRendered saves an image as JPEG, a format every OCR engine accepts, and reads it with the OCR engine
+func (s *OCRService) recognizeRendered(ctx context.Context, img image.Image) (string, error) {
+	file, err := os.CreateTemp("", "ocr-page-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: 90}); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to encode page: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write page: %w", err)
+	}
+
+	return s.recognizeImage(ctx, file.Name())
+}
+
+// recognizeImage reads an image with the OCR engine
+func (s *OCRService) recognizeImage(ctx context.Context, imagePath string) (string, error) {
+	result, err := s.engine.Recognize(ctx, imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	s.logger.Debug("Image recognized",
+		zap.String("file", imagePath),
+		zap.String("engine", result.Engine),
+		zap.Float64("confidence", result.Confidence),
+	)
+	return result.Text, nil
+}
+
+// isGarbageText reports whether a PDF text layer is unusable: too short to hold the page content,
+// or mostly characters that are not readable text. Cyrillic text in fonts without a Unicode mapping
+// comes out as Latin-1 letters ("Ñóììà"), replacement characters or private use code points.
+func isGarbageText(text string) bool {
+	var total, readable, alnum int
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+
+		switch {
+		case r == utf8.RuneError || unicode.Is(unicode.Co, r) || unicode.IsControl(r):
+		case r >= 0xC0 && r <= 0xFF:
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			readable++
+			alnum++
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			readable++
+		}
+	}
+
+	return alnum < minPageTextRunes || float64(readable) < minReadableShare*float64(total)
+}
+
+// ScanFiscalReceipt decodes the QR code of a Russian fiscal receipt from an image
+// or from the first pages of a PDF or multi-page image. It returns fiscal.ErrNotFound if there is none.
+func (s *OCRService) ScanFiscalReceipt(ctx context.Context, filePath string) (*fiscal.Receipt, error) {
+	format, err := imagefile.DetectFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == imagefile.FormatPDF {
+		doc, err := fitz.New(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PDF: %w", err)
+		}
+		defer doc.Close()
+
+		for i := 0; i < doc.NumPage() && i < fiscalScanPages; i++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			img, err := doc.Image(i)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render page %d: %w", i+1, err)
+			}
+			receipt, err := fiscal.Scan(img)
+			if !errors.Is(err, fiscal.ErrNotFound) {
+				return receipt, err
+			}
+		}
+		return nil, fiscal.ErrNotFound
+	}
+
+	pages, err := s.decoder.Pages(ctx, filePath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(pages) && i < fiscalScanPages; i++ {
+		receipt, err := fiscal.Scan(pages[i])
+		if !errors.Is(err, fiscal.ErrNotFound) {
+			return receipt, err
+		}
+	}
+	return nil, fiscal.ErrNotFound
+}
+
 // getExtractionMethod returns the method name used for extraction
-func (s *OCRService) getExtractionMethod(ext string) string {
-	if ext == ".pdf" {
+func (s *OCRService) getExtractionMethod(format imagefile.Format) string {
+	if format == imagefile.FormatPDF {
 		return "go-fitz"
 	}
-	return "GigaChat Vision"
+	return s.engine.Name()
 }
 
-// ExtractTextFromReader extracts text from an image or PDF reader
-func (s *OCRService) ExtractTextFromReader(ctx context.Context, reader io.Reader, format string) (string, error) {
+// ExtractTextFromReader extracts text from an image or PDF reader.
+// The format is detected from the content; the temporary file gets the matching extension,
+// as vision providers take the file type from the file name.
+func (s *OCRService) ExtractTextFromReader(ctx context.Context, reader io.Reader) (string, error) {
+	buffered := bufio.NewReaderSize(reader, imagefile.SniffLength)
+	head, err := buffered.Peek(imagefile.SniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read file data: %w", err)
+	}
+
+	format := imagefile.Detect(head)
+	if !s.SupportsFormat(format) {
+		return "", fmt.Errorf("unsupported file format (supported: jpeg, png, pdf, webp, bmp, tiff, heic)")
+	}
+
 	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "ocr-*.tmp")
+	tmpFile, err := os.CreateTemp("", "ocr-*"+format.Extension())
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
 	// Copy reader to temp file
-	if _, err := io.Copy(tmpFile, reader); err != nil {
+	if _, err := io.Copy(tmpFile, buffered); err != nil {
+		tmpFile.Close()
 		return "", fmt.Errorf("failed to copy file data: %w", err)
 	}
-
-	// Add extension based on format
-	ext := ".jpg"
-	switch format {
-	case "image/png":
-		ext = ".png"
-	case "application/pdf":
-		ext = ".pdf"
-	case "image/jpeg", "image/jpg":
-		ext = ".jpg"
-	}
-
-	newPath := tmpFile.Name() + ext
-	if err := os.Rename(tmpFile.Name(), newPath); err != nil {
-		return "", fmt.Errorf("failed to rename temp file: %w", err)
+	if err := tmpFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temp file: %w", err)
 	}
-	defer os.Remove(newPath)
 
-	return s.ExtractText(ctx, newPath)
+	return s.ExtractText(ctx, tmpFile.Name())
 }
@@ -2,61 +2,240 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"rag-iishka/internal/models"
 	"rag-iishka/internal/repository"
 	"rag-iishka/pkg/config"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+const (
+	// maxEmbeddingInputRunes keeps embedding input within the model context
+	// (GigaChat Embeddings accepts up to 512 tokens)
+	maxEmbeddingInputRunes = 1500
+
+	// embeddingBatchSize is the number of texts sent in one embeddings request
+	embeddingBatchSize = 16
+)
+
+// ErrEmbeddingDimensions is returned by CheckEmbeddingModel when the embedding model
+// returns vectors that do not fit the knowledge base schema
+var ErrEmbeddingDimensions = errors.New("embedding model dimensions do not match the knowledge base")
+
 type RAGService struct {
 	knowledgeRepo *repository.KnowledgeRepository
+	llmService    *LLMService
 	config        *config.RAGConfig
 	logger        *zap.Logger
 }
 
-func NewRAGService(knowledgeRepo *repository.KnowledgeRepository, cfg *config.RAGConfig, logger *zap.Logger) *RAGService {
+func NewRAGService(knowledgeRepo *repository.KnowledgeRepository, llmService *LLMService, cfg *config.RAGConfig, logger *zap.Logger) *RAGService {
 	return &RAGService{
 		knowledgeRepo: knowledgeRepo,
+		llmService:    llmService,
 		config:        cfg,
 		logger:        logger,
 	}
 }
 
-// SearchKnowledge searches for relevant knowledge base entries
-// In production, you would generate embeddings for the query and use vector search
-func (s *RAGService) SearchKnowledge(ctx context.Context, query string, transactionCategory *models.TransactionCategory) ([]*models.KnowledgeBase, error) {
-	// Map transaction category to knowledge type
-	var knowledgeType *models.KnowledgeType
-	if transactionCategory != nil {
-		// For now, search all types. In production, you might want to map categories to specific knowledge types
-		// For example: food -> bank_tariff, utilities -> gov_tariff, etc.
+// SearchKnowledge searches for relevant knowledge base entries.
+// The transaction category selects routes: parts of the knowledge base with a weight,
+// e.g. utilities go to government benefits and bank fees to the tariffs of the user's bank.
+// For every route vector search (query embedded with RAG_EMBEDDING_MODEL, cosine distance)
+// and Russian full-text search each return RAG_CANDIDATES entries; all rankings are combined
+// with reciprocal rank fusion and the best RAG_TOP_K entries are returned.
+// If the routes find nothing, the whole knowledge base is searched.
+func (s *RAGService) SearchKnowledge(ctx context.Context, query string, transactionCategory *models.TransactionCategory, bank string) ([]*models.KnowledgeBase, error) {
+	embedding, err := s.embedQuery(ctx, query)
+	if err != nil {
+		s.logger.Warn("Vector search unavailable, using full-text search only", zap.Error(err))
 	}
+	textQuery := buildTextQuery(query)
 
-	// Try vector search first (if embeddings are available)
-	// For now, fallback to text search
-	results, err := s.knowledgeRepo.SimpleTextSearch(ctx, query, s.config.TopK, knowledgeType)
+	routes := routesFor(s.config, transactionCategory, bank)
+	results, err := s.searchRoutes(ctx, textQuery, embedding, routes)
+	if err == nil && len(results) == 0 && isFiltered(routes) {
+		s.logger.Info("No knowledge found for category routes, searching the whole knowledge base")
+		results, err = s.searchRoutes(ctx, textQuery, embedding, unfilteredRoutes)
+	}
 	if err != nil {
-		s.logger.Warn("Vector search failed, using text search", zap.Error(err))
-		// Fallback to text search
-		results, err = s.knowledgeRepo.SimpleTextSearch(ctx, query, s.config.TopK, knowledgeType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to search knowledge base: %w", err)
-		}
+		return nil, err
 	}
 
-	s.logger.Info("Knowledge search completed", 
+	s.logger.Info("Knowledge search completed",
 		zap.String("query", query),
+		zap.Int("routes", len(routes)),
 		zap.Int("results", len(results)),
 	)
 
 	return results, nil
 }
 
-// BuildContext builds a context string from knowledge base results
+// searchRoutes runs vector and full-text search for every route and fuses the rankings.
+// It fails only if every search failed.
+func (s *RAGService) searchRoutes(ctx context.Context, textQuery string, embedding []float32, routes []knowledgeRoute) ([]*models.KnowledgeBase, error) {
+	var rankings []ranking
+	var lastErr error
+
+	for _, route := range routes {
+		if embedding != nil {
+			results, err := s.knowledgeRepo.SearchSimilar(ctx, embedding, s.config.EmbeddingModel, s.config.Candidates, route.filter)
+			if err != nil {
+				s.logger.Warn("Vector search failed", zap.Error(err))
+				lastErr = err
+			} else {
+				rankings = append(rankings, ranking{results: results, weight: route.weight * s.config.VectorWeight})
+			}
+		}
+
+		results, err := s.knowledgeRepo.FullTextSearch(ctx, textQuery, s.config.Candidates, route.filter)
+		if err != nil {
+			s.logger.Warn("Full-text search failed", zap.Error(err))
+			lastErr = err
+		} else {
+			rankings = append(rankings, ranking{results: results, weight: route.weight * s.config.TextWeight})
+		}
+	}
+
+	if len(rankings) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("failed to search knowledge base: %w", lastErr)
+	}
+
+	return fuseRankings(rankings, s.config.RRFK, s.config.TopK), nil
+}
+
+// ranking is the result list of one search with its weight in rank fusion
+type ranking struct {
+	results []*models.KnowledgeBase
+	weight  float64
+}
+
+// fuseRankings merges rankings with reciprocal rank fusion: every ranking adds
+// weight/(rrfK+rank) to the score of each of its entries, ranks starting at 1
+func fuseRankings(rankings []ranking, rrfK, topK int) []*models.KnowledgeBase {
+	type fused struct {
+		kb    *models.KnowledgeBase
+		score float64
+	}
+
+	byID := make(map[uuid.UUID]*fused)
+	var order []*fused
+	for _, r := range rankings {
+		for rank, kb := range r.results {
+			f, ok := byID[kb.ID]
+			if !ok {
+				f = &fused{kb: kb}
+				byID[kb.ID] = f
+				order = append(order, f)
+			}
+			f.score += r.weight / float64(rrfK+rank+1)
+		}
+	}
+
+	// Stable sort keeps the order of the first ranking for equal scores
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].score > order[j].score
+	})
+
+	if len(order) > topK {
+		order = order[:topK]
+	}
+
+	results := make([]*models.KnowledgeBase, len(order))
+	for i, f := range order {
+		results[i] = f.kb
+	}
+	return results
+}
+
+// buildTextQuery turns free text into a websearch_to_tsquery expression matching any of its words.
+// websearch_to_tsquery joins plain words with AND, which almost never matches a whole transaction description.
+func buildTextQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(words))
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		w = strings.ToLower(w)
+		// "or" is an operator of websearch_to_tsquery; one-letter words are never indexed
+		if utf8.RuneCountInString(w) < 2 || w == "or" || seen[w] {
+			continue
+		}
+		seen[w] = true
+		terms = append(terms, w)
+	}
+
+	return strings.Join(terms, " or ")
+}
+
+// CheckEmbeddingModel embeds a probe text with RAG_EMBEDDING_MODEL and checks that the vectors
+// have repository.EmbeddingDimensions, the size of the knowledge_base.embedding column
+func (s *RAGService) CheckEmbeddingModel(ctx context.Context) error {
+	embeddings, err := s.llmService.Embed(ctx, s.config.EmbeddingModel, []string{"проверка размерности"})
+	if err != nil {
+		return fmt.Errorf("failed to embed probe text with %s: %w", s.config.EmbeddingModel, err)
+	}
+	if len(embeddings) != 1 || len(embeddings[0]) != repository.EmbeddingDimensions {
+		dimensions := 0
+		if len(embeddings) > 0 {
+			dimensions = len(embeddings[0])
+		}
+		return fmt.Errorf("%w: %s returns %d dimensions, knowledge_base.embedding is vector(%d)",
+			ErrEmbeddingDimensions, s.config.EmbeddingModel, dimensions, repository.EmbeddingDimensions)
+	}
+	return nil
+}
+
+// embedQuery embeds the search query with the knowledge base embedding model
+func (s *RAGService) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	embeddings, err := s.llmService.Embed(ctx, s.config.EmbeddingModel, []string{truncateForEmbedding(query)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	return embeddings[0], nil
+}
+
+// EmbedKnowledge computes embeddings for knowledge base entries in batches
+// and stores them in the entries
+func (s *RAGService) EmbedKnowledge(ctx context.Context, entries []*models.KnowledgeBase) error {
+	for start := 0; start < len(entries); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		batch := entries[start:end]
+
+		input := make([]string, len(batch))
+		for i, kb := range batch {
+			input[i] = truncateForEmbedding(kb.Title + "\n" + kb.Content)
+		}
+
+		embeddings, err := s.llmService.Embed(ctx, s.config.EmbeddingModel, input)
+		if err != nil {
+			return fmt.Errorf("failed to embed knowledge entries: %w", err)
+		}
+
+		for i, kb := range batch {
+			kb.Embedding = embeddings[i]
+			kb.EmbeddingModel = s.config.EmbeddingModel
+		}
+	}
+
+	return nil
+}
+
+// BuildContext builds a context string from knowledge base results.
+// Entries are numbered [1], [2], ... in the order of results, so the model can cite them.
 func (s *RAGService) BuildContext(results []*models.KnowledgeBase) string {
 	if len(results) == 0 {
 		return "Нет релевантной информации в базе знаний."
@@ -66,13 +245,34 @@ func (s *RAGService) BuildContext(results []*models.KnowledgeBase) string {
 	builder.WriteString("Релевантная информация из базы знаний:\n\n")
 
 	for i, result := range results {
-		builder.WriteString(fmt.Sprintf("%d. [%s] %s\n", i+1, result.Type, result.Title))
+		builder.WriteString(fmt.Sprintf("[%d] (%s) %s%s\n", i+1, result.Type, result.Title, formatPages(result)))
 		builder.WriteString(fmt.Sprintf("   %s\n\n", result.Content))
 	}
 
 	return builder.String()
 }
 
+// formatPages returns the page range of a chunk for the prompt, e.g. ", стр. 3–4"
+func formatPages(kb *models.KnowledgeBase) string {
+	switch {
+	case kb.PageStart == 0:
+		return ""
+	case kb.PageEnd <= kb.PageStart:
+		return fmt.Sprintf(", стр. %d", kb.PageStart)
+	default:
+		return fmt.Sprintf(", стр. %d–%d", kb.PageStart, kb.PageEnd)
+	}
+}
+
+// truncateForEmbedding cuts text to the size accepted by the embedding model
+func truncateForEmbedding(text string) string {
+	runes := []rune(text)
+	if len(runes) <= maxEmbeddingInputRunes {
+		return text
+	}
+	return string(runes[:maxEmbeddingInputRunes])
+}
+
 // GenerateQueryFromTransaction generates a search query from transaction data
 func (s *RAGService) GenerateQueryFromTransaction(transaction *models.Transaction) string {
 	query := transaction.Description
@@ -80,6 +280,31 @@ func (s *RAGService) GenerateQueryFromTransaction(transaction *models.Transactio
 		query += " " + transaction.LLMDescription
 	}
 	query += " " + string(transaction.Category)
+	for _, name := range topItemNames(transaction.Items, queryItems) {
+		query += " " + name
+	}
+	if transaction.Bank != "" {
+		query += " " + transaction.Bank
+	}
 	return query
 }
 
+// queryItems is the number of receipt items added to a knowledge search query
+const queryItems = 3
+
+// topItemNames returns the names of the n most expensive receipt items
+func topItemNames(items []models.TransactionItem, n int) []string {
+	sorted := append([]models.TransactionItem(nil), items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Total > sorted[j].Total
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+
+	names := make([]string, len(sorted))
+	for i, item := range sorted {
+		names[i] = item.Name
+	}
+	return names
+}
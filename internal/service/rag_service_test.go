@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"rag-iishka/internal/models"
+	"rag-iishka/pkg/config"
+	"rag-iishka/pkg/llm"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestFuseRankings(t *testing.T) {
+	a, b, c, d := knowledgeEntry("a"), knowledgeEntry("b"), knowledgeEntry("c"), knowledgeEntry("d")
+
+	tests := []struct {
+		name     string
+		rankings []ranking
+		topK     int
+		want     []*models.KnowledgeBase
+	}{
+		{
+			name:     "one ranking keeps its order",
+			rankings: []ranking{{results: []*models.KnowledgeBase{a, b, c}, weight: 1}},
+			topK:     10,
+			want:     []*models.KnowledgeBase{a, b, c},
+		},
+		{
+			// c is second in both searches and outscores the entries found by one search only
+			name: "entries found by both searches rise",
+			rankings: []ranking{
+				{results: []*models.KnowledgeBase{a, c}, weight: 1},
+				{results: []*models.KnowledgeBase{b, c}, weight: 1},
+			},
+			topK: 10,
+			want: []*models.KnowledgeBase{c, a, b},
+		},
+		{
+			name: "weight decides between first places",
+			rankings: []ranking{
+				{results: []*models.KnowledgeBase{a}, weight: 0.5},
+				{results: []*models.KnowledgeBase{b}, weight: 1.5},
+			},
+			topK: 10,
+			want: []*models.KnowledgeBase{b, a},
+		},
+		{
+			name: "equal scores keep the order of the first ranking",
+			rankings: []ranking{
+				{results: []*models.KnowledgeBase{a, b}, weight: 1},
+				{results: []*models.KnowledgeBase{b, a}, weight: 1},
+			},
+			topK: 10,
+			want: []*models.KnowledgeBase{a, b},
+		},
+		{
+			name: "limit",
+			rankings: []ranking{
+				{results: []*models.KnowledgeBase{a, b, c, d}, weight: 1},
+				{results: []*models.KnowledgeBase{d, c}, weight: 1},
+			},
+			topK: 2,
+			want: []*models.KnowledgeBase{d, c},
+		},
+		{
+			name: "no rankings",
+			topK: 5,
+			want: []*models.KnowledgeBase{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseRankings(tt.rankings, 60, tt.topK)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", contents(got), contents(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", contents(got), contents(tt.want))
+				}
+			}
+		})
+	}
+}
+
+func TestFuseRankingsDeduplicatesByID(t *testing.T) {
+	// Vector and full-text search return separate copies of the same chunk
+	id := uuid.New()
+	vector := &models.KnowledgeBase{ID: id, Content: "chunk"}
+	text := &models.KnowledgeBase{ID: id, Content: "chunk"}
+	other := knowledgeEntry("other")
+
+	got := fuseRankings([]ranking{
+		{results: []*models.KnowledgeBase{vector, other}, weight: 1},
+		{results: []*models.KnowledgeBase{text}, weight: 1},
+	}, 60, 10)
+
+	if len(got) != 2 || got[0].ID != id || got[1] != other {
+		t.Errorf("got %v, want the chunk once, ahead of the other entry", contents(got))
+	}
+}
+
+func TestBuildTextQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Кешбэк на АЗС", "кешбэк or на or азс"},
+		{"Комиссия за перевод, комиссия за СБП!", "комиссия or за or перевод or сбп"},
+		{"Оплата ЖКХ 12.2024 и т.д.", "оплата or жкх or 12 or 2024"},
+		{"Apple or Google", "apple or google"},
+		{`"-налог" OR вычет`, "налог or вычет"},
+		{"", ""},
+		{"а и в", ""},
+	}
+
+	for _, tt := range tests {
+		if got := buildTextQuery(tt.text); got != tt.want {
+			t.Errorf("buildTextQuery(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCheckEmbeddingModel(t *testing.T) {
+	cfg := &config.RAGConfig{EmbeddingModel: "Embeddings"}
+
+	fake, llmService := newFakeLLM(t)
+	ragService := NewRAGService(nil, llmService, cfg, zap.NewNop())
+	if err := ragService.CheckEmbeddingModel(context.Background()); err != nil {
+		t.Errorf("CheckEmbeddingModel with 1024 dimensions: %v", err)
+	}
+
+	// An unavailable model is not a dimension mismatch
+	fake.FailNext("/embeddings", http.StatusInternalServerError, "internal error")
+	if err := ragService.CheckEmbeddingModel(context.Background()); err == nil || errors.Is(err, ErrEmbeddingDimensions) {
+		t.Errorf("got %v for a failed request, want a request error", err)
+	}
+
+	stub := NewLLMService(embeddingStub{dimensions: 1536}, zap.NewNop())
+	ragService = NewRAGService(nil, stub, cfg, zap.NewNop())
+	if err := ragService.CheckEmbeddingModel(context.Background()); !errors.Is(err, ErrEmbeddingDimensions) {
+		t.Errorf("got %v for 1536 dimensions, want ErrEmbeddingDimensions", err)
+	}
+}
+
+// embeddingStub is a provider whose embeddings have the given size; other methods are not implemented
+type embeddingStub struct {
+	llm.Provider
+	dimensions int
+}
+
+func (embeddingStub) Name() string { return "stub" }
+
+func (s embeddingStub) Embed(_ context.Context, _ string, input []string) ([][]float32, error) {
+	vectors := make([][]float32, len(input))
+	for i := range vectors {
+		vectors[i] = make([]float32, s.dimensions)
+	}
+	return vectors, nil
+}
+
+func knowledgeEntry(content string) *models.KnowledgeBase {
+	return &models.KnowledgeBase{ID: uuid.New(), Content: content}
+}
+
+func contents(entries []*models.KnowledgeBase) []string {
+	names := make([]string, len(entries))
+	for i, kb := range entries {
+		names[i] = kb.Content
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"rag-iishka/internal/models"
+)
+
+// transactionGroup is a set of document transactions with the same category, merchant and currency
+type transactionGroup struct {
+	category     models.TransactionCategory
+	merchant     string // merchantKey of the transactions; empty for various merchants
+	currency     string
+	transactions []*models.Transaction // largest first
+	total        float64
+}
+
+// bank returns the first known bank of the group's transactions
+func (g *transactionGroup) bank() string {
+	for _, tx := range g.transactions {
+		if tx.Bank != "" {
+			return tx.Bank
+		}
+	}
+	return ""
+}
+
+// merchantNoise are words of card statement descriptions that do not identify a merchant
+var merchantNoise = map[string]bool{
+	"оплата": true, "покупка": true, "списание": true, "платеж": true, "перевод": true,
+	"операция": true, "по": true, "карте": true, "карта": true, "на": true, "в": true,
+	"ооо": true, "ип": true, "ао": true, "пао": true, "зао": true, "оао": true,
+	"card": true, "pos": true, "payment": true, "purchase": true, "llc": true,
+}
+
+// merchantKey reduces a transaction description to a merchant name: its first significant word, e.g.
+// "Оплата по карте ПЯТЁРОЧКА 1234 MOSCOW" and "Пятерочка 0987" both become "пятерочка".
+// Words with digits and the words of merchantNoise are skipped.
+func merchantKey(description string) string {
+	words := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		word = strings.ReplaceAll(word, "ё", "е")
+		if len([]rune(word)) < 2 || merchantNoise[word] || strings.IndexFunc(word, unicode.IsDigit) != -1 {
+			continue
+		}
+		return word
+	}
+	return ""
+}
+
+// groupTransactions groups transactions by category, merchant and currency.
+// Merchants met only once are put together into one group per category and currency,
+// so a long statement does not produce a group for every shop. Groups are ordered by total, largest first.
+func groupTransactions(transactions []*models.Transaction) []*transactionGroup {
+	type groupKey struct {
+		category models.TransactionCategory
+		merchant string
+		currency string
+	}
+
+	byKey := make(map[groupKey]*transactionGroup)
+	var keys []groupKey
+	add := func(key groupKey, tx *models.Transaction) {
+		g, ok := byKey[key]
+		if !ok {
+			g = &transactionGroup{category: key.category, merchant: key.merchant, currency: key.currency}
+			byKey[key] = g
+			keys = append(keys, key)
+		}
+		g.transactions = append(g.transactions, tx)
+		g.total += tx.Amount
+	}
+
+	for _, tx := range transactions {
+		add(groupKey{tx.Category, merchantKey(tx.Description), tx.Currency}, tx)
+	}
+
+	// Fold single transactions of a category into a group of various merchants
+	single := func(key groupKey) bool {
+		return key.merchant != "" && len(byKey[key].transactions) == 1
+	}
+	singles := make(map[groupKey]int)
+	for _, key := range keys {
+		if single(key) {
+			singles[groupKey{key.category, "", key.currency}]++
+		}
+	}
+	for _, key := range append([]groupKey(nil), keys...) {
+		various := groupKey{key.category, "", key.currency}
+		if !single(key) || singles[various] < 2 {
+			continue
+		}
+		tx := byKey[key].transactions[0]
+		delete(byKey, key)
+		add(various, tx)
+	}
+
+	groups := make([]*transactionGroup, 0, len(byKey))
+	for _, key := range keys {
+		if g, ok := byKey[key]; ok {
+			sort.SliceStable(g.transactions, func(i, j int) bool {
+				return g.transactions[i].Amount > g.transactions[j].Amount
+			})
+			groups = append(groups, g)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].total > groups[j].total
+	})
+
+	return groups
+}
+
+// groupQuery builds the knowledge search query of a group from its largest transactions and their receipt items
+func groupQuery(g *transactionGroup) string {
+	parts := []string{string(g.category)}
+	if g.merchant != "" {
+		parts = append(parts, g.merchant)
+	}
+	for i, tx := range g.transactions {
+		if i == 3 {
+			break
+		}
+		parts = append(parts, tx.Description)
+		if tx.LLMDescription != "" {
+			parts = append(parts, tx.LLMDescription)
+		}
+		parts = append(parts, topItemNames(tx.Items, queryItems)...)
+	}
+	if bank := g.bank(); bank != "" {
+		parts = append(parts, bank)
+	}
+	return strings.Join(parts, " ")
+}
@@ -2,22 +2,35 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"sort"
+	"time"
 
 	"rag-iishka/internal/models"
 	"rag-iishka/internal/repository"
+	"rag-iishka/pkg/config"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// Recommendation modes (RECOMMENDATIONS_MODE)
+const (
+	RecommendationModeDocument    = "document"
+	RecommendationModeTransaction = "transaction"
+)
+
+const (
+	defaultGroupContext      = 3
+	defaultGroupTransactions = 10
+)
+
 type RecommendationService struct {
 	llmService *LLMService
 	ragService *RAGService
 	recRepo    *repository.RecommendationRepository
+	config     *config.RecommendationsConfig
 	logger     *zap.Logger
 }
 
@@ -25,25 +38,201 @@ func NewRecommendationService(
 	llmService *LLMService,
 	ragService *RAGService,
 	recRepo *repository.RecommendationRepository,
+	cfg *config.RecommendationsConfig,
 	logger *zap.Logger,
 ) *RecommendationService {
+	switch cfg.Mode {
+	case RecommendationModeDocument, RecommendationModeTransaction:
+	default:
+		logger.Warn("Unknown recommendation mode, using document mode", zap.String("mode", cfg.Mode))
+		cfg.Mode = RecommendationModeDocument
+	}
+
 	return &RecommendationService{
 		llmService: llmService,
 		ragService: ragService,
 		recRepo:    recRepo,
+		config:     cfg,
 		logger:     logger,
 	}
 }
 
-// GenerateRecommendations generates recommendations for a transaction
+// GenerateDocumentRecommendations generates recommendations for the transactions of a document
+// in the configured mode. In document mode transactions are grouped by category, merchant and currency,
+// knowledge is searched once per group and one consolidated set of recommendations is requested for
+// the whole document; in transaction mode every transaction is handled by GenerateRecommendations.
+// progress may be nil; otherwise it receives the number of completed and total steps.
+// Model answers rejected by validation are returned even when the generation fails.
+func (s *RecommendationService) GenerateDocumentRecommendations(
+	ctx context.Context,
+	transactions []*models.Transaction,
+	userID uuid.UUID,
+	progress func(done, total int),
+) ([]*models.Recommendation, []JSONFailure, error) {
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+	if len(transactions) == 0 {
+		return nil, nil, nil
+	}
+
+	if s.config.Mode == RecommendationModeTransaction {
+		return s.generatePerTransaction(ctx, transactions, userID, progress)
+	}
+
+	groups := groupTransactions(transactions)
+	searched := s.config.MaxGroups
+	if searched <= 0 || searched > len(groups) {
+		searched = len(groups)
+	}
+	perGroup := s.config.GroupContext
+	if perGroup <= 0 {
+		perGroup = defaultGroupContext
+	}
+
+	// 1. Search knowledge base once per group; entries found for several groups are listed once
+	var knowledgeResults []*models.KnowledgeBase
+	seen := make(map[uuid.UUID]bool)
+	for i, g := range groups[:searched] {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		results, err := s.ragService.SearchKnowledge(ctx, groupQuery(g), &g.category, g.bank())
+		if err != nil {
+			s.logger.Warn("Failed to search knowledge base", zap.Error(err), zap.String("category", string(g.category)))
+		}
+
+		taken := 0
+		for _, kb := range results {
+			if taken == perGroup {
+				break
+			}
+			if seen[kb.ID] {
+				continue
+			}
+			seen[kb.ID] = true
+			knowledgeResults = append(knowledgeResults, kb)
+			taken++
+		}
+		progress(i+1, searched+1)
+	}
+
+	// 2. Generate one set of recommendations for all groups
+	context := s.ragService.BuildContext(knowledgeResults)
+	analyses, failures, err := s.llmService.GenerateDocumentRecommendations(ctx, s.recommendationGroups(groups), context, len(knowledgeResults))
+	if err != nil {
+		return nil, failures, fmt.Errorf("failed to generate recommendation: %w", err)
+	}
+	progress(searched+1, searched+1)
+
+	// 3. Link recommendations to the transactions of their groups, the largest one being the main
+	now := time.Now()
+	recommendations := make([]*models.Recommendation, len(analyses))
+	for i, analysis := range analyses {
+		var covered []*models.Transaction
+		seenGroups := make(map[int]bool, len(analysis.Groups))
+		for _, number := range analysis.Groups {
+			if !seenGroups[number] {
+				seenGroups[number] = true
+				covered = append(covered, groups[number-1].transactions...)
+			}
+		}
+		sort.SliceStable(covered, func(a, b int) bool {
+			return covered[a].Amount > covered[b].Amount
+		})
+
+		transactionIDs := make([]uuid.UUID, len(covered))
+		for j, tx := range covered {
+			transactionIDs[j] = tx.ID
+		}
+		recommendations[i] = s.toRecommendation(analysis, transactionIDs, userID, knowledgeResults, now)
+	}
+
+	s.logger.Info("Document recommendations generated",
+		zap.Int("transactions", len(transactions)),
+		zap.Int("groups", len(groups)),
+		zap.Int("knowledge_entries", len(knowledgeResults)),
+		zap.Int("count", len(recommendations)),
+	)
+
+	return recommendations, failures, nil
+}
+
+// generatePerTransaction generates recommendations for every transaction separately.
+// A failed transaction is logged and skipped.
+func (s *RecommendationService) generatePerTransaction(
+	ctx context.Context,
+	transactions []*models.Transaction,
+	userID uuid.UUID,
+	progress func(done, total int),
+) ([]*models.Recommendation, []JSONFailure, error) {
+	var recommendations []*models.Recommendation
+	var failures []JSONFailure
+	for i, tx := range transactions {
+		if err := ctx.Err(); err != nil {
+			return nil, failures, err
+		}
+		recs, txFailures, err := s.GenerateRecommendations(ctx, tx, userID)
+		failures = append(failures, txFailures...)
+		if err != nil {
+			s.logger.Warn("Failed to generate recommendations", zap.Error(err), zap.String("transaction_id", tx.ID.String()))
+		} else {
+			recommendations = append(recommendations, recs...)
+		}
+		progress(i+1, len(transactions))
+	}
+	return recommendations, failures, nil
+}
+
+// recommendationGroups converts groups for the prompt, listing at most GroupTransactions of each
+func (s *RecommendationService) recommendationGroups(groups []*transactionGroup) []*RecommendationGroup {
+	limit := s.config.GroupTransactions
+	if limit <= 0 {
+		limit = defaultGroupTransactions
+	}
+
+	result := make([]*RecommendationGroup, len(groups))
+	for i, g := range groups {
+		listed := g.transactions
+		if len(listed) > limit {
+			listed = listed[:limit]
+		}
+
+		rg := &RecommendationGroup{
+			Category:     g.category,
+			Merchant:     g.merchant,
+			Bank:         g.bank(),
+			Currency:     g.currency,
+			Count:        len(g.transactions),
+			Total:        g.total,
+			Transactions: make([]*TransactionAnalysis, len(listed)),
+		}
+		for j, tx := range listed {
+			rg.Transactions[j] = &TransactionAnalysis{
+				Description: tx.Description,
+				Category:    tx.Category,
+				Amount:      tx.Amount,
+				Currency:    tx.Currency,
+				Date:        tx.Date.Format("2006-01-02"),
+				Items:       toItemAnalyses(tx.Items),
+			}
+		}
+		result[i] = rg
+	}
+	return result
+}
+
+// GenerateRecommendations generates recommendations for a transaction.
+// Model answers rejected by validation are returned even when the generation fails.
 func (s *RecommendationService) GenerateRecommendations(
 	ctx context.Context,
 	transaction *models.Transaction,
 	userID uuid.UUID,
-) ([]*models.Recommendation, error) {
+) ([]*models.Recommendation, []JSONFailure, error) {
 	// 1. Search knowledge base
 	query := s.ragService.GenerateQueryFromTransaction(transaction)
-	knowledgeResults, err := s.ragService.SearchKnowledge(ctx, query, &transaction.Category)
+	knowledgeResults, err := s.ragService.SearchKnowledge(ctx, query, &transaction.Category, transaction.Bank)
 	if err != nil {
 		s.logger.Warn("Failed to search knowledge base", zap.Error(err))
 		knowledgeResults = []*models.KnowledgeBase{}
@@ -61,138 +250,119 @@ func (s *RecommendationService) GenerateRecommendations(
 		Currency:       transaction.Currency,
 		LLMDescription: transaction.LLMDescription,
 		Date:           transaction.Date.Format("2006-01-02"),
+		Items:          toItemAnalyses(transaction.Items),
 	}
 
-	llmResponse, err := s.llmService.GenerateRecommendationPrompt(ctx, transactionAnalysis, context)
+	analyses, failures, err := s.llmService.GenerateRecommendationPrompt(ctx, transactionAnalysis, context, len(knowledgeResults))
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate recommendation: %w", err)
+		return nil, failures, fmt.Errorf("failed to generate recommendation: %w", err)
 	}
 
-	// 4. Parse recommendations from LLM response
-	recommendations := s.parseRecommendations(llmResponse, transaction.ID, userID, knowledgeResults)
+	// 4. Convert validated recommendations to models
+	now := time.Now()
+	recommendations := make([]*models.Recommendation, len(analyses))
+	for i, analysis := range analyses {
+		recommendations[i] = s.toRecommendation(analysis, []uuid.UUID{transaction.ID}, userID, knowledgeResults, now)
+	}
 
 	s.logger.Info("Recommendations generated",
 		zap.String("transaction_id", transaction.ID.String()),
 		zap.Int("count", len(recommendations)),
 	)
 
-	return recommendations, nil
+	return recommendations, failures, nil
 }
 
-// parseRecommendations parses recommendations from LLM response
-func (s *RecommendationService) parseRecommendations(
-	llmResponse string,
-	transactionID uuid.UUID,
-	userID uuid.UUID,
-	knowledgeResults []*models.KnowledgeBase,
-) []*models.Recommendation {
-	var recommendations []*models.Recommendation
-
-	// Simple parsing: split by numbered list or bullet points
-	lines := strings.Split(llmResponse, "\n")
-	currentRec := &models.Recommendation{
-		ID:            uuid.New(),
-		TransactionID: transactionID,
-		UserID:        userID,
-		Source:        "llm",
+// toItemAnalyses converts stored receipt items for recommendation prompts
+func toItemAnalyses(items []models.TransactionItem) []*ItemAnalysis {
+	if len(items) == 0 {
+		return nil
 	}
 
-	var currentText strings.Builder
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	analyses := make([]*ItemAnalysis, len(items))
+	for i, item := range items {
+		analyses[i] = &ItemAnalysis{
+			Name:      item.Name,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Total:     item.Total,
+			VATRate:   string(item.VATRate),
+			Category:  item.Category,
 		}
-
-		// Check if line starts a new recommendation (numbered or bulleted)
-		if matched, _ := regexp.MatchString(`^(\d+[\.\)]|[-*])\s+`, line); matched {
-			// Save previous recommendation if exists
-			if currentText.Len() > 0 {
-				currentRec.Description = sanitizeUTF8(currentText.String())
-				currentRec.Title = sanitizeUTF8(s.extractTitle(currentRec.Description))
-				currentRec.PotentialSavings = s.extractSavings(currentRec.Description, knowledgeResults)
-				recommendations = append(recommendations, currentRec)
-			}
-
-			// Start new recommendation
-			currentRec = &models.Recommendation{
-				ID:            uuid.New(),
-				TransactionID: transactionID,
-				UserID:        userID,
-				Source:        s.getSourceFromKnowledge(knowledgeResults),
-			}
-			currentText.Reset()
-			line = regexp.MustCompile(`^(\d+[\.\)]|[-*])\s+`).ReplaceAllString(line, "")
-		}
-
-		currentText.WriteString(line)
-		currentText.WriteString(" ")
-	}
-
-	// Save last recommendation
-	if currentText.Len() > 0 {
-		currentRec.Description = sanitizeUTF8(currentText.String())
-		currentRec.Title = sanitizeUTF8(s.extractTitle(currentRec.Description))
-		currentRec.PotentialSavings = s.extractSavings(currentRec.Description, knowledgeResults)
-		recommendations = append(recommendations, currentRec)
-	}
-
-	// If no structured recommendations found, create one from entire response
-	if len(recommendations) == 0 {
-		recommendations = append(recommendations, &models.Recommendation{
-			ID:               uuid.New(),
-			TransactionID:    transactionID,
-			UserID:           userID,
-			Title:            sanitizeUTF8("Рекомендация по сокращению расходов"),
-			Description:      sanitizeUTF8(llmResponse),
-			PotentialSavings: s.extractSavings(llmResponse, knowledgeResults),
-			Source:           s.getSourceFromKnowledge(knowledgeResults),
-		})
 	}
-
-	return recommendations
+	return analyses
 }
 
-func (s *RecommendationService) extractTitle(description string) string {
-	// Extract first sentence or first 50 characters as title
-	sentences := strings.Split(description, ".")
-	if len(sentences) > 0 {
-		title := strings.TrimSpace(sentences[0])
-		if len(title) > 100 {
-			title = title[:100] + "..."
-		}
-		return title
+// toRecommendation converts a validated recommendation of the model covering transactionIDs;
+// the first of them is the main transaction. Source is the knowledge type of the first cited entry,
+// or "llm" without citations.
+func (s *RecommendationService) toRecommendation(
+	analysis *RecommendationAnalysis,
+	transactionIDs []uuid.UUID,
+	userID uuid.UUID,
+	knowledgeResults []*models.KnowledgeBase,
+	now time.Time,
+) *models.Recommendation {
+	rec := &models.Recommendation{
+		ID:               uuid.New(),
+		TransactionID:    transactionIDs[0],
+		TransactionIDs:   transactionIDs,
+		UserID:           userID,
+		Title:            sanitizeUTF8(analysis.Title),
+		Description:      sanitizeUTF8(analysis.Description),
+		PotentialSavings: analysis.PotentialSavings,
+		SavingsBasis:     sanitizeUTF8(analysis.SavingsBasis),
+		Confidence:       analysis.Confidence,
+		Source:           "llm",
+		CreatedAt:        now,
 	}
 
-	if len(description) > 100 {
-		return description[:100] + "..."
+	rec.Citations = citationsFor(rec.ID, analysis.Citations, knowledgeResults)
+	if len(rec.Citations) > 0 {
+		rec.Source = string(rec.Citations[0].Type)
 	}
-	return description
+
+	return rec
 }
 
-func (s *RecommendationService) extractSavings(description string, knowledgeResults []*models.KnowledgeBase) float64 {
-	// Try to extract potential savings amount from description
-	// Look for patterns like "сэкономить 1000 руб", "экономия 5%", etc.
+// citationsFor resolves cited context numbers to the knowledge base entries of the prompt;
+// numbers are 1-based positions in knowledgeResults, unknown and repeated numbers are skipped
+func citationsFor(recommendationID uuid.UUID, numbers []int, knowledgeResults []*models.KnowledgeBase) []models.RecommendationCitation {
+	var citations []models.RecommendationCitation
+	seen := make(map[int]bool)
 
-	// Pattern 1: "X руб" or "X рублей"
-	re1 := regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(?:руб|рублей|RUB)`)
-	matches := re1.FindStringSubmatch(description)
-	if len(matches) > 1 {
-		if amount, err := strconv.ParseFloat(matches[1], 64); err == nil {
-			return amount
+	for _, number := range numbers {
+		if number < 1 || number > len(knowledgeResults) || seen[number] {
+			continue
 		}
-	}
+		seen[number] = true
 
-	// Pattern 2: "X%" - calculate percentage of transaction amount
-	// This would require transaction amount, so we'll skip for now
+		kb := knowledgeResults[number-1]
+		knowledgeID := kb.ID
+		citations = append(citations, models.RecommendationCitation{
+			RecommendationID: recommendationID,
+			Number:           number,
+			KnowledgeID:      &knowledgeID,
+			Type:             kb.Type,
+			Title:            kb.Title,
+			SourceFile:       metadataString(kb.Metadata, "source_file"),
+			PageStart:        kb.PageStart,
+			PageEnd:          kb.PageEnd,
+		})
+	}
 
-	return 0.0
+	return citations
 }
 
-func (s *RecommendationService) getSourceFromKnowledge(knowledgeResults []*models.KnowledgeBase) string {
-	if len(knowledgeResults) > 0 {
-		return string(knowledgeResults[0].Type)
+// metadataString returns a string field of a knowledge base metadata JSON
+func metadataString(metadata, key string) string {
+	if metadata == "" {
+		return ""
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(metadata), &fields); err != nil {
+		return ""
 	}
-	return "llm"
+	value, _ := fields[key].(string)
+	return value
 }
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"rag-iishka/internal/models"
+)
+
+// earliestTransactionDate rejects dates that are almost certainly misread years
+var earliestTransactionDate = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// currencyAliases maps currency spellings the model returns instead of ISO 4217 codes
+var currencyAliases = map[string]string{
+	"RUR": "RUB",
+	"₽":   "RUB",
+	"РУБ": "RUB",
+	"$":   "USD",
+	"€":   "EUR",
+	"£":   "GBP",
+	"¥":   "CNY",
+	"₸":   "KZT",
+}
+
+// iso4217Codes are the active ISO 4217 currency codes
+var iso4217Codes = makeCodeSet(`
+	AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD
+	CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD
+	GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT
+	LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR
+	NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP
+	STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XOF
+	XPF YER ZAR ZMW ZWL
+`)
+
+func makeCodeSet(codes string) map[string]bool {
+	set := make(map[string]bool)
+	for _, code := range strings.Fields(codes) {
+		set[code] = true
+	}
+	return set
+}
+
+// categoryList returns categories in the "food|transport|..." form used in prompts
+func categoryList() string {
+	names := make([]string, len(models.TransactionCategories))
+	for i, category := range models.TransactionCategories {
+		names[i] = string(category)
+	}
+	return strings.Join(names, "|")
+}
+
+// validateTransactions normalizes the model's transactions in place and checks categories,
+// amounts, ISO 4217 currency codes and dates. All problems are returned at once,
+// so that the model can fix them in one repair request.
+func validateTransactions(transactions []*TransactionAnalysis, now time.Time) error {
+	var errs validationErrors
+	latest := now.AddDate(0, 0, 1)
+
+	for i, tx := range transactions {
+		n := i + 1
+		if tx == nil {
+			errs = append(errs, fmt.Sprintf("транзакция %d: пустой элемент массива", n))
+			continue
+		}
+
+		tx.Description = strings.TrimSpace(tx.Description)
+		tx.Category = models.TransactionCategory(strings.ToLower(strings.TrimSpace(string(tx.Category))))
+		tx.Currency = normalizeCurrency(tx.Currency)
+		tx.Date = strings.TrimSpace(tx.Date)
+
+		if tx.Description == "" {
+			errs = append(errs, fmt.Sprintf("транзакция %d: пустое поле description", n))
+		}
+		if !tx.Category.Valid() {
+			errs = append(errs, fmt.Sprintf("транзакция %d: категория %q не из списка %s", n, tx.Category, categoryList()))
+		}
+		if tx.Amount <= 0 {
+			errs = append(errs, fmt.Sprintf("транзакция %d: amount должен быть положительным числом, получено %v", n, tx.Amount))
+		}
+		if !iso4217Codes[tx.Currency] {
+			errs = append(errs, fmt.Sprintf("транзакция %d: валюта %q не является кодом ISO 4217 (например RUB, USD, EUR)", n, tx.Currency))
+		}
+		if tx.Date != "" {
+			date, err := time.Parse("2006-01-02", tx.Date)
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Sprintf("транзакция %d: дата %q не в формате YYYY-MM-DD", n, tx.Date))
+			case date.Before(earliestTransactionDate) || date.After(latest):
+				errs = append(errs, fmt.Sprintf("транзакция %d: дата %s вне допустимого диапазона (с %s по сегодня)", n, tx.Date, earliestTransactionDate.Format("2006-01-02")))
+			}
+		}
+
+		errs = append(errs, validateItems(n, tx)...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateItems normalizes and checks the receipt items of transaction n.
+// Items without a category inherit the category of the transaction; their totals
+// must add up to the amount within itemsTotalTolerance.
+func validateItems(n int, tx *TransactionAnalysis) []string {
+	var errs []string
+
+	for i, item := range tx.Items {
+		prefix := fmt.Sprintf("транзакция %d, позиция %d", n, i+1)
+		if item == nil {
+			errs = append(errs, prefix+": пустой элемент массива items")
+			continue
+		}
+
+		item.Name = strings.TrimSpace(item.Name)
+		item.Category = models.TransactionCategory(strings.ToLower(strings.TrimSpace(string(item.Category))))
+		if item.Category == "" {
+			item.Category = tx.Category
+		}
+		if item.Quantity == 0 {
+			item.Quantity = 1
+		}
+
+		if item.Name == "" {
+			errs = append(errs, prefix+": пустое поле name")
+		}
+		if item.Quantity < 0 {
+			errs = append(errs, fmt.Sprintf("%s: quantity должно быть положительным числом, получено %v", prefix, item.Quantity))
+		}
+		if item.UnitPrice < 0 || item.Total < 0 {
+			errs = append(errs, prefix+": unit_price и total не могут быть отрицательными, скидки учитывай в total")
+		}
+		if !item.Category.Valid() {
+			errs = append(errs, fmt.Sprintf("%s: категория %q не из списка %s", prefix, item.Category, categoryList()))
+		}
+		rate, ok := normalizeVATRate(item.VATRate)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: ставка НДС %q не из списка 20%%, 18%%, 10%%, 0%%, none", prefix, item.VATRate))
+		}
+		item.VATRate = string(rate)
+	}
+
+	if total := itemsTotal(tx.Items); len(tx.Items) > 0 && tx.Amount > 0 && math.Abs(total-tx.Amount) > itemsTotalTolerance(tx.Amount) {
+		errs = append(errs, fmt.Sprintf("транзакция %d: сумма total позиций %.2f не совпадает с amount %.2f - проверь позиции и ИТОГ чека, скидки учитывай в total позиций", n, total, tx.Amount))
+	}
+
+	return errs
+}
+
+// itemsTotal returns the sum of the item totals
+func itemsTotal(items []*ItemAnalysis) float64 {
+	total := 0.0
+	for _, item := range items {
+		if item != nil {
+			total += item.Total
+		}
+	}
+	return total
+}
+
+// itemsTotalTolerance is the allowed difference between the items and the amount of a receipt:
+// 1% or 1 unit of currency for rounding, whichever is larger
+func itemsTotalTolerance(amount float64) float64 {
+	return math.Max(1, amount*0.01)
+}
+
+// normalizeVATRate maps VAT rates printed on receipts ("НДС 20%", "20/120", "Без НДС") to models.VATRate
+func normalizeVATRate(rate string) (models.VATRate, bool) {
+	key := strings.ToLower(strings.TrimSpace(rate))
+	key = strings.TrimSpace(strings.TrimPrefix(key, "ндс"))
+
+	switch {
+	case key == "":
+		return models.VATRateUnknown, true
+	case key == "none" || strings.Contains(key, "без"):
+		return models.VATRateNone, true
+	case strings.HasPrefix(key, "20"):
+		return models.VATRate20, true
+	case strings.HasPrefix(key, "18"):
+		return models.VATRate18, true
+	case strings.HasPrefix(key, "10"):
+		return models.VATRate10, true
+	case strings.HasPrefix(key, "0"):
+		return models.VATRate0, true
+	}
+	return models.VATRateUnknown, false
+}
+
+// normalizeCurrency upper-cases a currency and replaces common symbols and legacy codes
+func normalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	currency = strings.TrimSuffix(currency, ".")
+	if code, ok := currencyAliases[currency]; ok {
+		return code
+	}
+	return currency
+}
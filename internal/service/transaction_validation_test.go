@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"rag-iishka/internal/models"
+)
+
+func TestValidateTransactionsItemsTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		items  []float64
+		valid  bool
+	}{
+		{"exact", 143.90, []float64{89.90, 54.00}, true},
+		{"rounding within 1 ruble", 50, []float64{25.40, 25.40}, true},
+		{"within 1% of a large receipt", 10000, []float64{5000, 4920}, true},
+		{"no items", 500, nil, true},
+		{"missing item", 143.90, []float64{89.90}, false},
+		{"discount not applied", 1000, []float64{600, 500}, false},
+		{"just over 1 ruble", 50, []float64{25, 26.5}, false},
+	}
+
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &TransactionAnalysis{Description: "Покупка", Category: models.CategoryFood, Amount: tt.amount, Currency: "RUB", Date: "2025-01-10"}
+			for i, total := range tt.items {
+				tx.Items = append(tx.Items, &ItemAnalysis{Name: "Товар " + string(rune('A'+i)), Quantity: 1, UnitPrice: total, Total: total})
+			}
+
+			err := validateTransactions([]*TransactionAnalysis{tx}, now)
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.valid {
+				var errs validationErrors
+				if !errors.As(err, &errs) || len(errs) != 1 || !strings.Contains(errs[0], "не совпадает с amount") {
+					t.Errorf("got %v, want one items total error", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS processing_version VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP;
+
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS force BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS force;
+
+ALTER TABLE documents DROP COLUMN IF EXISTS processed_at;
+ALTER TABLE documents DROP COLUMN IF EXISTS processing_version;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS vector;
+
+-- Embeddings stored as REAL[] were never populated, so the column is recreated.
+-- 1024 is the dimension of the GigaChat "Embeddings" model.
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS embedding;
+ALTER TABLE knowledge_base ADD COLUMN embedding vector(1024);
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS embedding_model VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_knowledge_base_embedding ON knowledge_base USING hnsw (embedding vector_cosine_ops);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_knowledge_base_embedding;
+
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS embedding_model;
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS embedding;
+ALTER TABLE knowledge_base ADD COLUMN embedding REAL[];
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A source is a document the knowledge base was built from (e.g. a tariff PDF);
+-- knowledge_base rows are its chunks
+CREATE TABLE IF NOT EXISTS knowledge_sources (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    type VARCHAR(20) NOT NULL CHECK (type IN ('bank_tariff', 'gov_tariff', 'education')),
+    title VARCHAR(255) NOT NULL,
+    source_file VARCHAR(500) NOT NULL UNIQUE,
+    file_hash VARCHAR(64) NOT NULL DEFAULT '',
+    page_count INTEGER NOT NULL DEFAULT 0,
+    metadata JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- source_id is NULL for entries created before chunking
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS source_id UUID REFERENCES knowledge_sources(id) ON DELETE CASCADE;
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS chunk_index INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS page_start INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS page_end INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE knowledge_base ADD COLUMN IF NOT EXISTS token_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_knowledge_base_source_id ON knowledge_base(source_id, chunk_index);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_knowledge_base_source_id;
+
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS token_count;
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS page_end;
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS page_start;
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS chunk_index;
+ALTER TABLE knowledge_base DROP COLUMN IF EXISTS source_id;
+
+DROP TABLE IF EXISTS knowledge_sources;
+-- +goose StatementEnd
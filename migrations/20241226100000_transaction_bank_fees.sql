@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Bank commissions get their own category so that they are matched with bank tariffs
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_category_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_category_check
+    CHECK (category IN ('food', 'transport', 'utilities', 'shopping', 'entertainment', 'healthcare', 'education', 'fees', 'other'));
+
+-- Bank that issued the card or account, normalized to the names used in the knowledge base metadata
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS bank VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Speeds up metadata filters of knowledge base routing (metadata @> '{"bank": "..."}')
+CREATE INDEX IF NOT EXISTS idx_knowledge_base_metadata ON knowledge_base USING gin(metadata jsonb_path_ops);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_knowledge_base_metadata;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS bank;
+
+UPDATE transactions SET category = 'other' WHERE category = 'fees';
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_category_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_category_check
+    CHECK (category IN ('food', 'transport', 'utilities', 'shopping', 'entertainment', 'healthcare', 'education', 'other'));
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Knowledge base entries a recommendation was based on. number is the [n] the model cited.
+-- Title, type, source file and pages are copied, so a citation survives re-seeding of the knowledge base.
+CREATE TABLE IF NOT EXISTS recommendation_citations (
+    recommendation_id UUID NOT NULL REFERENCES recommendations(id) ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    knowledge_id UUID REFERENCES knowledge_base(id) ON DELETE SET NULL,
+    type VARCHAR(20) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    source_file VARCHAR(500) NOT NULL DEFAULT '',
+    page_start INTEGER NOT NULL DEFAULT 0,
+    page_end INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (recommendation_id, number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recommendation_citations_knowledge_id ON recommendation_citations(knowledge_id);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recommendation_citations;
+-- +goose StatementEnd
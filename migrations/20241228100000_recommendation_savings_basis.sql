@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Recommendations are generated as JSON: savings come with the calculation they are based on
+-- and the model's confidence in the recommendation (0..1)
+ALTER TABLE recommendations ADD COLUMN IF NOT EXISTS savings_basis TEXT NOT NULL DEFAULT '';
+ALTER TABLE recommendations ADD COLUMN IF NOT EXISTS confidence REAL NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE recommendations DROP COLUMN IF EXISTS confidence;
+ALTER TABLE recommendations DROP COLUMN IF EXISTS savings_basis;
+-- +goose StatementEnd
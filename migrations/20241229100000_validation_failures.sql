@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Model answers rejected by JSON decoding or validation while processing a document.
+-- Every rejected attempt is stored, including the ones fixed by a later repair request.
+CREATE TABLE IF NOT EXISTS validation_failures (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    stage VARCHAR(20) NOT NULL,
+    attempt INTEGER NOT NULL,
+    errors JSONB NOT NULL DEFAULT '[]',
+    response TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_validation_failures_document_id ON validation_failures(document_id);
+CREATE INDEX IF NOT EXISTS idx_validation_failures_created_at ON validation_failures(stage, created_at);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS validation_failures;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A recommendation generated for a document may cover several transactions.
+-- recommendations.transaction_id keeps the main (largest) of them.
+CREATE TABLE IF NOT EXISTS recommendation_transactions (
+    recommendation_id UUID NOT NULL REFERENCES recommendations(id) ON DELETE CASCADE,
+    transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    PRIMARY KEY (recommendation_id, transaction_id)
+);
+
+CREATE INDEX idx_recommendation_transactions_transaction_id ON recommendation_transactions(transaction_id);
+
+INSERT INTO recommendation_transactions (recommendation_id, transaction_id)
+SELECT id, transaction_id FROM recommendations
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recommendation_transactions;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Line items of a receipt: products with quantity, price, VAT rate and their own category
+CREATE TABLE IF NOT EXISTS transaction_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    name TEXT NOT NULL,
+    quantity DECIMAL(15, 3) NOT NULL DEFAULT 1,
+    unit_price DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    total DECIMAL(15, 2) NOT NULL,
+    vat_rate VARCHAR(10) NOT NULL DEFAULT '' CHECK (vat_rate IN ('', '20%', '18%', '10%', '0%', 'none')),
+    category VARCHAR(50) NOT NULL CHECK (category IN ('food', 'transport', 'utilities', 'shopping', 'entertainment', 'healthcare', 'education', 'fees', 'other')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (transaction_id, position)
+);
+
+CREATE INDEX idx_transaction_items_category ON transaction_items(category);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS transaction_items;
+-- +goose StatementEnd
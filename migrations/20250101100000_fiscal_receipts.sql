@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Fiscal data decoded from the QR code of a receipt. The QR values are authoritative
+-- for the date and total of the transaction; fn + i + fp identify the receipt at the tax service.
+CREATE TABLE IF NOT EXISTS fiscal_receipts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    fn VARCHAR(32) NOT NULL,
+    fd VARCHAR(32) NOT NULL,
+    fp VARCHAR(32) NOT NULL,
+    operation_type SMALLINT NOT NULL CHECK (operation_type BETWEEN 1 AND 4),
+    total DECIMAL(15, 2) NOT NULL,
+    issued_at TIMESTAMP NOT NULL,
+    raw TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- A receipt is stored once per user, however many times it is uploaded
+CREATE UNIQUE INDEX idx_fiscal_receipts_dedup ON fiscal_receipts(user_id, fn, fd, fp);
+CREATE INDEX idx_fiscal_receipts_document_id ON fiscal_receipts(document_id);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS fiscal_receipts;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Key of the file in the document storage (local directory or S3 bucket). New files get
+-- content-addressed keys; files uploaded before are keyed by their name in the uploads directory.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS storage_key VARCHAR(255);
+UPDATE documents SET storage_key = regexp_replace(file_url, '^.*/', '') WHERE storage_key IS NULL;
+ALTER TABLE documents ALTER COLUMN storage_key SET NOT NULL;
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE documents DROP COLUMN IF EXISTS storage_key;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Files are no longer served from the public /uploads route, only to their owner
+UPDATE documents SET file_url = '/api/v1/documents/' || id || '/file' WHERE file_url LIKE '/uploads/%';
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE documents SET file_url = '/uploads/' || storage_key WHERE file_url LIKE '/api/v1/documents/%';
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- SHA-256 of the uploaded file: a file the user has already uploaded returns the existing document.
+-- Documents stored under content-addressed keys get their hash from the key; if the user uploaded
+-- the same file several times, only the first document is kept as the original.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+
+UPDATE documents SET content_hash = substring(storage_key FROM '^documents/[0-9a-f]{2}/([0-9a-f]{64})')
+WHERE id IN (
+    SELECT DISTINCT ON (user_id, substring(storage_key FROM '^documents/[0-9a-f]{2}/([0-9a-f]{64})')) id
+    FROM documents
+    WHERE storage_key ~ '^documents/[0-9a-f]{2}/[0-9a-f]{64}'
+    ORDER BY user_id, substring(storage_key FROM '^documents/[0-9a-f]{2}/([0-9a-f]{64})'), created_at
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_documents_content_hash ON documents(user_id, content_hash);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_documents_content_hash;
+ALTER TABLE documents DROP COLUMN IF EXISTS content_hash;
+-- +goose StatementEnd
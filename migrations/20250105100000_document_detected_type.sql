@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The type chosen by the user becomes optional: the type is detected after OCR
+-- and stored with the confidence of the detection.
+ALTER TABLE documents ALTER COLUMN type DROP NOT NULL;
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS detected_type VARCHAR(20)
+    CHECK (detected_type IN ('receipt', 'statement', 'screenshot'));
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS type_confidence DOUBLE PRECISION
+    CHECK (type_confidence BETWEEN 0 AND 1);
+-- +goose StatementEnd
+
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE documents SET type = COALESCE(detected_type, 'screenshot') WHERE type IS NULL;
+ALTER TABLE documents ALTER COLUMN type SET NOT NULL;
+
+ALTER TABLE documents DROP COLUMN IF EXISTS type_confidence;
+ALTER TABLE documents DROP COLUMN IF EXISTS detected_type;
+-- +goose StatementEnd
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const signedPath = "/api/v1/documents/0b6c3c56-1f7a-4a8e-9d55-1d3f5a3e2b10/file"
+
+// signParams signs the path and returns the query parameters of the signed URL
+func signParams(t *testing.T, signer *URLSigner, path string) (expires, signature string) {
+	t.Helper()
+
+	signed, _ := signer.Sign(path)
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("signed URL %q: %v", signed, err)
+	}
+	if u.Path != path {
+		t.Fatalf("signed URL path = %q, want %q", u.Path, path)
+	}
+	return u.Query().Get("expires"), u.Query().Get("signature")
+}
+
+func TestURLSignerVerify(t *testing.T) {
+	signer := NewURLSigner("secret", time.Minute)
+	expires, signature := signParams(t, signer, signedPath)
+
+	if err := signer.Verify(signedPath, expires, signature); err != nil {
+		t.Fatalf("Verify of a fresh URL: %v", err)
+	}
+
+	unix, _ := strconv.ParseInt(expires, 10, 64)
+	later := strconv.FormatInt(unix+3600, 10)
+	decoded, _ := base64.RawURLEncoding.DecodeString(signature)
+	flipped := append([]byte(nil), decoded...)
+	flipped[0] ^= 1
+	_, otherKey := signParams(t, NewURLSigner("another secret", time.Minute), signedPath)
+
+	tests := []struct {
+		name      string
+		path      string
+		expires   string
+		signature string
+	}{
+		{"tampered path", strings.Replace(signedPath, "0b6c", "0b6d", 1), expires, signature},
+		{"path of another document's file", "/api/v1/documents/other/file", expires, signature},
+		{"extended expires", signedPath, later, signature},
+		{"expires with a leading zero", signedPath, "0" + expires, signature},
+		{"non-numeric expires", signedPath, "never", signature},
+		{"flipped signature bit", signedPath, expires, base64.RawURLEncoding.EncodeToString(flipped)},
+		{"truncated signature", signedPath, expires, signature[:len(signature)-2]},
+		{"padded signature", signedPath, expires, signature + "="},
+		{"signature of another key", signedPath, expires, otherKey},
+		{"empty signature", signedPath, expires, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.Verify(tt.path, tt.expires, tt.signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("got %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestURLSignerExpiry(t *testing.T) {
+	signer := NewURLSigner("secret", -time.Second)
+	expires, signature := signParams(t, signer, signedPath)
+
+	// The signature is valid, but the URL is no longer accepted
+	if err := signer.Verify(signedPath, expires, signature); !errors.Is(err, ErrExpiredURL) {
+		t.Errorf("got %v, want ErrExpiredURL", err)
+	}
+
+	// An expired URL with a forged signature reports the signature, not the expiry
+	if err := signer.Verify(signedPath, expires, "AAAA"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("got %v for a forged expired URL, want ErrInvalidSignature", err)
+	}
+}
+
+func TestURLSignerExpiresAt(t *testing.T) {
+	ttl := 10 * time.Minute
+	before := time.Now()
+	signed, expiresAt := NewURLSigner("secret", ttl).Sign(signedPath)
+
+	if expiresAt.Before(before.Add(ttl).Truncate(time.Second)) || expiresAt.After(time.Now().Add(ttl)) {
+		t.Errorf("expiresAt = %v, want about %v from now", expiresAt, ttl)
+	}
+	if !strings.Contains(signed, "expires="+strconv.FormatInt(expiresAt.Unix(), 10)) {
+		t.Errorf("signed URL %q does not carry the expiration time %d", signed, expiresAt.Unix())
+	}
+}
@@ -0,0 +1,408 @@
+// Package chunker splits knowledge base sources into retrieval-sized chunks.
+//
+// Text is split into sections at headings and into paragraphs at blank lines.
+// Paragraphs are packed into chunks of at most MaxTokens; consecutive chunks of
+// one section share up to OverlapTokens of trailing paragraphs, and every chunk
+// starts with its section heading so it can be understood on its own.
+package chunker
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Page is the text of one page of a source document
+type Page struct {
+	Number int // 1-based; 0 if the page is unknown
+	Text   string
+}
+
+// Chunk is a piece of a source document
+type Chunk struct {
+	Index      int
+	Heading    string
+	Text       string
+	PageStart  int
+	PageEnd    int
+	TokenCount int
+}
+
+type Options struct {
+	MaxTokens     int
+	OverlapTokens int
+}
+
+const (
+	defaultMaxTokens = 400
+
+	// charsPerToken is a conservative estimate for Russian text;
+	// GigaChat and most BPE tokenizers produce 3-4 characters per token
+	charsPerToken = 3
+
+	maxHeadingRunes = 120
+)
+
+var (
+	numberedHeadingRe = regexp.MustCompile(`^(\d+(\.\d+)*\.?|[IVXLC]+\.)\s+\S`)
+	sentenceEndRe     = regexp.MustCompile(`([.!?;])\s+`)
+
+	// \b of Go regexps knows only ASCII word characters, so the end of a keyword is matched explicitly
+	keywordHeadingRe = regexp.MustCompile(`(?i)^(раздел|глава|часть|статья|приложение|тарифы?|section|chapter)(?:[^\p{L}\p{N}]|$)`)
+)
+
+// EstimateTokens approximates the number of model tokens in text
+func EstimateTokens(text string) int {
+	n := utf8.RuneCountInString(strings.TrimSpace(text))
+	if n == 0 {
+		return 0
+	}
+	return (n + charsPerToken - 1) / charsPerToken
+}
+
+type paragraph struct {
+	text   string
+	page   int
+	tokens int
+}
+
+type section struct {
+	heading    string
+	page       int
+	paragraphs []paragraph
+}
+
+// Split splits pages into chunks
+func Split(pages []Page, opts Options) []Chunk {
+	if opts.MaxTokens <= 0 {
+		opts.MaxTokens = defaultMaxTokens
+	}
+	if opts.OverlapTokens < 0 || opts.OverlapTokens >= opts.MaxTokens {
+		opts.OverlapTokens = 0
+	}
+
+	var chunks []Chunk
+	for _, sec := range splitSections(pages, opts.MaxTokens) {
+		chunks = append(chunks, packSection(sec, opts)...)
+	}
+
+	chunks = mergeSmall(chunks, opts.MaxTokens)
+	for i := range chunks {
+		chunks[i].Index = i
+	}
+	return chunks
+}
+
+// splitSections groups paragraphs under the closest preceding heading
+func splitSections(pages []Page, maxTokens int) []section {
+	var sections []section
+	current := section{}
+
+	for _, page := range pages {
+		for _, block := range splitBlocks(page.Text) {
+			lines := strings.Split(block, "\n")
+
+			// A heading may be the first line of a block without a blank line after it
+			if isHeading(lines[0]) {
+				if len(current.paragraphs) > 0 || current.heading != "" {
+					sections = append(sections, current)
+				}
+				current = section{heading: cleanHeading(lines[0]), page: page.Number}
+				lines = lines[1:]
+			}
+
+			text := strings.TrimSpace(strings.Join(lines, "\n"))
+			if text == "" {
+				continue
+			}
+			if current.page == 0 {
+				current.page = page.Number
+			}
+			for _, part := range splitLong(text, contentBudget(current.heading, maxTokens)) {
+				current.paragraphs = append(current.paragraphs, paragraph{
+					text:   part,
+					page:   page.Number,
+					tokens: EstimateTokens(part),
+				})
+			}
+		}
+	}
+
+	if len(current.paragraphs) > 0 || current.heading != "" {
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+// packSection packs paragraphs of one section into chunks with overlap
+func packSection(sec section, opts Options) []Chunk {
+	budget := contentBudget(sec.heading, opts.MaxTokens)
+
+	if len(sec.paragraphs) == 0 {
+		return []Chunk{newChunk(sec.heading, nil, sec.page)}
+	}
+
+	var chunks []Chunk
+	var window []paragraph
+	windowTokens := 0
+	fresh := 0 // paragraphs in window not yet emitted
+
+	for _, p := range sec.paragraphs {
+		if windowTokens+p.tokens > budget && fresh > 0 {
+			chunks = append(chunks, newChunk(sec.heading, window, sec.page))
+			window, windowTokens = overlapTail(window, opts.OverlapTokens, budget-p.tokens)
+			fresh = 0
+		}
+		window = append(window, p)
+		windowTokens += p.tokens
+		fresh++
+	}
+	if fresh > 0 {
+		chunks = append(chunks, newChunk(sec.heading, window, sec.page))
+	}
+
+	return chunks
+}
+
+// contentBudget is the number of tokens left for paragraphs in a chunk that starts with heading
+func contentBudget(heading string, maxTokens int) int {
+	budget := maxTokens - EstimateTokens(heading) - 1
+	if budget < maxTokens/2 {
+		// Overlong heading: do not let it starve the content
+		budget = maxTokens / 2
+	}
+	return budget
+}
+
+// overlapTail returns the trailing paragraphs that fit into the overlap and the remaining budget
+func overlapTail(window []paragraph, overlapTokens, room int) ([]paragraph, int) {
+	limit := overlapTokens
+	if room < limit {
+		limit = room
+	}
+
+	tokens := 0
+	start := len(window)
+	for start > 0 && tokens+window[start-1].tokens <= limit {
+		start--
+		tokens += window[start].tokens
+	}
+
+	if start == len(window) && start > 0 && limit > 0 {
+		// The last paragraph is longer than the overlap: carry over its trailing sentences
+		last := window[start-1]
+		if tail := tailSentences(last.text, limit); tail != "" {
+			p := paragraph{text: tail, page: last.page, tokens: EstimateTokens(tail)}
+			return []paragraph{p}, p.tokens
+		}
+	}
+
+	return append([]paragraph(nil), window[start:]...), tokens
+}
+
+// tailSentences returns the longest run of trailing sentences of text within maxTokens
+func tailSentences(text string, maxTokens int) string {
+	sentences := strings.Split(sentenceEndRe.ReplaceAllString(text, "$1\n"), "\n")
+
+	tail := ""
+	for i := len(sentences) - 1; i >= 0; i-- {
+		s := strings.TrimSpace(sentences[i])
+		if s == "" {
+			continue
+		}
+		candidate := s
+		if tail != "" {
+			candidate = s + " " + tail
+		}
+		if EstimateTokens(candidate) > maxTokens {
+			break
+		}
+		tail = candidate
+	}
+	return tail
+}
+
+func newChunk(heading string, paragraphs []paragraph, sectionPage int) Chunk {
+	parts := make([]string, 0, len(paragraphs)+1)
+	if heading != "" {
+		parts = append(parts, heading)
+	}
+
+	pageStart, pageEnd := sectionPage, sectionPage
+	for i, p := range paragraphs {
+		parts = append(parts, p.text)
+		if i == 0 || (p.page != 0 && p.page < pageStart) {
+			pageStart = p.page
+		}
+		if p.page > pageEnd {
+			pageEnd = p.page
+		}
+	}
+	if pageStart == 0 {
+		pageStart = pageEnd
+	}
+
+	text := strings.Join(parts, "\n\n")
+	return Chunk{
+		Heading:    heading,
+		Text:       text,
+		PageStart:  pageStart,
+		PageEnd:    pageEnd,
+		TokenCount: EstimateTokens(text),
+	}
+}
+
+// mergeSmall joins neighbouring chunks that together still fit into maxTokens,
+// e.g. a run of short sections under their own headings
+func mergeSmall(chunks []Chunk, maxTokens int) []Chunk {
+	if len(chunks) < 2 {
+		return chunks
+	}
+
+	merged := []Chunk{chunks[0]}
+	for _, c := range chunks[1:] {
+		last := &merged[len(merged)-1]
+		if last.TokenCount < maxTokens/4 && last.TokenCount+c.TokenCount <= maxTokens {
+			last.Text += "\n\n" + c.Text
+			last.TokenCount = EstimateTokens(last.Text)
+			if c.PageEnd > last.PageEnd {
+				last.PageEnd = c.PageEnd
+			}
+			if last.Heading == "" {
+				last.Heading = c.Heading
+			}
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// splitBlocks splits text into paragraphs separated by blank lines
+func splitBlocks(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var blocks []string
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			blocks = append(blocks, strings.Join(current, "\n"))
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return blocks
+}
+
+// splitLong splits a paragraph longer than maxTokens by sentences, then by words
+func splitLong(text string, maxTokens int) []string {
+	if EstimateTokens(text) <= maxTokens {
+		return []string{text}
+	}
+
+	sentences := sentenceEndRe.ReplaceAllString(text, "$1\n")
+	var units []string
+	for _, s := range strings.Split(sentences, "\n") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if EstimateTokens(s) <= maxTokens {
+			units = append(units, s)
+			continue
+		}
+		units = append(units, splitWords(s, maxTokens)...)
+	}
+
+	var parts []string
+	var current strings.Builder
+	for _, u := range units {
+		if current.Len() > 0 && EstimateTokens(current.String()+" "+u) > maxTokens {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString(" ")
+		}
+		current.WriteString(u)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func splitWords(text string, maxTokens int) []string {
+	maxRunes := maxTokens * charsPerToken
+
+	var parts []string
+	var current []rune
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		for len(w) > maxRunes {
+			// A "word" longer than a chunk (e.g. a table row without spaces)
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+				current = nil
+			}
+			parts = append(parts, string(w[:maxRunes]))
+			w = w[maxRunes:]
+		}
+		if len(current) > 0 && len(current)+1+len(w) > maxRunes {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, w...)
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return parts
+}
+
+// isHeading reports whether a line looks like a section heading:
+// a short line without a terminal period that is numbered, starts with a
+// heading keyword, is a markdown heading or is written in capitals
+func isHeading(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || utf8.RuneCountInString(line) > maxHeadingRunes {
+		return false
+	}
+	if strings.HasPrefix(line, "#") {
+		return true
+	}
+	if strings.HasSuffix(line, ".") || strings.HasSuffix(line, ",") || strings.HasSuffix(line, ";") {
+		return false
+	}
+	if numberedHeadingRe.MatchString(line) || keywordHeadingRe.MatchString(line) {
+		return true
+	}
+
+	letters, upper := 0, 0
+	for _, r := range line {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= 4 && upper == letters
+}
+
+func cleanHeading(line string) string {
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "# "))
+}
@@ -0,0 +1,166 @@
+package chunker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsHeading(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"Раздел 1 Общие положения", true},
+		{"РАЗДЕЛ 2. Порядок расчётов", true},
+		{"Глава 2", true},
+		{"глава 3: Ответственность сторон", true},
+		{"Статья 5", true},
+		{"Приложение № 1", true},
+		{"Тариф «Базовый»", true},
+		{"Тарифы банка", true},
+		{"Section 3", true},
+		{"Chapter 1 Introduction", true},
+		{"1. Общие положения", true},
+		{"2.3 Комиссии за переводы", true},
+		{"IV. Заключительные положения", true},
+		{"# Кешбэк", true},
+		{"ОБЩИЕ УСЛОВИЯ", true},
+
+		// Keywords only count as whole words
+		{"Разделение счетов между супругами", false},
+		{"Главная страница приложения", false},
+		{"Тарификация звонков", false},
+		{"Частичное досрочное погашение", false},
+		{"Sectional view", false},
+
+		// Sentences and overlong lines are not headings
+		{"Раздел 1 содержит общие положения.", false},
+		{"Обычный абзац текста", false},
+		{"", false},
+		{"Раздел " + strings.Repeat("а", maxHeadingRunes), false},
+	}
+
+	for _, tt := range tests {
+		if got := isHeading(tt.line); got != tt.want {
+			t.Errorf("isHeading(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSplitSectionsAtCyrillicHeadings(t *testing.T) {
+	text := "Раздел 1 Общие положения\n" + paragraphOf("Условия обслуживания карт.", 15) +
+		"\n\nГлава 2 Комиссии\n" + paragraphOf("Комиссия за перевод составляет один процент.", 15) +
+		"\n\n3. Кешбэк\n" + paragraphOf("Кешбэк начисляется раз в месяц.", 15)
+
+	chunks := Split([]Page{{Number: 1, Text: text}}, Options{MaxTokens: 400})
+
+	want := []string{"Раздел 1 Общие положения", "Глава 2 Комиссии", "3. Кешбэк"}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i, heading := range want {
+		if chunks[i].Heading != heading {
+			t.Errorf("chunk %d heading = %q, want %q", i, chunks[i].Heading, heading)
+		}
+		if !strings.HasPrefix(chunks[i].Text, heading+"\n\n") {
+			t.Errorf("chunk %d does not start with its heading", i)
+		}
+	}
+}
+
+func TestSplitSizeLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		opts Options
+	}{
+		{
+			name: "many paragraphs",
+			text: "Глава 1 Тарифы\n" + strings.Repeat(paragraphOf("Плата за обслуживание списывается ежемесячно.", 5)+"\n\n", 40),
+			opts: Options{MaxTokens: 200, OverlapTokens: 40},
+		},
+		{
+			name: "one long paragraph",
+			text: "Статья 7\n" + paragraphOf("Банк вправе изменить тарифы, уведомив клиента за 30 дней.", 100),
+			opts: Options{MaxTokens: 150, OverlapTokens: 30},
+		},
+		{
+			name: "word longer than a chunk",
+			text: strings.Repeat("я", 2000),
+			opts: Options{MaxTokens: 100},
+		},
+		{
+			name: "default options",
+			text: strings.Repeat(paragraphOf("Проценты на остаток начисляются ежедневно.", 8)+"\n\n", 30),
+			opts: Options{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := Split([]Page{{Number: 1, Text: tt.text}}, tt.opts)
+			if len(chunks) < 2 {
+				t.Fatalf("got %d chunks, want the text split", len(chunks))
+			}
+
+			maxTokens := tt.opts.MaxTokens
+			if maxTokens == 0 {
+				maxTokens = defaultMaxTokens
+			}
+			for i, c := range chunks {
+				if c.Index != i {
+					t.Errorf("chunk %d has index %d", i, c.Index)
+				}
+				if c.TokenCount > maxTokens {
+					t.Errorf("chunk %d has %d tokens, limit %d", i, c.TokenCount, maxTokens)
+				}
+				if c.TokenCount != EstimateTokens(c.Text) {
+					t.Errorf("chunk %d token count %d does not match its text", i, c.TokenCount)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitOverlap(t *testing.T) {
+	var paragraphs []string
+	for i := 0; i < 20; i++ {
+		paragraphs = append(paragraphs, paragraphOf("Абзац условий тарифа.", 4))
+	}
+	chunks := Split([]Page{{Number: 1, Text: strings.Join(paragraphs, "\n\n")}}, Options{MaxTokens: 100, OverlapTokens: 30})
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+
+	// The last paragraph of a chunk is repeated at the start of the next one
+	for i := 1; i < len(chunks); i++ {
+		prev := strings.Split(chunks[i-1].Text, "\n\n")
+		if !strings.HasPrefix(chunks[i].Text, prev[len(prev)-1]) {
+			t.Errorf("chunk %d does not start with the overlap of chunk %d", i, i-1)
+		}
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	pages := []Page{
+		{Number: 1, Text: "Раздел 1 Общие положения\n" + paragraphOf("Текст первой страницы.", 15)},
+		{Number: 2, Text: paragraphOf("Текст второй страницы.", 15)},
+		{Number: 3, Text: "Раздел 2 Тарифы\n" + paragraphOf("Текст третьей страницы.", 15)},
+	}
+
+	chunks := Split(pages, Options{MaxTokens: 400})
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(chunks))
+	}
+	if chunks[0].PageStart != 1 || chunks[0].PageEnd != 2 {
+		t.Errorf("first chunk pages = %d-%d, want 1-2", chunks[0].PageStart, chunks[0].PageEnd)
+	}
+	if chunks[1].PageStart != 3 || chunks[1].PageEnd != 3 {
+		t.Errorf("second chunk pages = %d-%d, want 3-3", chunks[1].PageStart, chunks[1].PageEnd)
+	}
+}
+
+// paragraphOf repeats a sentence n times as one paragraph
+func paragraphOf(sentence string, n int) string {
+	return strings.TrimSpace(strings.Repeat(sentence+" ", n))
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -9,13 +11,20 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	GigaChat GigaChatConfig
-	OCR      OCRConfig
-	RAG      RAGConfig
-	Logger   LoggerConfig
+	Server          ServerConfig
+	Database        DatabaseConfig
+	JWT             JWTConfig
+	LLM             LLMConfig
+	GigaChat        GigaChatConfig
+	OpenAI          OpenAIConfig
+	OCR             OCRConfig
+	RAG             RAGConfig
+	Recommendations RecommendationsConfig
+	Import          ImportConfig
+	Storage         StorageConfig
+	Upload          UploadConfig
+	Jobs            JobsConfig
+	Logger          LoggerConfig
 }
 
 type LoggerConfig struct {
@@ -43,20 +52,102 @@ type JWTConfig struct {
 	RefreshExp time.Duration
 }
 
+type LLMConfig struct {
+	Provider string // gigachat or openai
+}
+
 type GigaChatConfig struct {
 	APIKey             string
 	Scope              string
+	BaseURL            string // REST API base, e.g. https://gigachat.devices.sberbank.ru/api/v1
+	AuthURL            string // OAuth token endpoint
 	InsecureSkipVerify bool
 }
 
+// OpenAIConfig configures an OpenAI-compatible server (llama.cpp, Ollama, vLLM)
+type OpenAIConfig struct {
+	BaseURL     string
+	APIKey      string
+	Model       string
+	VisionModel string
+	Timeout     time.Duration
+}
+
 type OCRConfig struct {
-	Provider string // Deprecated: now using GigaChat Vision API
-	APIKey   string // Deprecated: now using GigaChat Vision API
+	Provider           string  // gigachat (vision model of the LLM provider), tesseract, or best: run both and keep the more confident result
+	APIKey             string  // Deprecated: not used by any OCR engine
+	TesseractPath      string  // tesseract binary
+	TesseractLanguages string  // tesseract languages, e.g. rus+eng
+	VisionConfidence   float64 // confidence given to vision model results, which report none; in best mode Tesseract wins above it
+	HEICConverter      string  // command converting HEIC photos to PNG: heif-convert (libheif) or magick (ImageMagick)
 }
 
 type RAGConfig struct {
-	EmbeddingModel string
-	TopK           int
+	EmbeddingModel string  // must return 1024-dimensional vectors (knowledge_base.embedding), checked at startup
+	TopK           int     // entries passed to the prompt after rank fusion
+	Candidates     int     // entries taken from each of vector and full-text search
+	VectorWeight   float64 // weight of the vector ranking in reciprocal rank fusion
+	TextWeight     float64 // weight of the full-text ranking in reciprocal rank fusion
+	RRFK           int     // rank fusion constant; larger values flatten the difference between top ranks
+	ChunkTokens    int     // max size of a knowledge base chunk
+	ChunkOverlap   int     // tokens shared by neighbouring chunks of one section
+
+	// Routes overrides the built-in routing of transaction categories to the knowledge base,
+	// keyed by category; categories missing here keep the built-in routes
+	Routes map[string][]KnowledgeRoute
+}
+
+// KnowledgeRoute directs knowledge search for a transaction category to a part of the knowledge base
+type KnowledgeRoute struct {
+	Type     string            `json:"type"`      // knowledge type; empty matches all types
+	Metadata map[string]string `json:"metadata"`  // required values of the source metadata, e.g. {"category": "benefits"}
+	UserBank bool              `json:"user_bank"` // restrict to the bank of the transaction when it is known
+	Weight   float64           `json:"weight"`    // weight of the route in rank fusion
+}
+
+// RecommendationsConfig configures recommendation generation for processed documents
+type RecommendationsConfig struct {
+	Mode              string // document: one request per document for groups of similar transactions; transaction: one request per transaction
+	MaxGroups         int    // groups of a document that get their own knowledge search; smaller groups share the context
+	GroupContext      int    // knowledge base entries taken for the prompt from the search of one group
+	GroupTransactions int    // transactions of a group listed in the prompt; the rest are summarized
+}
+
+// ImportConfig configures the import of statement files (CSV, OFX, QIF, 1C)
+type ImportConfig struct {
+	CSVProfilesFile string // JSON array of CSV column mappings tried before the built-in Sber, Tinkoff and Alfa profiles
+}
+
+// StorageConfig configures where uploaded documents are stored
+type StorageConfig struct {
+	Backend     string // local or s3; replicas of the server must share an s3 bucket
+	LocalDir    string // directory of the local backend
+	S3Endpoint  string // host:port of the S3-compatible service, e.g. localhost:9000 for MinIO
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+
+	SignedURLKey string        // HMAC key of signed file URLs for the web UI; empty disables them
+	SignedURLTTL time.Duration // lifetime of a signed file URL
+}
+
+// UploadConfig limits uploaded documents
+type UploadConfig struct {
+	MaxFileSize    int64 // bytes
+	MaxPages       int   // pages of a PDF or multi-page TIFF
+	MaxRequestSize int64 // bytes of an upload request; a batch upload sends many files at once
+	MaxBatchFiles  int   // files of a batch upload, counting the files inside ZIP archives
+	MaxArchiveSize int64 // total unpacked bytes of the files of a ZIP archive
+}
+
+// JobsConfig configures the background document processing worker pool
+type JobsConfig struct {
+	Workers      int
+	QueueSize    int
+	Timeout      time.Duration // max duration of one job; running jobs silent for longer are re-queued. 0 disables both
+	PollInterval time.Duration // how often pending jobs are picked up from the database
 }
 
 func Load() (*Config, error) {
@@ -81,7 +172,33 @@ func Load() (*Config, error) {
 	jwtExp, _ := strconv.Atoi(getEnv("JWT_EXPIRATION_HOURS", "24"))
 	refreshExp, _ := strconv.Atoi(getEnv("JWT_REFRESH_EXPIRATION_HOURS", "168"))
 	ragTopK, _ := strconv.Atoi(getEnv("RAG_TOP_K", "5"))
+	ragCandidates, _ := strconv.Atoi(getEnv("RAG_CANDIDATES", "20"))
+	ragVectorWeight, _ := strconv.ParseFloat(getEnv("RAG_VECTOR_WEIGHT", "1.0"), 64)
+	ragTextWeight, _ := strconv.ParseFloat(getEnv("RAG_TEXT_WEIGHT", "1.0"), 64)
+	ragRRFK, _ := strconv.Atoi(getEnv("RAG_RRF_K", "60"))
+	ragRoutes, err := loadKnowledgeRoutes(getEnv("RAG_ROUTES_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+	ragChunkTokens, _ := strconv.Atoi(getEnv("RAG_CHUNK_TOKENS", "400"))
+	ragChunkOverlap, _ := strconv.Atoi(getEnv("RAG_CHUNK_OVERLAP", "50"))
+	ocrVisionConfidence, _ := strconv.ParseFloat(getEnv("OCR_VISION_CONFIDENCE", "0.85"), 64)
 	insecureSkipVerify := getEnv("GIGACHAT_INSECURE_SKIP_VERIFY", "true") == "true"
+	openAITimeout, _ := strconv.Atoi(getEnv("OPENAI_TIMEOUT", "120"))
+	recMaxGroups, _ := strconv.Atoi(getEnv("RECOMMENDATIONS_MAX_GROUPS", "8"))
+	recGroupContext, _ := strconv.Atoi(getEnv("RECOMMENDATIONS_GROUP_CONTEXT", "3"))
+	recGroupTransactions, _ := strconv.Atoi(getEnv("RECOMMENDATIONS_GROUP_TRANSACTIONS", "10"))
+	s3UseSSL := getEnv("STORAGE_S3_USE_SSL", "false") == "true"
+	signedURLTTL, _ := strconv.Atoi(getEnv("STORAGE_SIGNED_URL_TTL", "300"))
+	uploadMaxFileSize, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_FILE_SIZE_MB", "20"), 10, 64)
+	uploadMaxPages, _ := strconv.Atoi(getEnv("UPLOAD_MAX_PAGES", "50"))
+	uploadMaxRequestSize, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_REQUEST_SIZE_MB", "100"), 10, 64)
+	uploadMaxBatchFiles, _ := strconv.Atoi(getEnv("UPLOAD_MAX_BATCH_FILES", "50"))
+	uploadMaxArchiveSize, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_ARCHIVE_SIZE_MB", "200"), 10, 64)
+	jobWorkers, _ := strconv.Atoi(getEnv("JOBS_WORKERS", "2"))
+	jobQueueSize, _ := strconv.Atoi(getEnv("JOBS_QUEUE_SIZE", "100"))
+	jobTimeout, _ := strconv.Atoi(getEnv("JOBS_TIMEOUT", "900"))
+	jobPollInterval, _ := strconv.Atoi(getEnv("JOBS_POLL_INTERVAL", "10"))
 
 	return &Config{
 		Server: ServerConfig{
@@ -102,18 +219,76 @@ func Load() (*Config, error) {
 			Expiration: time.Duration(jwtExp) * time.Hour,
 			RefreshExp: time.Duration(refreshExp) * time.Hour,
 		},
+		LLM: LLMConfig{
+			Provider: getEnv("LLM_PROVIDER", "gigachat"),
+		},
 		GigaChat: GigaChatConfig{
 			APIKey:             getEnv("GIGACHAT_API_KEY", ""),
 			Scope:              getEnv("GIGACHAT_SCOPE", "GIGACHAT_API_PERS"),
+			BaseURL:            getEnv("GIGACHAT_BASE_URL", "https://gigachat.devices.sberbank.ru/api/v1"),
+			AuthURL:            getEnv("GIGACHAT_AUTH_URL", "https://ngw.devices.sberbank.ru:9443/api/v2/oauth"),
 			InsecureSkipVerify: insecureSkipVerify,
 		},
+		OpenAI: OpenAIConfig{
+			BaseURL:     getEnv("OPENAI_BASE_URL", "http://localhost:8081/v1"),
+			APIKey:      getEnv("OPENAI_API_KEY", ""),
+			Model:       getEnv("OPENAI_MODEL", ""),
+			VisionModel: getEnv("OPENAI_VISION_MODEL", ""),
+			Timeout:     time.Duration(openAITimeout) * time.Second,
+		},
 		OCR: OCRConfig{
-			Provider: getEnv("OCR_PROVIDER", "tesseract"),
-			APIKey:   getEnv("OCR_API_KEY", ""),
+			Provider:           getEnv("OCR_PROVIDER", "gigachat"),
+			APIKey:             getEnv("OCR_API_KEY", ""),
+			TesseractPath:      getEnv("TESSERACT_PATH", "tesseract"),
+			TesseractLanguages: getEnv("TESSERACT_LANGUAGES", "rus+eng"),
+			VisionConfidence:   ocrVisionConfidence,
+			HEICConverter:      getEnv("HEIC_CONVERTER", "heif-convert"),
 		},
 		RAG: RAGConfig{
-			EmbeddingModel: getEnv("RAG_EMBEDDING_MODEL", "text-embedding-ada-002"),
+			EmbeddingModel: getEnv("RAG_EMBEDDING_MODEL", "Embeddings"),
 			TopK:           ragTopK,
+			Candidates:     ragCandidates,
+			VectorWeight:   ragVectorWeight,
+			TextWeight:     ragTextWeight,
+			RRFK:           ragRRFK,
+			ChunkTokens:    ragChunkTokens,
+			ChunkOverlap:   ragChunkOverlap,
+			Routes:         ragRoutes,
+		},
+		Recommendations: RecommendationsConfig{
+			Mode:              getEnv("RECOMMENDATIONS_MODE", "document"),
+			MaxGroups:         recMaxGroups,
+			GroupContext:      recGroupContext,
+			GroupTransactions: recGroupTransactions,
+		},
+		Import: ImportConfig{
+			CSVProfilesFile: getEnv("IMPORT_CSV_PROFILES_FILE", ""),
+		},
+		Storage: StorageConfig{
+			Backend:     getEnv("STORAGE_BACKEND", "local"),
+			LocalDir:    getEnv("STORAGE_LOCAL_DIR", "uploads"),
+			S3Endpoint:  getEnv("STORAGE_S3_ENDPOINT", "localhost:9000"),
+			S3Region:    getEnv("STORAGE_S3_REGION", "us-east-1"),
+			S3Bucket:    getEnv("STORAGE_S3_BUCKET", "documents"),
+			S3AccessKey: getEnv("STORAGE_S3_ACCESS_KEY", ""),
+			S3SecretKey: getEnv("STORAGE_S3_SECRET_KEY", ""),
+			S3UseSSL:    s3UseSSL,
+
+			SignedURLKey: getEnv("STORAGE_SIGNED_URL_KEY", ""),
+			SignedURLTTL: time.Duration(signedURLTTL) * time.Second,
+		},
+		Upload: UploadConfig{
+			MaxFileSize:    uploadMaxFileSize << 20,
+			MaxPages:       uploadMaxPages,
+			MaxRequestSize: uploadMaxRequestSize << 20,
+			MaxBatchFiles:  uploadMaxBatchFiles,
+			MaxArchiveSize: uploadMaxArchiveSize << 20,
+		},
+		Jobs: JobsConfig{
+			Workers:      jobWorkers,
+			QueueSize:    jobQueueSize,
+			Timeout:      time.Duration(jobTimeout) * time.Second,
+			PollInterval: time.Duration(jobPollInterval) * time.Second,
 		},
 		Logger: LoggerConfig{
 			Level: getEnv("LOG_LEVEL", "info"),
@@ -127,3 +302,32 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// loadKnowledgeRoutes reads RAG_ROUTES_FILE: a JSON object mapping transaction categories to routes, e.g.
+//
+//	{"fees": [{"type": "bank_tariff", "user_bank": true, "weight": 1}]}
+func loadKnowledgeRoutes(path string) (map[string][]KnowledgeRoute, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RAG_ROUTES_FILE: %w", err)
+	}
+
+	var routes map[string][]KnowledgeRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse RAG_ROUTES_FILE: %w", err)
+	}
+
+	for category, categoryRoutes := range routes {
+		for i := range categoryRoutes {
+			if categoryRoutes[i].Weight <= 0 {
+				return nil, fmt.Errorf("RAG_ROUTES_FILE: route %d of %q must have a positive weight", i, category)
+			}
+		}
+	}
+
+	return routes, nil
+}
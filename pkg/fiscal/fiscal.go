@@ -0,0 +1,176 @@
+// Package fiscal decodes the QR code printed on Russian cash register receipts.
+//
+// The code holds the fiscal data of the receipt in the format defined by the
+// Federal Tax Service, e.g.
+//
+//	t=20240301T1530&s=1234.50&fn=9289000100123456&i=12345&fp=1234567890&n=1
+//
+// where t is the local date and time, s the total in rubles, fn the fiscal drive
+// number, i the fiscal document number, fp the fiscal sign and n the operation type.
+package fiscal
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// totalPattern matches the s field: rubles with up to two decimals that fit the DECIMAL(15, 2) columns
+var totalPattern = regexp.MustCompile(`^\d{1,13}([.,]\d{1,2})?$`)
+
+// Operation types (the n field)
+const (
+	OperationIncome        = 1 // приход - покупка
+	OperationIncomeReturn  = 2 // возврат прихода
+	OperationExpense       = 3 // расход
+	OperationExpenseReturn = 4 // возврат расхода
+)
+
+// ErrNotFound is returned by Scan when the image has no receipt QR code
+var ErrNotFound = errors.New("fiscal QR code not found")
+
+// Receipt is the fiscal data of a receipt
+type Receipt struct {
+	Time          time.Time // local time printed on the receipt; the location is UTC
+	Total         float64   // rubles
+	FN            string    // fiscal drive number
+	FD            string    // fiscal document number
+	FP            string    // fiscal sign
+	OperationType int
+	Raw           string // decoded QR text
+}
+
+// Return reports whether the receipt is a refund: возврат прихода or возврат расхода
+func (r *Receipt) Return() bool {
+	return r.OperationType == OperationIncomeReturn || r.OperationType == OperationExpenseReturn
+}
+
+// Parse parses the text of a receipt QR code
+func Parse(text string) (*Receipt, error) {
+	text = strings.TrimSpace(text)
+	values, err := url.ParseQuery(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fiscal QR code: %w", err)
+	}
+
+	receipt := &Receipt{
+		FN:  values.Get("fn"),
+		FD:  values.Get("i"),
+		FP:  values.Get("fp"),
+		Raw: text,
+	}
+	if receipt.FN == "" || receipt.FD == "" || receipt.FP == "" {
+		return nil, fmt.Errorf("invalid fiscal QR code: fn, i and fp are required")
+	}
+	if !isDigits(receipt.FN) || !isDigits(receipt.FD) || !isDigits(receipt.FP) {
+		return nil, fmt.Errorf("invalid fiscal QR code: fn, i and fp must be numbers")
+	}
+
+	receipt.Time, err = parseTime(values.Get("t"))
+	if err != nil {
+		return nil, err
+	}
+
+	total := values.Get("s")
+	if !totalPattern.MatchString(total) {
+		return nil, fmt.Errorf("invalid fiscal QR code: total %q", total)
+	}
+	receipt.Total, err = strconv.ParseFloat(strings.ReplaceAll(total, ",", "."), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fiscal QR code: total %q", total)
+	}
+
+	receipt.OperationType = OperationIncome
+	if n := values.Get("n"); n != "" {
+		receipt.OperationType, err = strconv.Atoi(n)
+		if err != nil || receipt.OperationType < OperationIncome || receipt.OperationType > OperationExpenseReturn {
+			return nil, fmt.Errorf("invalid fiscal QR code: operation type %q", n)
+		}
+	}
+
+	return receipt, nil
+}
+
+// parseTime parses the t field: YYYYMMDDTHHMM, optionally with seconds
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T1504", "20060102T150405"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid fiscal QR code: time %q", value)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// maxScanSide is the image side above which Scan retries on a downscaled copy:
+// photos of receipts are often too large for the QR finder pattern detection
+const maxScanSide = 1600
+
+// Scan finds and parses a receipt QR code in an image
+func Scan(img image.Image) (*Receipt, error) {
+	text, err := decodeQR(img)
+	if err != nil {
+		bounds := img.Bounds()
+		if side := max(bounds.Dx(), bounds.Dy()); side > maxScanSide {
+			text, err = decodeQR(downscale(img, (side+maxScanSide-1)/maxScanSide))
+		}
+	}
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	return Parse(text)
+}
+
+func decodeQR(img image.Image) (string, error) {
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", err
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bitmap, hints)
+	if err != nil {
+		return "", err
+	}
+	return result.GetText(), nil
+}
+
+// downscale shrinks img by an integer factor, averaging the brightness of each block
+func downscale(img image.Image, factor int) image.Image {
+	bounds := img.Bounds()
+	small := image.NewGray(image.Rect(0, 0, bounds.Dx()/factor, bounds.Dy()/factor))
+
+	for y := 0; y < small.Rect.Dy(); y++ {
+		for x := 0; x < small.Rect.Dx(); x++ {
+			var sum, count uint32
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					r, g, b, _ := img.At(bounds.Min.X+x*factor+dx, bounds.Min.Y+y*factor+dy).RGBA()
+					sum += (299*r + 587*g + 114*b) / 1000 >> 8
+					count++
+				}
+			}
+			small.Pix[y*small.Stride+x] = uint8(sum / count)
+		}
+	}
+	return small
+}
@@ -0,0 +1,119 @@
+package fiscal
+
+import (
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Receipt
+	}{
+		{
+			name: "minutes",
+			text: "t=20240301T1530&s=1234.50&fn=9289000100123456&i=12345&fp=1234567890&n=1",
+			want: Receipt{Time: time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), Total: 1234.50, FN: "9289000100123456", FD: "12345", FP: "1234567890", OperationType: OperationIncome},
+		},
+		{
+			name: "seconds",
+			text: "t=20240301T153045&s=99.00&fn=9289000100123456&i=7&fp=42&n=1",
+			want: Receipt{Time: time.Date(2024, 3, 1, 15, 30, 45, 0, time.UTC), Total: 99, FN: "9289000100123456", FD: "7", FP: "42", OperationType: OperationIncome},
+		},
+		{
+			name: "comma in total",
+			text: "t=20231231T2359&s=450,75&fn=1&i=2&fp=3&n=1",
+			want: Receipt{Time: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC), Total: 450.75, FN: "1", FD: "2", FP: "3", OperationType: OperationIncome},
+		},
+		{
+			name: "missing operation type",
+			text: "t=20240301T1530&s=10&fn=1&i=2&fp=3",
+			want: Receipt{Time: time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), Total: 10, FN: "1", FD: "2", FP: "3", OperationType: OperationIncome},
+		},
+		{
+			name: "return",
+			text: "  t=20240301T1530&s=10&fn=1&i=2&fp=3&n=2\n",
+			want: Receipt{Time: time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), Total: 10, FN: "1", FD: "2", FP: "3", OperationType: OperationIncomeReturn},
+		},
+		{
+			name: "fields in another order",
+			text: "fn=1&fp=3&i=2&n=3&s=5.5&t=20240301T1530",
+			want: Receipt{Time: time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC), Total: 5.5, FN: "1", FD: "2", FP: "3", OperationType: OperationExpense},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			tt.want.Raw = got.Raw
+			if *got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.text, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"missing fp", "t=20240301T1530&s=10&fn=1&i=2&n=1"},
+		{"missing fn", "t=20240301T1530&s=10&i=2&fp=3&n=1"},
+		{"missing i", "t=20240301T1530&s=10&fn=1&fp=3&n=1"},
+		{"fp not a number", "t=20240301T1530&s=10&fn=1&i=2&fp=3a&n=1"},
+		{"operation type too large", "t=20240301T1530&s=10&fn=1&i=2&fp=3&n=5"},
+		{"operation type zero", "t=20240301T1530&s=10&fn=1&i=2&fp=3&n=0"},
+		{"operation type not a number", "t=20240301T1530&s=10&fn=1&i=2&fp=3&n=x"},
+		{"missing time", "s=10&fn=1&i=2&fp=3&n=1"},
+		{"date only", "t=20240301&s=10&fn=1&i=2&fp=3&n=1"},
+		{"missing total", "t=20240301T1530&fn=1&i=2&fp=3&n=1"},
+		{"negative total", "t=20240301T1530&s=-10&fn=1&i=2&fp=3&n=1"},
+		{"NaN total", "t=20240301T1530&s=NaN&fn=1&i=2&fp=3&n=1"},
+		{"infinite total", "t=20240301T1530&s=Inf&fn=1&i=2&fp=3&n=1"},
+		{"exponent total", "t=20240301T1530&s=1e300&fn=1&i=2&fp=3&n=1"},
+		{"total over DECIMAL(15, 2)", "t=20240301T1530&s=12345678901234&fn=1&i=2&fp=3&n=1"},
+		{"three decimals", "t=20240301T1530&s=10.005&fn=1&i=2&fp=3&n=1"},
+		{"hex total", "t=20240301T1530&s=0x10&fn=1&i=2&fp=3&n=1"},
+		{"not a query", "https://example.com/receipt"},
+		{"bad escape", "t=%zz&s=10&fn=1&i=2&fp=3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if receipt, err := Parse(tt.text); err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tt.text, receipt)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	const text = "t=20240301T1530&s=1234.50&fn=9289000100123456&i=12345&fp=1234567890&n=1"
+
+	matrix, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 300, 300, nil)
+	if err != nil {
+		t.Fatalf("failed to encode QR code: %v", err)
+	}
+
+	receipt, err := Scan(matrix)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if receipt.Raw != text || receipt.Total != 1234.50 || receipt.FD != "12345" {
+		t.Errorf("unexpected receipt %+v", receipt)
+	}
+
+	if _, err := Scan(image.NewGray(image.Rect(0, 0, 100, 100))); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Scan of a blank image: got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,191 @@
+package imagefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// maxTIFFPages stops decoding of TIFF files with more pages than any statement has
+const maxTIFFPages = 100
+
+// Decoder decodes image files into pages. HEIC is decoded with an external converter
+// (heif-convert from libheif or ImageMagick), as there is no HEVC decoder in Go.
+type Decoder struct {
+	heicConverter string // path of the converter; empty when it is not installed
+	logger        *zap.Logger
+}
+
+// NewDecoder creates a decoder. HEIC is supported only if heicConverter is found;
+// its command line must be "<converter> input output.png".
+func NewDecoder(heicConverter string, logger *zap.Logger) *Decoder {
+	d := &Decoder{logger: logger}
+
+	if heicConverter != "" {
+		path, err := exec.LookPath(heicConverter)
+		if err != nil {
+			logger.Warn("HEIC converter not found, HEIC files will be rejected",
+				zap.String("converter", heicConverter),
+				zap.Error(err),
+			)
+		} else {
+			d.heicConverter = path
+		}
+	}
+
+	return d
+}
+
+// Supports reports whether files of the format can be decoded into pages. PDF is not an image format
+// and is read by the caller.
+func (d *Decoder) Supports(f Format) bool {
+	switch f {
+	case FormatJPEG, FormatPNG, FormatWebP, FormatTIFF, FormatBMP:
+		return true
+	case FormatHEIC:
+		return d.heicConverter != ""
+	}
+	return false
+}
+
+// Pages decodes an image file; multi-page TIFF files give one image per page
+func (d *Decoder) Pages(ctx context.Context, path string, f Format) ([]image.Image, error) {
+	if !d.Supports(f) {
+		return nil, fmt.Errorf("unsupported image format %q", f)
+	}
+
+	switch f {
+	case FormatHEIC:
+		img, err := d.decodeHEIC(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return []image.Image{img}, nil
+	case FormatTIFF:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		return decodeTIFF(data)
+	default:
+		img, err := decodeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []image.Image{img}, nil
+	}
+}
+
+func decodeFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// decodeHEIC converts the primary image of a HEIC file to PNG with the converter and decodes it
+func (d *Decoder) decodeHEIC(ctx context.Context, path string) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "image.png")
+	if out, err := exec.CommandContext(ctx, d.heicConverter, path, output).CombinedOutput(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("failed to convert HEIC: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return decodeFile(output)
+}
+
+// TIFFPageCount returns the number of pages (IFDs) of a TIFF file without decoding them
+func TIFFPageCount(data []byte) (int, error) {
+	_, offsets, err := tiffPages(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(offsets), nil
+}
+
+// tiffPages walks the chain of IFDs of a TIFF file and returns their offsets
+func tiffPages(data []byte) (binary.ByteOrder, []uint32, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("invalid TIFF: file too short")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	seen := make(map[uint32]bool)
+	var offsets []uint32
+
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if len(offsets) == maxTIFFPages {
+			return nil, nil, fmt.Errorf("TIFF has more than %d pages", maxTIFFPages)
+		}
+		if seen[offset] || int(offset)+2 > len(data) {
+			return nil, nil, fmt.Errorf("invalid TIFF: bad offset of page %d", len(offsets)+1)
+		}
+		seen[offset] = true
+		offsets = append(offsets, offset)
+
+		// An IFD is a count of 12-byte entries followed by the offset of the next IFD
+		next := int(offset) + 2 + int(order.Uint16(data[offset:]))*12
+		if next+4 > len(data) {
+			return nil, nil, fmt.Errorf("invalid TIFF: page %d is truncated", len(offsets))
+		}
+		offset = order.Uint32(data[next:])
+	}
+
+	if len(offsets) == 0 {
+		return nil, nil, errors.New("invalid TIFF: no pages")
+	}
+	return order, offsets, nil
+}
+
+// decodeTIFF decodes every page (IFD) of a TIFF file. x/image/tiff reads only the IFD named
+// in the file header, so each page is decoded from a copy of the file whose header points to it.
+func decodeTIFF(data []byte) ([]image.Image, error) {
+	order, offsets, err := tiffPages(data)
+	if err != nil {
+		return nil, err
+	}
+
+	patched := bytes.Clone(data)
+	pages := make([]image.Image, 0, len(offsets))
+	for i, offset := range offsets {
+		order.PutUint32(patched[4:8], offset)
+		img, err := tiff.Decode(bytes.NewReader(patched))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode TIFF page %d: %w", i+1, err)
+		}
+		pages = append(pages, img)
+	}
+	return pages, nil
+}
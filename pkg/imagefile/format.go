@@ -0,0 +1,83 @@
+// Package imagefile detects document file formats by their content and decodes
+// image formats the OCR engines do not accept (HEIC, WebP, TIFF, BMP) into images.
+package imagefile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Format is a file format detected by magic bytes
+type Format string
+
+const (
+	FormatUnknown Format = ""
+	FormatJPEG    Format = "jpeg"
+	FormatPNG     Format = "png"
+	FormatPDF     Format = "pdf"
+	FormatWebP    Format = "webp"
+	FormatHEIC    Format = "heic"
+	FormatTIFF    Format = "tiff"
+	FormatBMP     Format = "bmp"
+)
+
+// SniffLength is the number of leading bytes Detect needs
+const SniffLength = 1024
+
+// heicBrands are the ftyp brands of HEIF files with HEVC images, as written by phones
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// Detect returns the format of a file from its first SniffLength bytes
+func Detect(head []byte) Format {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return FormatWebP
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) && heicBrands[string(head[8:12])]:
+		return FormatHEIC
+	case bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")):
+		return FormatTIFF
+	case bytes.HasPrefix(head, []byte("BM")) && len(head) >= 18:
+		return FormatBMP
+	case bytes.Contains(head[:min(len(head), SniffLength)], []byte("%PDF-")):
+		// Readers accept a PDF header anywhere in the first kilobyte
+		return FormatPDF
+	}
+	return FormatUnknown
+}
+
+// DetectFile returns the format of a file
+func DetectFile(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer file.Close()
+
+	head := make([]byte, SniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, fmt.Errorf("failed to read file: %w", err)
+	}
+	return Detect(head[:n]), nil
+}
+
+// Extension returns the usual file extension of the format
+func (f Format) Extension() string {
+	switch f {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatUnknown:
+		return ""
+	default:
+		return "." + string(f)
+	}
+}
@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Wire types shared by GigaChat and OpenAI-compatible APIs.
+// Both expose the same shape for chat completions and embeddings.
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+}
+
+// decodeEmbeddingResponse decodes an embeddings response and orders vectors by input index
+func decodeEmbeddingResponse(resp *http.Response, inputCount int) ([][]float32, error) {
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embeddings API failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var embResp embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(embResp.Data) != inputCount {
+		return nil, fmt.Errorf("embeddings API returned %d vectors for %d inputs", len(embResp.Data), inputCount)
+	}
+
+	vectors := make([][]float32, inputCount)
+	for _, item := range embResp.Data {
+		if item.Index < 0 || item.Index >= inputCount {
+			return nil, fmt.Errorf("embeddings API returned invalid index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+
+	return vectors, nil
+}
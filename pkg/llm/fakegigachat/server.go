@@ -0,0 +1,485 @@
+// Package fakegigachat provides an in-process GigaChat API server for offline tests.
+//
+// It implements the OAuth token endpoint and the /files, /models, /embeddings and
+// /chat/completions REST endpoints. Chat answers are scripted with OnChat rules,
+// so a whole DocumentService.ProcessDocument pipeline can run without network:
+//
+//	fake := fakegigachat.New()
+//	defer fake.Close()
+//	fake.OnChat("Извлеки весь текст", "ООО Ромашка\nИТОГ 450.00")
+//	fake.OnChat("Проанализируй текст", `[{"description":"Продукты","category":"food","amount":450,"currency":"RUB","date":"2024-12-20"}]`)
+//	cfg := fake.Config()
+//	provider, err := llm.NewGigaChatProvider(&cfg, logger)
+package fakegigachat
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"rag-iishka/pkg/config"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// APIKey is the credential accepted by the fake OAuth endpoint
+	APIKey = "fake-gigachat-key"
+
+	// DefaultReply is returned for chat requests that match no rule
+	DefaultReply = "[]"
+
+	// EmbeddingDimensions is the size of vectors returned by /embeddings,
+	// the same as the GigaChat "Embeddings" model
+	EmbeddingDimensions = 1024
+
+	oauthPath = "/api/v2/oauth"
+	apiPrefix = "/api/v1"
+)
+
+// ChatMessage is a message received by /chat/completions
+type ChatMessage struct {
+	Role        string     `json:"role"`
+	Content     string     `json:"content"`
+	Attachments [][]string `json:"attachments,omitempty"`
+}
+
+// ChatRequest is a decoded /chat/completions request
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// LastUserMessage returns the content of the last user message
+func (r ChatRequest) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// FileIDs returns IDs of all files attached to the request
+func (r ChatRequest) FileIDs() []string {
+	var ids []string
+	for _, msg := range r.Messages {
+		for _, group := range msg.Attachments {
+			ids = append(ids, group...)
+		}
+	}
+	return ids
+}
+
+// File is a file received by /files
+type File struct {
+	ID       string
+	Name     string
+	MimeType string
+	Purpose  string
+	Content  []byte
+}
+
+// ChatRule scripts an answer for chat requests accepted by Match
+type ChatRule struct {
+	Match func(req ChatRequest) bool
+	Reply func(req ChatRequest) string
+}
+
+type failure struct {
+	status int
+	body   string
+}
+
+// Server is a fake GigaChat API backed by httptest.Server
+type Server struct {
+	// TokenTTL is the lifetime of issued access tokens
+	TokenTTL time.Duration
+
+	server *httptest.Server
+
+	mu           sync.Mutex
+	tokens       map[string]time.Time
+	files        map[string]*File
+	models       []string
+	rules        []ChatRule
+	defaultReply string
+	chatRequests []ChatRequest
+	tokenCount   int
+	failures     map[string][]failure
+}
+
+// New starts a fake GigaChat server on a random local port
+func New() *Server {
+	s := &Server{
+		TokenTTL:     30 * time.Minute,
+		tokens:       make(map[string]time.Time),
+		files:        make(map[string]*File),
+		models:       []string{"GigaChat", "GigaChat-Pro", "GigaChat-Max", "Embeddings"},
+		defaultReply: DefaultReply,
+		failures:     make(map[string][]failure),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oauthPath, s.handleOAuth)
+	mux.HandleFunc(apiPrefix+"/models", s.authorized(s.handleModels))
+	mux.HandleFunc(apiPrefix+"/files", s.authorized(s.handleFiles))
+	mux.HandleFunc(apiPrefix+"/chat/completions", s.authorized(s.handleChat))
+	mux.HandleFunc(apiPrefix+"/embeddings", s.authorized(s.handleEmbeddings))
+
+	s.server = httptest.NewServer(s.injectFailures(mux))
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// BaseURL returns the REST API base URL (GIGACHAT_BASE_URL)
+func (s *Server) BaseURL() string {
+	return s.server.URL + apiPrefix
+}
+
+// AuthURL returns the OAuth endpoint URL (GIGACHAT_AUTH_URL)
+func (s *Server) AuthURL() string {
+	return s.server.URL + oauthPath
+}
+
+// Config returns a GigaChat configuration pointing at the fake server
+func (s *Server) Config() config.GigaChatConfig {
+	return config.GigaChatConfig{
+		APIKey:  APIKey,
+		Scope:   "GIGACHAT_API_PERS",
+		BaseURL: s.BaseURL(),
+		AuthURL: s.AuthURL(),
+	}
+}
+
+// OnChat replies with reply to chat requests whose last user message contains substr.
+// Rules are checked in the order they were added.
+func (s *Server) OnChat(substr, reply string) {
+	s.OnChatFunc(
+		func(req ChatRequest) bool { return strings.Contains(req.LastUserMessage(), substr) },
+		func(ChatRequest) string { return reply },
+	)
+}
+
+// OnChatFunc adds a rule with custom matching and reply logic
+func (s *Server) OnChatFunc(match func(req ChatRequest) bool, reply func(req ChatRequest) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, ChatRule{Match: match, Reply: reply})
+}
+
+// SetDefaultReply sets the answer for chat requests that match no rule
+func (s *Server) SetDefaultReply(reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultReply = reply
+}
+
+// SetModels replaces the list returned by /models
+func (s *Server) SetModels(models ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = models
+}
+
+// FailNext makes the next request to path (e.g. "/files", "/chat/completions",
+// or "/oauth") fail with the given status and body. Calls are queued.
+func (s *Server) FailNext(path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], failure{status: status, body: body})
+}
+
+// ExpireTokens invalidates all issued access tokens
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]time.Time)
+}
+
+// ChatRequests returns all received chat requests
+func (s *Server) ChatRequests() []ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatRequest(nil), s.chatRequests...)
+}
+
+// Files returns all uploaded files
+func (s *Server) Files() []*File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make([]*File, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	return files
+}
+
+// TokensIssued returns the number of access tokens issued by the OAuth endpoint
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCount
+}
+
+func (s *Server) injectFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, apiPrefix), "/api/v2")
+
+		s.mu.Lock()
+		queue := s.failures[path]
+		var f *failure
+		if len(queue) > 0 {
+			f = &queue[0]
+			s.failures[path] = queue[1:]
+		}
+		s.mu.Unlock()
+
+		if f != nil {
+			writeError(w, f.status, f.body)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		expiresAt, ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !ok || time.Now().After(expiresAt) {
+			writeError(w, http.StatusUnauthorized, "Token has expired")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleOAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.Header.Get("Authorization") != "Basic "+APIKey {
+		writeError(w, http.StatusUnauthorized, "Authorization error: header is incorrect")
+		return
+	}
+	if r.Header.Get("RqUID") == "" {
+		writeError(w, http.StatusBadRequest, "RqUID header is required")
+		return
+	}
+
+	token := uuid.NewString()
+	expiresAt := time.Now().Add(s.TokenTTL)
+
+	s.mu.Lock()
+	s.tokens[token] = expiresAt
+	s.tokenCount++
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_at":   expiresAt.UnixMilli(),
+	})
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data := make([]map[string]interface{}, len(s.models))
+	for i, model := range s.models {
+		data[i] = map[string]interface{}{
+			"id":       model,
+			"object":   "model",
+			"owned_by": "salutedevices",
+		}
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("file is required: %v", err))
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f := &File{
+		ID:       uuid.NewString(),
+		Name:     header.Filename,
+		MimeType: header.Header.Get("Content-Type"),
+		Purpose:  r.FormValue("purpose"),
+		Content:  content,
+	}
+
+	s.mu.Lock()
+	s.files[f.ID] = f
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":         f.ID,
+		"object":     "file",
+		"bytes":      len(content),
+		"created_at": time.Now().Unix(),
+		"filename":   f.Name,
+		"purpose":    f.Purpose,
+	})
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.chatRequests = append(s.chatRequests, req)
+	for _, id := range req.FileIDs() {
+		if _, ok := s.files[id]; !ok {
+			s.mu.Unlock()
+			writeError(w, http.StatusNotFound, fmt.Sprintf("file %s not found", id))
+			return
+		}
+	}
+	rules := append([]ChatRule(nil), s.rules...)
+	reply := s.defaultReply
+	s.mu.Unlock()
+
+	for _, rule := range rules {
+		if rule.Match(req) {
+			reply = rule.Reply(req)
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":  "chat.completion",
+		"model":   req.Model,
+		"created": time.Now().Unix(),
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"finish_reason": "stop",
+				"message": map[string]interface{}{
+					"role":    "assistant",
+					"content": reply,
+				},
+			},
+		},
+		"usage": map[string]int{
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+			"total_tokens":      0,
+		},
+	})
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	data := make([]map[string]interface{}, len(req.Input))
+	for i, text := range req.Input {
+		data[i] = map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": Embedding(text),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"model":  req.Model,
+		"data":   data,
+	})
+}
+
+// Embedding returns the deterministic vector the fake assigns to text: a normalized
+// bag of hashed lower-case words. Texts sharing words get a higher cosine similarity,
+// which is enough to exercise vector search without a real model.
+func Embedding(text string) []float32 {
+	vec := make([]float32, EmbeddingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		vec[h.Sum32()%EmbeddingDimensions]++
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v * v)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vec {
+			vec[i] = float32(float64(vec[i]) / norm)
+		}
+	}
+
+	return vec
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"status":  status,
+		"message": message,
+	})
+}
@@ -0,0 +1,384 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"rag-iishka/pkg/config"
+
+	"github.com/Role1776/gigago"
+	"go.uber.org/zap"
+)
+
+// gigaChatModel is the model used for chat and vision requests
+const gigaChatModel = "GigaChat"
+
+type GigaChatProvider struct {
+	client     *gigago.Client
+	model      *gigago.GenerativeModel
+	config     *config.GigaChatConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+	baseURL    string
+	tokens     *tokenSource // Access token for direct API calls
+}
+
+func NewGigaChatProvider(cfg *config.GigaChatConfig, logger *zap.Logger) (*GigaChatProvider, error) {
+	ctx := context.Background()
+
+	// Build client options
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	opts := []gigago.Option{
+		gigago.WithCustomScope(cfg.Scope),
+		gigago.WithCustomURLAI(baseURL + "/chat/completions"),
+		gigago.WithCustomURLOauth(cfg.AuthURL),
+	}
+
+	// Add insecure skip verify option if configured
+	if cfg.InsecureSkipVerify {
+		opts = append(opts, gigago.WithCustomInsecureSkipVerify(true))
+		logger.Warn("GigaChat TLS certificate verification is disabled")
+	}
+
+	client, err := gigago.NewClient(ctx, cfg.APIKey, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GigaChat client: %w", err)
+	}
+
+	model := client.GenerativeModel(gigaChatModel)
+	model.Temperature = defaultTemperature
+
+	// Create HTTP client for file uploads
+	httpClient := &http.Client{}
+	if cfg.InsecureSkipVerify {
+		httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		logger.Warn("HTTP client TLS certificate verification is disabled")
+	}
+
+	// Get access token for file uploads, fail fast on invalid credentials
+	tokens := newTokenSource(cfg, httpClient, logger)
+	if _, err := tokens.Token(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	logger.Info("Using GigaChat model")
+
+	return &GigaChatProvider{
+		client:     client,
+		model:      model,
+		config:     cfg,
+		logger:     logger,
+		httpClient: httpClient,
+		tokens:     tokens,
+		// Base URL for GigaChat REST API
+		// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
+		baseURL: baseURL,
+	}, nil
+}
+
+func (p *GigaChatProvider) Name() string {
+	return ProviderGigaChat
+}
+
+// doAuthorized sends a request built by newRequest with a Bearer token.
+// On 401 the cached token is dropped and the request is rebuilt and retried once,
+// so newRequest must return a request with a fresh body on every call.
+func (p *GigaChatProvider) doAuthorized(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := p.tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		p.logger.Warn("GigaChat rejected access token, refreshing",
+			zap.String("url", req.URL.Path),
+			zap.String("response", string(bodyBytes)),
+		)
+		p.tokens.Invalidate(token)
+	}
+}
+
+// Chat sends messages through the gigago client
+func (p *GigaChatProvider) Chat(ctx context.Context, messages []Message) (string, error) {
+	gigaMessages := make([]gigago.Message, len(messages))
+	for i, msg := range messages {
+		gigaMessages[i] = gigago.Message{Role: gigago.Role(msg.Role), Content: msg.Content}
+	}
+
+	resp, err := p.model.Generate(ctx, gigaMessages)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate response: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no response from LLM")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// ListModels retrieves list of available models from GigaChat API
+// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
+// Endpoint: GET /models
+func (p *GigaChatProvider) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := p.doAuthorized(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/models", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get models with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var modelsResp struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			Created int64  `json:"created"`
+			OwnedBy string `json:"owned_by"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	}
+
+	var modelIDs []string
+	for _, model := range modelsResp.Data {
+		modelIDs = append(modelIDs, model.ID)
+	}
+
+	return modelIDs, nil
+}
+
+// UploadFile uploads a file to GigaChat and returns the file ID
+// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
+// Endpoint: POST /files
+// Returns error with 413 status if file is too large
+func (p *GigaChatProvider) UploadFile(ctx context.Context, fileReader io.Reader, fileName string) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	// Add purpose field (required by GigaChat API)
+	// "general" allows using uploaded files in generation requests (Vision API)
+	// Documentation: https://developers.sber.ru/docs/ru/gigachat/guides/working-with-files
+	if err := writer.WriteField("purpose", "general"); err != nil {
+		return "", fmt.Errorf("failed to write purpose field: %w", err)
+	}
+
+	// Create form file with proper MIME type
+	part, err := writer.CreatePart(map[string][]string{
+		"Content-Type":        {mimeTypeByFileName(fileName)},
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename="%s"`, fileName)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	if _, err := io.Copy(part, fileReader); err != nil {
+		return "", fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close writer: %w", err)
+	}
+
+	// Create request to GigaChat Files API
+	// Endpoint: POST {baseURL}/files
+	// The body is kept in memory so the request can be replayed after a token refresh
+	resp, err := p.doAuthorized(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/files", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("file too large (413): file exceeds maximum size limit: %s", string(bodyBytes))
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// Parse upload response according to GigaChat API documentation
+	// Response format: {"id": "file_id", ...}
+	var uploadResp struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	p.logger.Info("File uploaded to GigaChat", zap.String("file_id", uploadResp.ID))
+
+	return uploadResp.ID, nil
+}
+
+// ExtractText uses GigaChat Vision API via HTTP
+// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/main
+// Endpoint: POST /chat/completions
+// Uses file attachments for vision processing
+func (p *GigaChatProvider) ExtractText(ctx context.Context, fileID, prompt string) (string, error) {
+	p.logger.Info("Using GigaChat for Vision API", zap.String("file_id", fileID))
+
+	// Create chat completion request with vision
+	// According to GigaChat API docs, attachments format: [["file_id"]]
+	requestBody := map[string]interface{}{
+		"model": gigaChatModel,
+		"messages": []map[string]interface{}{
+			{
+				"role":        "user",
+				"content":     prompt,
+				"attachments": [][]string{{fileID}}, // Array of arrays: [["file_id"]]
+			},
+		},
+		"temperature":        defaultTemperature,
+		"top_p":              0.0,
+		"stream":             false,
+		"max_tokens":         0,
+		"repetition_penalty": 1.0,
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Log request for debugging
+	p.logger.Debug("Vision API request",
+		zap.String("model", gigaChatModel),
+		zap.String("file_id", fileID),
+		zap.String("request_body", string(jsonData)),
+	)
+
+	// Create request to GigaChat Chat Completions API
+	// Endpoint: POST {baseURL}/chat/completions
+	resp, err := p.doAuthorized(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("vision API failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var visionResp chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&visionResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(visionResp.Choices) == 0 {
+		return "", fmt.Errorf("no response from Vision API")
+	}
+
+	return strings.TrimSpace(visionResp.Choices[0].Message.Content), nil
+}
+
+// Embed generates embeddings via GigaChat Embeddings API
+// Documentation: https://developers.sber.ru/docs/ru/gigachat/api/reference/rest/post-embeddings
+// Endpoint: POST /embeddings
+func (p *GigaChatProvider) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(embeddingRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := p.doAuthorized(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/embeddings", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeEmbeddingResponse(resp, len(input))
+}
+
+func (p *GigaChatProvider) Close() error {
+	if p.client != nil {
+		p.client.Close()
+	}
+	return nil
+}
+
+// mimeTypeByFileName determines MIME type from file extension
+func mimeTypeByFileName(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+
+	// Fallback to common types
+	switch ext {
+	case ".pdf":
+		return "application/pdf"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"rag-iishka/pkg/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// tokenRefreshMargin is how long before expires_at the token is refreshed,
+	// so that long requests (file uploads, vision) never start with an almost expired token
+	tokenRefreshMargin = 5 * time.Minute
+
+	// defaultTokenTTL is assumed when the OAuth response carries no expiry (GigaChat issues 30 minute tokens)
+	defaultTokenTTL = 30 * time.Minute
+)
+
+// tokenSource caches a GigaChat access token and refreshes it before expiry.
+// It is safe for concurrent use: callers block while a single refresh is in flight.
+type tokenSource struct {
+	config     *config.GigaChatConfig
+	httpClient *http.Client
+	logger     *zap.Logger
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newTokenSource(cfg *config.GigaChatConfig, httpClient *http.Client, logger *zap.Logger) *tokenSource {
+	return &tokenSource{
+		config:     cfg,
+		httpClient: httpClient,
+		logger:     logger,
+	}
+}
+
+// Token returns a cached access token or obtains a new one if it is missing or about to expire
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && time.Now().Before(ts.expiresAt.Add(-tokenRefreshMargin)) {
+		return ts.token, nil
+	}
+
+	token, expiresAt, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ts.token = token
+	ts.expiresAt = expiresAt
+	return token, nil
+}
+
+// Invalidate drops the cached token if it is still the one that was rejected.
+// A token already replaced by a concurrent refresh is kept.
+func (ts *tokenSource) Invalidate(rejected string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token == rejected {
+		ts.token = ""
+		ts.expiresAt = time.Time{}
+	}
+}
+
+// fetch obtains an access token from GigaChat OAuth endpoint
+// According to GigaChat API docs, API key should already be Base64-encoded
+func (ts *tokenSource) fetch(ctx context.Context) (string, time.Time, error) {
+	// Generate RqUID as required by GigaChat API
+	rqUID := uuid.New().String()
+
+	// Prepare form data
+	formData := url.Values{}
+	formData.Set("scope", ts.config.Scope)
+
+	// Create request with form data
+	req, err := http.NewRequestWithContext(ctx, "POST", ts.config.AuthURL, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create OAuth request: %w", err)
+	}
+
+	// Set headers according to GigaChat API documentation
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("RqUID", rqUID)
+	// API key should already be Base64-encoded (as per GigaChat API docs)
+	req.Header.Set("Authorization", "Basic "+ts.config.APIKey)
+
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		ts.logger.Error("OAuth request failed",
+			zap.Int("status", resp.StatusCode),
+			zap.String("response", string(bodyBytes)),
+			zap.String("rq_uid", rqUID),
+		)
+		return "", time.Time{}, fmt.Errorf("OAuth failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var oauthResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresAt   int64  `json:"expires_at"` // Unix time in milliseconds
+		ExpiresIn   int64  `json:"expires_in"` // Seconds, returned by some proxies
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&oauthResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode OAuth response: %w", err)
+	}
+
+	if oauthResp.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("empty access token in OAuth response")
+	}
+
+	var expiresAt time.Time
+	switch {
+	case oauthResp.ExpiresAt > 0:
+		expiresAt = time.UnixMilli(oauthResp.ExpiresAt)
+	case oauthResp.ExpiresIn > 0:
+		expiresAt = time.Now().Add(time.Duration(oauthResp.ExpiresIn) * time.Second)
+	default:
+		expiresAt = time.Now().Add(defaultTokenTTL)
+	}
+
+	ts.logger.Info("Access token obtained", zap.Time("expires_at", expiresAt))
+	return oauthResp.AccessToken, expiresAt, nil
+}
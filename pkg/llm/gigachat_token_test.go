@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"rag-iishka/pkg/llm/fakegigachat"
+
+	"go.uber.org/zap"
+)
+
+func TestTokenSourceRefreshMargin(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		wantTokens int
+	}{
+		// The token outlives the margin, so it is fetched once and then reused
+		{"cached", tokenRefreshMargin + time.Minute, 1},
+		// The token expires within the margin, so every call fetches a new one
+		{"within margin", tokenRefreshMargin - time.Second, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakegigachat.New()
+			defer fake.Close()
+			fake.TokenTTL = tt.ttl
+
+			cfg := fake.Config()
+			tokens := newTokenSource(&cfg, &http.Client{}, zap.NewNop())
+
+			issued := make(map[string]bool)
+			for i := 0; i < 3; i++ {
+				token, err := tokens.Token(context.Background())
+				if err != nil {
+					t.Fatalf("Token: %v", err)
+				}
+				issued[token] = true
+			}
+
+			if fake.TokensIssued() != tt.wantTokens || len(issued) != tt.wantTokens {
+				t.Errorf("got %d tokens from OAuth, %d distinct, want %d", fake.TokensIssued(), len(issued), tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestTokenSourceInvalidate(t *testing.T) {
+	fake := fakegigachat.New()
+	defer fake.Close()
+
+	cfg := fake.Config()
+	tokens := newTokenSource(&cfg, &http.Client{}, zap.NewNop())
+	ctx := context.Background()
+
+	first, err := tokens.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+
+	// A rejected token that was already replaced is kept
+	tokens.Invalidate("stale")
+	if token, _ := tokens.Token(ctx); token != first || fake.TokensIssued() != 1 {
+		t.Errorf("Invalidate of another token dropped the cached one")
+	}
+
+	tokens.Invalidate(first)
+	second, err := tokens.Token(ctx)
+	if err != nil {
+		t.Fatalf("Token after Invalidate: %v", err)
+	}
+	if second == first || fake.TokensIssued() != 2 {
+		t.Errorf("Invalidate did not force a refresh")
+	}
+}
+
+func TestTokenSourceOAuthError(t *testing.T) {
+	fake := fakegigachat.New()
+	defer fake.Close()
+
+	cfg := fake.Config()
+	cfg.APIKey = "wrong"
+	if _, err := newTokenSource(&cfg, &http.Client{}, zap.NewNop()).Token(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("want a 401 OAuth error, got %v", err)
+	}
+}
+
+func TestDoAuthorizedRetriesOnce(t *testing.T) {
+	fake := fakegigachat.New()
+	defer fake.Close()
+
+	cfg := fake.Config()
+	provider, err := NewGigaChatProvider(&cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewGigaChatProvider: %v", err)
+	}
+	defer provider.Close()
+	ctx := context.Background()
+
+	// The server forgets the token although it has not expired for the client:
+	// the request is rejected once, repeated with a new token and succeeds
+	fake.ExpireTokens()
+	issued := fake.TokensIssued()
+	vectors, err := provider.Embed(ctx, "Embeddings", []string{"кешбэк"})
+	if err != nil {
+		t.Fatalf("Embed after token expiry: %v", err)
+	}
+	if len(vectors) != 1 || len(vectors[0]) != fakegigachat.EmbeddingDimensions {
+		t.Errorf("unexpected embeddings: %d vectors", len(vectors))
+	}
+	if fake.TokensIssued() != issued+1 {
+		t.Errorf("got %d new tokens, want 1", fake.TokensIssued()-issued)
+	}
+
+	// A second 401 is returned to the caller instead of retrying again
+	fake.FailNext("/embeddings", http.StatusUnauthorized, "Token has expired")
+	fake.FailNext("/embeddings", http.StatusUnauthorized, "Token has expired")
+	fake.FailNext("/embeddings", http.StatusUnauthorized, "Token has expired")
+	issued = fake.TokensIssued()
+	if _, err := provider.Embed(ctx, "Embeddings", []string{"кешбэк"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("want a 401 error after the retry, got %v", err)
+	}
+	if fake.TokensIssued() != issued+1 {
+		t.Errorf("got %d new tokens, want 1 for the single retry", fake.TokensIssued()-issued)
+	}
+
+	// The third queued failure is still pending: the retry was not repeated
+	if _, err := provider.Embed(ctx, "Embeddings", []string{"кешбэк"}); err != nil {
+		t.Errorf("Embed after one rejection: %v", err)
+	}
+}
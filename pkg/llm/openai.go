@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"rag-iishka/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+// OpenAIProvider talks to any server implementing the OpenAI chat completions API,
+// e.g. a local llama.cpp server or Ollama (http://localhost:11434/v1)
+type OpenAIProvider struct {
+	config     *config.OpenAIConfig
+	logger     *zap.Logger
+	httpClient *http.Client
+	baseURL    string
+}
+
+func NewOpenAIProvider(cfg *config.OpenAIConfig, logger *zap.Logger) (*OpenAIProvider, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("OPENAI_BASE_URL is required for the openai provider")
+	}
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("OPENAI_MODEL is required for the openai provider")
+	}
+
+	logger.Info("Using OpenAI-compatible LLM provider",
+		zap.String("base_url", cfg.BaseURL),
+		zap.String("model", cfg.Model),
+	)
+
+	return &OpenAIProvider{
+		config:     cfg,
+		logger:     logger,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+	}, nil
+}
+
+func (p *OpenAIProvider) Name() string {
+	return ProviderOpenAI
+}
+
+// Chat sends messages to POST /chat/completions
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []Message) (string, error) {
+	apiMessages := make([]map[string]interface{}, len(messages))
+	for i, msg := range messages {
+		apiMessages[i] = map[string]interface{}{
+			"role":    string(msg.Role),
+			"content": msg.Content,
+		}
+	}
+
+	return p.chatCompletion(ctx, p.config.Model, apiMessages)
+}
+
+// UploadFile encodes the file as a data URL.
+// OpenAI-compatible servers have no file storage for vision requests,
+// so the returned "file ID" is passed inline as image_url by ExtractText.
+func (p *OpenAIProvider) UploadFile(ctx context.Context, fileReader io.Reader, fileName string) (string, error) {
+	data, err := io.ReadAll(fileReader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	mimeType := mimeTypeByFileName(fileName)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", fmt.Errorf("openai provider supports only images for vision, got %s", mimeType)
+	}
+
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// ExtractText sends a vision request with the image attached as image_url
+func (p *OpenAIProvider) ExtractText(ctx context.Context, fileID, prompt string) (string, error) {
+	model := p.config.VisionModel
+	if model == "" {
+		model = p.config.Model
+	}
+
+	apiMessages := []map[string]interface{}{
+		{
+			"role": "user",
+			"content": []map[string]interface{}{
+				{"type": "text", "text": prompt},
+				{"type": "image_url", "image_url": map[string]string{"url": fileID}},
+			},
+		},
+	}
+
+	text, err := p.chatCompletion(ctx, model, apiMessages)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(text), nil
+}
+
+// Embed generates embeddings via POST /embeddings
+func (p *OpenAIProvider) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(embeddingRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := p.do(ctx, "POST", "/embeddings", jsonData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeEmbeddingResponse(resp, len(input))
+}
+
+// ListModels retrieves models via GET /models
+func (p *OpenAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, "GET", "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get models with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var modelsResp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode models response: %w", err)
+	}
+
+	var modelIDs []string
+	for _, model := range modelsResp.Data {
+		modelIDs = append(modelIDs, model.ID)
+	}
+
+	return modelIDs, nil
+}
+
+func (p *OpenAIProvider) Close() error {
+	p.httpClient.CloseIdleConnections()
+	return nil
+}
+
+func (p *OpenAIProvider) chatCompletion(ctx context.Context, model string, messages []map[string]interface{}) (string, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model":       model,
+		"messages":    messages,
+		"temperature": defaultTemperature,
+		"stream":      false,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := p.do(ctx, "POST", "/chat/completions", jsonData)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("chat completion failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var completion chatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no response from LLM")
+	}
+
+	return completion.Choices[0].Message.Content, nil
+}
+
+func (p *OpenAIProvider) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	return resp, nil
+}
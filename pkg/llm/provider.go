@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"rag-iishka/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ProviderGigaChat = "gigachat"
+	ProviderOpenAI   = "openai"
+)
+
+// defaultTemperature is used for all chat and vision requests
+const defaultTemperature = 0.3
+
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single chat message sent to a provider
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// Provider is an LLM backend used by the service layer.
+// GigaChat is the primary implementation, OpenAI-compatible servers
+// (llama.cpp, Ollama, vLLM) can be used for development and testing.
+type Provider interface {
+	// Name returns the provider identifier (gigachat, openai)
+	Name() string
+
+	// Chat sends messages to the chat completion endpoint and returns the answer text
+	Chat(ctx context.Context, messages []Message) (string, error)
+
+	// UploadFile makes a file available for vision requests and returns its ID
+	UploadFile(ctx context.Context, fileReader io.Reader, fileName string) (string, error)
+
+	// ExtractText runs a vision request with the uploaded file attached
+	ExtractText(ctx context.Context, fileID, prompt string) (string, error)
+
+	// Embed returns one embedding vector per input text
+	Embed(ctx context.Context, model string, input []string) ([][]float32, error)
+
+	// ListModels returns IDs of models available to the provider
+	ListModels(ctx context.Context) ([]string, error)
+
+	Close() error
+}
+
+// NewProvider creates the provider selected by LLM_PROVIDER
+func NewProvider(cfg *config.Config, logger *zap.Logger) (Provider, error) {
+	switch cfg.LLM.Provider {
+	case ProviderGigaChat, "":
+		return NewGigaChatProvider(&cfg.GigaChat, logger)
+	case ProviderOpenAI:
+		return NewOpenAIProvider(&cfg.OpenAI, logger)
+	default:
+		return nil, fmt.Errorf("unknown LLM provider: %s (supported: %s, %s)", cfg.LLM.Provider, ProviderGigaChat, ProviderOpenAI)
+	}
+}
@@ -0,0 +1,70 @@
+package ocr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// BestEngine runs several engines on the same image and keeps the result with the highest confidence.
+// An engine that fails (e.g. GigaChat quota exceeded) is skipped, so recognition fails only if all of them do.
+type BestEngine struct {
+	engines []Engine
+	logger  *zap.Logger
+}
+
+func NewBestEngine(logger *zap.Logger, engines ...Engine) *BestEngine {
+	return &BestEngine{
+		engines: engines,
+		logger:  logger,
+	}
+}
+
+func (e *BestEngine) Name() string {
+	return ProviderBest
+}
+
+func (e *BestEngine) Recognize(ctx context.Context, imagePath string) (*Result, error) {
+	results := make([]*Result, len(e.engines))
+	errs := make([]error, len(e.engines))
+
+	var wg sync.WaitGroup
+	for i, engine := range e.engines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = engine.Recognize(ctx, imagePath)
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var best *Result
+	for i, engine := range e.engines {
+		if errs[i] != nil {
+			e.logger.Warn("OCR engine failed", zap.String("engine", engine.Name()), zap.Error(errs[i]))
+			errs[i] = fmt.Errorf("%s: %w", engine.Name(), errs[i])
+			continue
+		}
+
+		e.logger.Info("OCR engine result",
+			zap.String("engine", engine.Name()),
+			zap.Float64("confidence", results[i].Confidence),
+			zap.Int("text_length", len(results[i].Text)),
+		)
+		if best == nil || results[i].Confidence > best.Confidence {
+			best = results[i]
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("all OCR engines failed: %w", errors.Join(errs...))
+	}
+	return best, nil
+}
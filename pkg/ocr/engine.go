@@ -0,0 +1,57 @@
+// Package ocr recognizes text in images. Engines are interchangeable: the vision model
+// of the LLM provider, a local Tesseract, or both with the more confident result kept.
+package ocr
+
+import (
+	"context"
+	"fmt"
+
+	"rag-iishka/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ProviderGigaChat  = "gigachat"
+	ProviderTesseract = "tesseract"
+	ProviderBest      = "best"
+)
+
+// Result is the text recognized in an image
+type Result struct {
+	Text       string
+	Confidence float64 // 0..1
+	Engine     string
+}
+
+// Engine recognizes text in an image file
+type Engine interface {
+	// Name returns the engine identifier (gigachat, tesseract, best)
+	Name() string
+
+	// Recognize returns the text of the image; an unreadable image gives an empty text
+	Recognize(ctx context.Context, imagePath string) (*Result, error)
+}
+
+// VisionExtractor reads the text of an image with a vision model
+type VisionExtractor interface {
+	ExtractTextFromImage(ctx context.Context, imagePath string) (string, error)
+}
+
+// NewEngine creates the engine selected by OCR_PROVIDER
+func NewEngine(cfg *config.OCRConfig, vision VisionExtractor, logger *zap.Logger) (Engine, error) {
+	switch cfg.Provider {
+	case ProviderGigaChat, "":
+		return NewVisionEngine(vision, cfg.VisionConfidence), nil
+	case ProviderTesseract:
+		return NewTesseractEngine(cfg, logger)
+	case ProviderBest:
+		tesseract, err := NewTesseractEngine(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		return NewBestEngine(logger, NewVisionEngine(vision, cfg.VisionConfidence), tesseract), nil
+	default:
+		return nil, fmt.Errorf("unknown OCR provider: %s (supported: %s, %s, %s)", cfg.Provider, ProviderGigaChat, ProviderTesseract, ProviderBest)
+	}
+}
@@ -0,0 +1,149 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rag-iishka/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+// TesseractEngine recognizes images locally with the tesseract command line tool,
+// so documents do not leave the server
+type TesseractEngine struct {
+	path      string
+	languages string
+	logger    *zap.Logger
+}
+
+// NewTesseractEngine checks that tesseract and its language data are installed
+func NewTesseractEngine(cfg *config.OCRConfig, logger *zap.Logger) (*TesseractEngine, error) {
+	path, err := exec.LookPath(cfg.TesseractPath)
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found (install tesseract-ocr or set TESSERACT_PATH): %w", err)
+	}
+
+	output, err := exec.Command(path, "--list-langs").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tesseract languages: %w: %s", err, output)
+	}
+	installed := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		installed[strings.TrimSpace(line)] = true
+	}
+	for _, lang := range strings.Split(cfg.TesseractLanguages, "+") {
+		if !installed[lang] {
+			return nil, fmt.Errorf("tesseract language %q is not installed (e.g. apt install tesseract-ocr-%s)", lang, lang)
+		}
+	}
+
+	logger.Info("Tesseract OCR engine initialized",
+		zap.String("path", path),
+		zap.String("languages", cfg.TesseractLanguages),
+	)
+
+	return &TesseractEngine{
+		path:      path,
+		languages: cfg.TesseractLanguages,
+		logger:    logger,
+	}, nil
+}
+
+func (e *TesseractEngine) Name() string {
+	return ProviderTesseract
+}
+
+// Recognize runs tesseract with TSV output: the text is rebuilt from the recognized words
+// and the confidence is the mean word confidence weighted by word length
+func (e *TesseractEngine) Recognize(ctx context.Context, imagePath string) (*Result, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.path, imagePath, "stdout", "-l", e.languages, "tsv")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	result, err := parseTSV(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+	result.Engine = e.Name()
+	return result, nil
+}
+
+// tsvWord is the word level of tesseract TSV output
+const tsvWord = 5
+
+// parseTSV rebuilds the text of tesseract TSV output. Columns: level page_num block_num par_num
+// line_num word_num left top width height conf text. Words of one line are joined with spaces,
+// blocks are separated with an empty line.
+func parseTSV(tsv string) (*Result, error) {
+	type lineKey struct{ page, block, par, line int }
+
+	var builder strings.Builder
+	var weighted, weight float64
+	var prev lineKey
+	first := true
+
+	for n, row := range strings.Split(tsv, "\n") {
+		fields := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if n == 0 || len(fields) < 12 {
+			continue // header or trailing line
+		}
+
+		level, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tesseract output line %d: %q", n+1, row)
+		}
+		word := strings.TrimSpace(fields[11])
+		if level != tsvWord || word == "" {
+			continue
+		}
+
+		var key lineKey
+		for i, dst := range []*int{&key.page, &key.block, &key.par, &key.line} {
+			if *dst, err = strconv.Atoi(fields[i+1]); err != nil {
+				return nil, fmt.Errorf("invalid tesseract output line %d: %q", n+1, row)
+			}
+		}
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tesseract output line %d: %q", n+1, row)
+		}
+
+		switch {
+		case first:
+		case key.page != prev.page || key.block != prev.block:
+			builder.WriteString("\n\n")
+		case key != prev:
+			builder.WriteString("\n")
+		default:
+			builder.WriteString(" ")
+		}
+		builder.WriteString(word)
+		prev, first = key, false
+
+		if conf >= 0 {
+			runes := float64(utf8.RuneCountInString(word))
+			weighted += conf * runes
+			weight += runes
+		}
+	}
+
+	result := &Result{Text: builder.String()}
+	if weight > 0 {
+		result.Confidence = weighted / weight / 100
+	}
+	return result, nil
+}
@@ -0,0 +1,38 @@
+package ocr
+
+import (
+	"context"
+	"strings"
+)
+
+// VisionEngine recognizes images with the vision model of the LLM provider (GigaChat Vision)
+type VisionEngine struct {
+	vision     VisionExtractor
+	confidence float64
+}
+
+// NewVisionEngine creates a vision engine. Vision models do not report a confidence,
+// so every non-empty result gets the given one.
+func NewVisionEngine(vision VisionExtractor, confidence float64) *VisionEngine {
+	return &VisionEngine{
+		vision:     vision,
+		confidence: confidence,
+	}
+}
+
+func (e *VisionEngine) Name() string {
+	return ProviderGigaChat
+}
+
+func (e *VisionEngine) Recognize(ctx context.Context, imagePath string) (*Result, error) {
+	text, err := e.vision.ExtractTextFromImage(ctx, imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Text: strings.TrimSpace(text), Engine: e.Name()}
+	if result.Text != "" {
+		result.Confidence = e.confidence
+	}
+	return result, nil
+}
@@ -0,0 +1,57 @@
+// Package storage stores uploaded document files. Backends are interchangeable:
+// a local directory for a single instance, or an S3-compatible bucket (AWS S3, MinIO,
+// Yandex Object Storage) shared by all replicas.
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"rag-iishka/pkg/config"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound is returned by Get for keys that are not stored
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a blob store addressed by keys such as "documents/ab/ab12....jpg"
+type Storage interface {
+	// Put stores size bytes of r under the key, replacing an existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Get opens the object for reading; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Exists reports whether the key is stored
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// ContentKey returns the content-addressed key of a file from its SHA-256 digest and extension.
+// Files with the same content share one object; the first two hex digits spread objects
+// over directories of the local backend.
+func ContentKey(sha256 []byte, ext string) string {
+	digest := hex.EncodeToString(sha256)
+	return path.Join("documents", digest[:2], digest+ext)
+}
+
+// New creates the backend selected by STORAGE_BACKEND
+func New(ctx context.Context, cfg *config.StorageConfig, logger *zap.Logger) (Storage, error) {
+	switch cfg.Backend {
+	case BackendLocal, "":
+		return NewLocal(cfg.LocalDir, logger)
+	case BackendS3:
+		return NewS3(ctx, cfg, logger)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s (supported: %s, %s)", cfg.Backend, BackendLocal, BackendS3)
+	}
+}
@@ -226,8 +226,14 @@ async function handleUpload(event) {
         return;
     }
     
+    const files = Array.from(fileInput.files);
+    if (files.length > 1 || files[0].name.toLowerCase().endsWith('.zip')) {
+        await handleBatchUpload(files, docType);
+        return;
+    }
+    
     const formData = new FormData();
-    formData.append('file', fileInput.files[0]);
+    formData.append('file', files[0]);
     formData.append('type', docType);
     
     try {
@@ -242,7 +248,13 @@ async function handleUpload(event) {
         
         const data = await response.json();
         
-        if (response.ok) {
+        if (response.ok && data.duplicate) {
+            // The same file was uploaded before: the server returned the existing document
+            successDiv.textContent = `Этот файл уже загружен ${formatDate(data.created_at)}`;
+            successDiv.style.display = 'block';
+            fileInput.value = '';
+            await loadDocuments();
+        } else if (response.ok) {
             successDiv.textContent = 'Документ успешно загружен! Обработка началась...';
             successDiv.style.display = 'block';
             fileInput.value = '';
@@ -264,11 +276,124 @@ async function handleUpload(event) {
     }
 }
 
+// Upload several files or ZIP archives in one request; the server starts their processing
+async function handleBatchUpload(files, docType) {
+    const errorDiv = document.getElementById('uploadError');
+    const successDiv = document.getElementById('uploadSuccess');
+    const fileInput = document.getElementById('file');
+    
+    const formData = new FormData();
+    files.forEach(file => formData.append('files', file));
+    formData.append('type', docType);
+    formData.append('process', 'true');
+    
+    try {
+        const response = await fetch(`${API_BASE}/api/v1/documents/upload/batch`, {
+            method: 'POST',
+            headers: {
+                'Authorization': `Bearer ${getToken()}`,
+            },
+            body: formData,
+        });
+        
+        const data = await response.json();
+        
+        if (!response.ok) {
+            errorDiv.textContent = data.error || 'Ошибка загрузки';
+            errorDiv.style.display = 'block';
+            return;
+        }
+        
+        fileInput.value = '';
+        successDiv.textContent = `Загружено документов: ${data.uploaded}, уже были загружены: ${data.duplicates}. Обработка началась...`;
+        successDiv.style.display = 'block';
+        
+        const failed = data.results.filter(result => result.error);
+        if (failed.length > 0) {
+            errorDiv.textContent = 'Не загружены: ' + failed
+                .map(result => `${result.archive ? result.archive + '/' : ''}${result.file_name} - ${result.error}`)
+                .join('; ');
+            errorDiv.style.display = 'block';
+        }
+        
+        await loadDocuments();
+    } catch (error) {
+        errorDiv.textContent = 'Ошибка подключения к серверу';
+        errorDiv.style.display = 'block';
+    }
+}
+
 // Store processed document data
 let processedDocuments = {};
 
+const JOB_POLL_INTERVAL_MS = 2000;
+
+const jobStageNames = {
+    'ocr': 'распознавание текста',
+    'analysis': 'анализ транзакций',
+    'recommendations': 'рекомендации'
+};
+
+// Start a processing job and poll it until it finishes.
+// Returns the processing result; throws Error with a user-facing message on failure.
+async function runProcessingJob(documentId, force, onProgress) {
+    const query = force ? '?force=true' : '';
+    const response = await apiCall(`/api/v1/documents/${documentId}/process${query}`, {
+        method: 'POST',
+    });
+    let job = await response.json();
+    if (!response.ok) {
+        throw new Error(job.error || 'Ошибка обработки документа');
+    }
+
+    while (job.status === 'pending' || job.status === 'running') {
+        if (onProgress) {
+            onProgress(job);
+        }
+        await new Promise(resolve => setTimeout(resolve, JOB_POLL_INTERVAL_MS));
+
+        const jobResponse = await apiCall(`/api/v1/jobs/${job.id}`);
+        const data = await jobResponse.json();
+        if (!jobResponse.ok) {
+            throw new Error(data.error || 'Ошибка получения статуса обработки');
+        }
+        job = data;
+    }
+
+    if (job.status === 'failed') {
+        throw new Error(job.error || 'Ошибка обработки документа');
+    }
+
+    return job.result || {};
+}
+
+// Load stored transactions and recommendations of a processed document
+async function fetchDocumentResults(documentId) {
+    const [txResponse, recResponse] = await Promise.all([
+        apiCall(`/api/v1/documents/${documentId}/transactions`),
+        apiCall(`/api/v1/documents/${documentId}/recommendations`),
+    ]);
+    if (!txResponse.ok || !recResponse.ok) {
+        return null;
+    }
+
+    return {
+        transactions: await txResponse.json(),
+        recommendations: await recResponse.json(),
+        processedAt: new Date().toISOString()
+    };
+}
+
+function formatJobProgress(job) {
+    if (job.status === 'pending') {
+        return 'В очереди...';
+    }
+    const stage = jobStageNames[job.stage] || 'обработка';
+    return `${stage} (${job.progress}%)`;
+}
+
 // Process document
-async function processDocument(documentId, event) {
+async function processDocument(documentId, event, force = false) {
     // Show loading state
     const button = event?.target || document.querySelector(`button[onclick*="processDocument('${documentId}')"]`);
     if (button) {
@@ -287,44 +412,36 @@ async function processDocument(documentId, event) {
     }
     
     try {
-        const response = await apiCall(`/api/v1/documents/${documentId}/process`, {
-            method: 'POST',
+        const data = await runProcessingJob(documentId, force, job => {
+            const statusEl = document.querySelector(`[data-doc-id="${documentId}"] .status`);
+            if (statusEl) {
+                statusEl.textContent = formatJobProgress(job);
+            }
         });
+        console.log('Document processed:', data);
+        console.log('Transactions:', data.transactions);
+        console.log('Recommendations:', data.recommendations);
         
-        if (response.ok) {
-            const data = await response.json();
-            console.log('Document processed:', data);
-            console.log('Transactions:', data.transactions);
-            console.log('Recommendations:', data.recommendations);
-            
-            // Store processed data
-            processedDocuments[documentId] = {
-                transactions: data.transactions || [],
-                recommendations: data.recommendations || [],
-                processedAt: new Date().toISOString()
-            };
-            
-            console.log('Stored processed data:', processedDocuments[documentId]);
-            
-            // Reload documents to show updated status
-            await loadDocuments();
-            
-            // Show success message
-            showNotification('Документ успешно обработан!', 'success');
-        } else {
-            const errorData = await response.json();
-            showNotification(errorData.error || 'Ошибка обработки документа', 'error');
-            if (button) {
-                button.disabled = false;
-                button.textContent = 'Обработать';
-            }
-        }
+        // Store processed data
+        processedDocuments[documentId] = {
+            transactions: data.transactions || [],
+            recommendations: data.recommendations || [],
+            processedAt: new Date().toISOString()
+        };
+        
+        console.log('Stored processed data:', processedDocuments[documentId]);
+        
+        // Reload documents to show updated status
+        await loadDocuments();
+        
+        // Show success message
+        showNotification('Документ успешно обработан!', 'success');
     } catch (error) {
         console.error('Processing error:', error);
-        showNotification('Ошибка подключения к серверу', 'error');
+        showNotification(error.message || 'Ошибка подключения к серверу', 'error');
         if (button) {
             button.disabled = false;
-            button.textContent = 'Обработать';
+            button.textContent = force ? 'Обработать заново' : 'Обработать';
         }
     }
 }
@@ -350,24 +467,26 @@ async function loadDocuments() {
             } else {
                 documentsList.innerHTML = documents.map(doc => {
                     const hasText = doc.extracted_text && doc.extracted_text.length > 0;
-                    const status = hasText ? 'processed' : 'uploaded';
+                    const isProcessed = hasText || !!doc.processed_at;
+                    const status = isProcessed ? 'processed' : 'uploaded';
                     const processedData = processedDocuments[doc.id];
                     const txCount = processedData?.transactions?.length || 0;
                     const recCount = processedData?.recommendations?.length || 0;
                     
                     // Check if document has been processed (has text or has processed data)
-                    const hasProcessedData = hasText || (processedData && (txCount > 0 || recCount > 0));
+                    const hasProcessedData = isProcessed || (processedData && (txCount > 0 || recCount > 0));
                     
                     return `
                     <div class="document-card" data-doc-id="${doc.id}">
-                        <h3>${escapeHtml(getDocTypeName(doc.type))}</h3>
-                        <p><strong>Файл:</strong> ${escapeHtml(doc.file_name)}</p>
+                        <h3>${escapeHtml(getDocTypeName(doc.detected_type || doc.type))}</h3>
+                        ${doc.detected_type ? `<p><strong>Тип определён:</strong> ${Math.round((doc.type_confidence || 0) * 100)}%${doc.type && doc.type !== doc.detected_type ? ` (выбран: ${escapeHtml(getDocTypeName(doc.type))})` : ''}</p>` : ''}
+                        <p><strong>Файл:</strong> <a href="#" onclick="openDocumentFile('${doc.id}'); return false;" title="Открыть файл">${escapeHtml(doc.file_name)}</a></p>
                         <p><strong>Размер:</strong> ${formatFileSize(doc.file_size)}</p>
                         <p><strong>Дата:</strong> ${formatDate(doc.created_at)}</p>
                         <span class="status ${status}">${getStatusText(status)}</span>
                         ${txCount > 0 ? `<p><strong>Транзакций:</strong> ${txCount}</p>` : ''}
                         ${recCount > 0 ? `<p><strong>Рекомендаций:</strong> ${recCount}</p>` : ''}
-                        ${!hasText ? `
+                        ${!isProcessed ? `
                             <div class="document-actions">
                                 <button class="btn btn-primary" onclick="processDocument('${doc.id}', event)">
                                     Обработать
@@ -379,6 +498,9 @@ async function loadDocuments() {
                                 <button class="btn btn-secondary" onclick="showDocumentDetails('${doc.id}')">
                                     Подробнее
                                 </button>
+                                <button class="btn btn-secondary" onclick="processDocument('${doc.id}', event, true)">
+                                    Обработать заново
+                                </button>
                                 ${recCount > 0 ? `
                                     <button class="btn btn-primary" onclick="showRecommendations('${doc.id}')">
                                         💡 Рекомендации (${recCount})
@@ -439,7 +561,51 @@ function getDocTypeName(type) {
         'statement': 'Выписка',
         'screenshot': 'Скриншот',
     };
-    return typeMap[type] || type;
+    return typeMap[type] || type || 'Документ';
+}
+
+// Open the uploaded file in a new tab: by a short-lived signed URL if the server issues them,
+// otherwise download it with the token. If the browser blocks the tab, the signed URL is opened
+// in this tab and the downloaded file is saved instead.
+async function openDocumentFile(documentId) {
+    // Open the tab before awaiting, popups opened later are blocked
+    const fileWindow = window.open('', '_blank');
+    try {
+        const urlResponse = await apiCall(`/api/v1/documents/${documentId}/file-url`);
+        if (urlResponse.ok) {
+            const { url } = await urlResponse.json();
+            if (fileWindow) {
+                fileWindow.location = `${API_BASE}${url}`;
+            } else {
+                window.location.assign(`${API_BASE}${url}`);
+            }
+            return;
+        }
+
+        const fileResponse = await apiCall(`/api/v1/documents/${documentId}/file`);
+        if (!fileResponse.ok) {
+            throw new Error(`HTTP ${fileResponse.status}`);
+        }
+        const blobUrl = URL.createObjectURL(await fileResponse.blob());
+        if (fileWindow) {
+            fileWindow.location = blobUrl;
+            return;
+        }
+
+        const link = document.createElement('a');
+        link.href = blobUrl;
+        link.download = '';
+        document.body.appendChild(link);
+        link.click();
+        link.remove();
+        setTimeout(() => URL.revokeObjectURL(blobUrl), 60000);
+    } catch (error) {
+        console.error('Error opening document file:', error);
+        if (fileWindow) {
+            fileWindow.close();
+        }
+        showNotification('Не удалось открыть файл', 'error');
+    }
 }
 
 // Show document details modal
@@ -449,8 +615,7 @@ async function showDocumentDetails(documentId) {
     
     let processedData = processedDocuments[documentId];
     
-    // If data not in memory, try to fetch it by processing the document again
-    // (this will return cached data if already processed)
+    // If data not in memory, load stored results from the server
     if (!processedData) {
         // Show loading state
         const loadingModal = document.createElement('div');
@@ -467,20 +632,8 @@ async function showDocumentDetails(documentId) {
         document.body.appendChild(loadingModal);
         
         try {
-            // Try to get data by processing (will return existing data if already processed)
-            const response = await apiCall(`/api/v1/documents/${documentId}/process`, {
-                method: 'POST',
-            });
-            
-            if (response.ok) {
-                const data = await response.json();
-                processedData = {
-                    transactions: data.transactions || [],
-                    recommendations: data.recommendations || [],
-                    processedAt: new Date().toISOString()
-                };
-                processedDocuments[documentId] = processedData;
-            }
+            processedData = await fetchDocumentResults(documentId);
+            processedDocuments[documentId] = processedData;
         } catch (error) {
             console.error('Error fetching document data:', error);
         } finally {
@@ -526,6 +679,7 @@ async function showDocumentDetails(documentId) {
                         </div>
                         <p class="transaction-description">${escapeHtml(tx.description)}</p>
                         ${tx.llm_description ? `<p class="transaction-llm-desc">${escapeHtml(tx.llm_description)}</p>` : ''}
+                        ${formatItems(tx.items)}
                         <p class="transaction-date">${formatDate(tx.date)}</p>
                     </div>
                 `).join('')}
@@ -547,12 +701,15 @@ async function showDocumentDetails(documentId) {
                     <div class="recommendation-item">
                         <h4>${escapeHtml(title)}</h4>
                         <p>${escapeHtml(description)}</p>
+                        ${formatCoveredTransactions(rec)}
                         ${savings > 0 ? `
                             <p class="recommendation-savings">
                                 💰 Потенциальная экономия: ${savings.toFixed(2)} руб
+                                ${rec.savings_basis ? `<span class="savings-basis">(${escapeHtml(rec.savings_basis)})</span>` : ''}
                             </p>
                         ` : ''}
-                        <span class="recommendation-source">Источник: ${escapeHtml(source)}</span>
+                        <span class="recommendation-source">Источник: ${escapeHtml(getSourceName(source))}</span>
+                        ${formatCitations(rec.citations)}
                     </div>
                 `;
                 }).join('')}
@@ -609,19 +766,8 @@ async function showRecommendations(documentId) {
         document.body.appendChild(loadingModal);
         
         try {
-            const response = await apiCall(`/api/v1/documents/${documentId}/process`, {
-                method: 'POST',
-            });
-            
-            if (response.ok) {
-                const data = await response.json();
-                processedData = {
-                    transactions: data.transactions || [],
-                    recommendations: data.recommendations || [],
-                    processedAt: new Date().toISOString()
-                };
-                processedDocuments[documentId] = processedData;
-            }
+            processedData = await fetchDocumentResults(documentId);
+            processedDocuments[documentId] = processedData;
         } catch (error) {
             console.error('Error fetching recommendations:', error);
             showNotification('Ошибка загрузки рекомендаций', 'error');
@@ -683,10 +829,12 @@ async function showRecommendations(documentId) {
                                 <div class="recommendation-content">
                                     <h4>${escapeHtml(title)}</h4>
                                     <p class="recommendation-description">${escapeHtml(description)}</p>
+                                    ${formatCoveredTransactions(rec)}
                                     ${savings > 0 ? `
                                         <div class="recommendation-savings">
                                             <span class="savings-icon">💰</span>
                                             <span class="savings-amount">Экономия: ${savings.toFixed(2)} руб</span>
+                                            ${rec.savings_basis ? `<span class="savings-basis">(${escapeHtml(rec.savings_basis)})</span>` : ''}
                                         </div>
                                     ` : ''}
                                     <div class="recommendation-footer">
@@ -694,6 +842,7 @@ async function showRecommendations(documentId) {
                                             <span class="source-icon">📚</span>
                                             ${escapeHtml(sourceName)}
                                         </span>
+                                        ${formatCitations(rec.citations)}
                                     </div>
                                 </div>
                             </div>
@@ -723,6 +872,56 @@ function getSourceName(source) {
     return sourceMap[source] || source;
 }
 
+// Render knowledge base entries cited by a recommendation as [n]
+// Render receipt line items of a transaction
+function formatItems(items) {
+    if (!items || items.length === 0) {
+        return '';
+    }
+
+    return `
+        <ul class="transaction-items">
+            ${items.map(item => `
+                <li>
+                    <span class="item-name">${escapeHtml(item.name)}</span>
+                    <span class="item-amount">${item.quantity} × ${item.unit_price.toFixed(2)} = ${item.total.toFixed(2)}</span>
+                    <span class="item-category">${escapeHtml(item.category)}</span>
+                </li>
+            `).join('')}
+        </ul>
+    `;
+}
+
+// Show how many transactions a document-level recommendation covers
+function formatCoveredTransactions(rec) {
+    const count = rec.transaction_ids ? rec.transaction_ids.length : 0;
+    if (count < 2) {
+        return '';
+    }
+    return `<p class="recommendation-transactions">Относится к операциям: ${count}</p>`;
+}
+
+function formatCitations(citations) {
+    if (!citations || citations.length === 0) {
+        return '';
+    }
+
+    return `
+        <ul class="recommendation-citations">
+            ${citations.map(c => {
+                let pages = '';
+                if (c.page_start) {
+                    pages = c.page_end && c.page_end > c.page_start
+                        ? `, стр. ${c.page_start}–${c.page_end}`
+                        : `, стр. ${c.page_start}`;
+                }
+                const file = c.source_file ? ` (${escapeHtml(c.source_file)})` : '';
+                return `<li>[${c.number}] ${escapeHtml(c.title)}${pages}${file} — ${escapeHtml(getSourceName(c.type))}</li>`;
+            }).join('')}
+        </ul>
+    `;
+}
+
 // Close modal
 function closeModal() {
     const documentModal = document.getElementById('documentModal');